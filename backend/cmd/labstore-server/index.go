@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/helper"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/object"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func NewIndexCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "index",
+		Short: "Manage the object index",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rebuild",
+		Short: "Rebuild the object index from the objects in storage, while the server is stopped",
+		Run: func(cmd *cobra.Command, args []string) {
+			helper.CheckFatal(storage.Init())
+			helper.CheckFatal(index.Init())
+
+			helper.CheckFatal(object.RebuildIndex())
+
+			// Blob references were rebuilt as well, which can leave blobs behind
+			_, err := blob.GC(context.Background())
+			helper.CheckFatal(err)
+
+			helper.CheckFatal(index.Close())
+			helper.CheckFatal(storage.Close())
+		},
+	})
+
+	return cmd
+}
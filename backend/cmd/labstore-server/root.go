@@ -27,6 +27,7 @@ func NewRootCmd() *cobra.Command {
 	cmd.PersistentFlags().Bool("debug", false, "Set debug level for logging")
 
 	cmd.AddCommand(NewServeCmd())
+	cmd.AddCommand(NewIndexCmd())
 
 	return cmd
 }
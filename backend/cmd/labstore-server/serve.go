@@ -1,22 +1,92 @@
 package main
 
 import (
-	log "github.com/sirupsen/logrus"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/helper"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
 	"github.com/DataLabTechTV/labstore/backend/internal/router"
+	"github.com/DataLabTechTV/labstore/backend/internal/seed"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+}
+
+// parseSize reads a size in bytes, with an optional unit (e.g., 512MiB).
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+
+	if i == -1 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+
+	if err != nil || !ok {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return n * unit, nil
+}
+
 func NewServeCmd() *cobra.Command {
 	var cmd = &cobra.Command{
 		Use:   "serve",
 		Short: "Start S3-compatible Lab Store server",
 		Run: func(cmd *cobra.Command, args []string) {
 			log.Infof("Welcome to %s, by %s", config.Name, config.Author)
+
+			ephemeral := helper.Must(cmd.Flags().GetBool("ephemeral"))
+			seedPath := helper.Must(cmd.Flags().GetString("seed"))
+
+			if ephemeral {
+				config.Env.StorageDriver = "memory"
+
+				if cmd.Flags().Changed("max-size") {
+					maxSize := helper.Must(cmd.Flags().GetString("max-size"))
+					config.Env.MemoryMaxSize = helper.Must(parseSize(maxSize))
+				}
+
+				log.Infof("Ephemeral mode, keeping up to %d bytes in memory", config.Env.MemoryMaxSize)
+			}
+
+			helper.CheckFatal(storage.Init())
+			helper.CheckFatal(index.Init())
+
+			if seedPath != "" {
+				helper.CheckFatal(seed.Load(seedPath))
+			}
+
 			router.Start()
+
+			helper.CheckFatal(index.Close())
+			helper.CheckFatal(storage.Close())
+
+			if ephemeral {
+				log.Info("Wiped ephemeral storage")
+			}
 		},
 	}
 
+	cmd.Flags().Bool("ephemeral", false, "Keep all data in memory, and wipe it on exit")
+	cmd.Flags().String("max-size", "1GiB", "Maximum size of the data kept in memory, in ephemeral mode")
+	cmd.Flags().String("seed", "", "Fixtures directory or YAML manifest to seed buckets, objects, users and policies from")
+
 	return cmd
 }
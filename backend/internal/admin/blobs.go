@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBlobStatsHandler: GET /_admin/v1/blobs
+func GetBlobStatsHandler(c *fiber.Ctx) error {
+	stats, err := blob.GetStats()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(stats)
+}
+
+// CollectBlobsHandler: POST /_admin/v1/blobs/gc
+func CollectBlobsHandler(c *fiber.Ctx) error {
+	res, err := blob.GC(c.UserContext())
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(res)
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBucketChunkingHandler: GET /_admin/v1/buckets/:bucket/chunking
+func GetBucketChunkingHandler(c *fiber.Ctx) error {
+	conf, err := bucket.GetBucketChunking(c.Params("bucket"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(conf)
+}
+
+// PutBucketChunkingHandler: PUT /_admin/v1/buckets/:bucket/chunking
+func PutBucketChunkingHandler(c *fiber.Ctx) error {
+	var conf bucket.ChunkingConfiguration
+
+	if err := json.Unmarshal(c.Body(), &conf); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := bucket.PutBucketChunking(c.Params("bucket"), &conf); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(conf)
+}
+
+// DeleteBucketChunkingHandler: DELETE /_admin/v1/buckets/:bucket/chunking
+func DeleteBucketChunkingHandler(c *fiber.Ctx) error {
+	if err := bucket.DeleteBucketChunking(c.Params("bucket")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
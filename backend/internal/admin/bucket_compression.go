@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBucketCompressionHandler: GET /_admin/v1/buckets/:bucket/compression
+func GetBucketCompressionHandler(c *fiber.Ctx) error {
+	conf, err := bucket.GetBucketCompression(c.Params("bucket"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(conf)
+}
+
+// PutBucketCompressionHandler: PUT /_admin/v1/buckets/:bucket/compression
+func PutBucketCompressionHandler(c *fiber.Ctx) error {
+	var conf bucket.CompressionConfiguration
+
+	if err := json.Unmarshal(c.Body(), &conf); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := bucket.PutBucketCompression(c.Params("bucket"), &conf); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(conf)
+}
+
+// DeleteBucketCompressionHandler: DELETE /_admin/v1/buckets/:bucket/compression
+func DeleteBucketCompressionHandler(c *fiber.Ctx) error {
+	if err := bucket.DeleteBucketCompression(c.Params("bucket")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
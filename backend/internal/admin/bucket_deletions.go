@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorNoSuchDeletion() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchDeletion",
+		Message:    "The specified bucket deletion does not exist",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// ForceDeleteBucketHandler: DELETE /_admin/v1/buckets/:bucket
+func ForceDeleteBucketHandler(c *fiber.Ctx) error {
+	job, err := bucket.ForceDeleteBucket(c.Params("bucket"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// ListBucketDeletionsHandler: GET /_admin/v1/bucket-deletions
+func ListBucketDeletionsHandler(c *fiber.Ctx) error {
+	return c.JSON(bucket.ListDeletions())
+}
+
+// GetBucketDeletionHandler: GET /_admin/v1/bucket-deletions/:id
+func GetBucketDeletionHandler(c *fiber.Ctx) error {
+	job, ok := bucket.GetDeletion(c.Params("id"))
+	if !ok {
+		err := ErrorNoSuchDeletion()
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(job)
+}
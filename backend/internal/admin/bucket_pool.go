@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorNoSuchBucketPool() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchBucketPool",
+		Message:    "The bucket is not pinned to a storage pool",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// bucketPool returns the pools backend, once the bucket is known to exist.
+func bucketPool(c *fiber.Ctx) (*storage.Pools, error) {
+	backend, err := poolsBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bucket.Lookup(c.Params("bucket")); err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+// GetBucketPoolHandler: GET /_admin/v1/buckets/:bucket/pool
+func GetBucketPoolHandler(c *fiber.Ctx) error {
+	backend, err := bucketPool(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	pin, ok := backend.Pinned(c.Params("bucket"))
+	if !ok {
+		err := ErrorNoSuchBucketPool()
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(pin)
+}
+
+// PutBucketPoolHandler: PUT /_admin/v1/buckets/:bucket/pool
+func PutBucketPoolHandler(c *fiber.Ctx) error {
+	backend, err := bucketPool(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	var req storage.PoolPin
+
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	pin, err := backend.Pin(c.Params("bucket"), req.Pool)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(pin)
+}
+
+// DeleteBucketPoolHandler: DELETE /_admin/v1/buckets/:bucket/pool
+func DeleteBucketPoolHandler(c *fiber.Ctx) error {
+	backend, err := bucketPool(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := backend.Unpin(c.Params("bucket")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
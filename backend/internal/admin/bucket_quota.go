@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBucketQuotaHandler: GET /_admin/v1/buckets/:bucket/quota
+func GetBucketQuotaHandler(c *fiber.Ctx) error {
+	quota, err := bucket.GetBucketQuota(c.Params("bucket"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(quota)
+}
+
+// PutBucketQuotaHandler: PUT /_admin/v1/buckets/:bucket/quota
+func PutBucketQuotaHandler(c *fiber.Ctx) error {
+	var quota bucket.Quota
+
+	if err := json.Unmarshal(c.Body(), &quota); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := bucket.PutBucketQuota(c.Params("bucket"), &quota); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(quota)
+}
+
+// DeleteBucketQuotaHandler: DELETE /_admin/v1/buckets/:bucket/quota
+func DeleteBucketQuotaHandler(c *fiber.Ctx) error {
+	if err := bucket.DeleteBucketQuota(c.Params("bucket")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
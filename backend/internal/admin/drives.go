@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func erasureBackend() (*storage.Erasure, error) {
+	backend, ok := storage.Default.(*storage.Erasure)
+	if !ok {
+		return nil, core.ErrorInvalidRequest("The storage driver is not erasure coded")
+	}
+
+	return backend, nil
+}
+
+// GetDrivesHandler: GET /_admin/v1/drives
+func GetDrivesHandler(c *fiber.Ctx) error {
+	backend, err := erasureBackend()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(backend.Status())
+}
+
+// HealDrivesHandler: POST /_admin/v1/drives/heal
+func HealDrivesHandler(c *fiber.Ctx) error {
+	backend, err := erasureBackend()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	res, err := backend.Heal(c.UserContext(), nil)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(res)
+}
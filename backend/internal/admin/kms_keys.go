@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/kms"
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateKeyRequest struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// CreateKeyHandler: POST /_admin/v1/kms/keys
+func CreateKeyHandler(c *fiber.Ctx) error {
+	var req CreateKeyRequest
+
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	key, err := kms.CreateKey(req.ID, req.Description)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(key)
+}
+
+// ListKeysHandler: GET /_admin/v1/kms/keys
+func ListKeysHandler(c *fiber.Ctx) error {
+	keys, err := kms.ListKeys()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(keys)
+}
+
+// GetKeyHandler: GET /_admin/v1/kms/keys/:id
+func GetKeyHandler(c *fiber.Ctx) error {
+	key, err := kms.DescribeKey(c.Params("id"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(key)
+}
+
+// RotateKeyHandler: POST /_admin/v1/kms/keys/:id/rotate
+func RotateKeyHandler(c *fiber.Ctx) error {
+	key, err := kms.RotateKey(c.Params("id"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(key)
+}
+
+// DisableKeyHandler: POST /_admin/v1/kms/keys/:id/disable
+func DisableKeyHandler(c *fiber.Ctx) error {
+	key, err := kms.DisableKey(c.Params("id"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(key)
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"strconv"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func poolsBackend() (*storage.Pools, error) {
+	backend, ok := storage.Default.(*storage.Pools)
+	if !ok {
+		return nil, core.ErrorInvalidRequest("The storage driver does not use pools")
+	}
+
+	return backend, nil
+}
+
+func poolParam(c *fiber.Ctx) (int, error) {
+	pool, err := strconv.Atoi(c.Params("pool"))
+	if err != nil {
+		return 0, core.ErrorInvalidArgument("The pool must be given by its index")
+	}
+
+	return pool, nil
+}
+
+// GetPoolsHandler: GET /_admin/v1/pools
+func GetPoolsHandler(c *fiber.Ctx) error {
+	backend, err := poolsBackend()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(backend.Status())
+}
+
+// DrainPoolHandler: POST /_admin/v1/pools/:pool/drain
+func DrainPoolHandler(c *fiber.Ctx) error {
+	backend, err := poolsBackend()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	pool, err := poolParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	status, err := backend.Drain(pool)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(status)
+}
+
+// CancelPoolDrainHandler: DELETE /_admin/v1/pools/:pool/drain
+func CancelPoolDrainHandler(c *fiber.Ctx) error {
+	backend, err := poolsBackend()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	pool, err := poolParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := backend.CancelDrain(pool); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/object"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetScrubStatusHandler: GET /_admin/v1/scrub
+func GetScrubStatusHandler(c *fiber.Ctx) error {
+	return c.JSON(object.GetScrubStatus())
+}
+
+// StartScrubHandler: POST /_admin/v1/scrub
+func StartScrubHandler(c *fiber.Ctx) error {
+	status, err := object.Scrub()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(status)
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/object"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetUsageHandler: GET /_admin/v1/usage
+func GetUsageHandler(c *fiber.Ctx) error {
+	usages, err := object.StorageUsage()
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(usages)
+}
+
+// GetBucketUsageHandler: GET /_admin/v1/buckets/:bucket/usage
+func GetBucketUsageHandler(c *fiber.Ctx) error {
+	usage, err := object.BucketUsage(c.Params("bucket"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(usage)
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"encoding/json"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/object"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetUserQuotaHandler: GET /_admin/v1/users/:user/quota
+func GetUserQuotaHandler(c *fiber.Ctx) error {
+	quota, err := bucket.GetUserQuota(c.Params("user"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(quota)
+}
+
+// PutUserQuotaHandler: PUT /_admin/v1/users/:user/quota
+func PutUserQuotaHandler(c *fiber.Ctx) error {
+	var quota bucket.Quota
+
+	if err := json.Unmarshal(c.Body(), &quota); err != nil {
+		err := core.ErrorInvalidRequest("The request body must be a JSON object")
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := bucket.PutUserQuota(c.Params("user"), &quota); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(quota)
+}
+
+// DeleteUserQuotaHandler: DELETE /_admin/v1/users/:user/quota
+func DeleteUserQuotaHandler(c *fiber.Ctx) error {
+	if err := bucket.DeleteUserQuota(c.Params("user")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
+
+// GetUserUsageHandler: GET /_admin/v1/users/:user/usage
+func GetUserUsageHandler(c *fiber.Ctx) error {
+	usage, err := object.GetUserUsage(c.Params("user"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.JSON(usage)
+}
@@ -8,16 +8,32 @@ import (
 	"fmt"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 )
 
-const unsignedPayload = "UNSIGNED-PAYLOAD"
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+	maxPresignedTTL = 7 * 24 * time.Hour
+)
+
+// IsPresigned checks whether the request carries SigV4 query string
+// authentication (i.e., a presigned URL) instead of an Authorization header.
+func IsPresigned(c *fiber.Ctx) bool {
+	return c.Query("X-Amz-Algorithm") != ""
+}
 
 func VerifyAWSSigV4(c *fiber.Ctx) (string, error) {
+	if IsPresigned(c) {
+		return verifyAWSSigV4Presigned(c)
+	}
+
 	auth := c.Get("Authorization")
 	logger.Log.Debug("Authorization: " + auth)
 
@@ -80,6 +96,98 @@ func VerifyAWSSigV4(c *fiber.Ctx) (string, error) {
 	credentialParts := strings.Split(credentials, "/")
 
 	accessKey := credentialParts[0]
+	scope := strings.Join(credentialParts[1:], "/")
+
+	timestamp := c.Get("X-Amz-Date")
+
+	return verifySignature(
+		c,
+		accessKey,
+		scope,
+		timestamp,
+		signedHeaders,
+		payloadHash,
+		signature,
+	)
+}
+
+func verifyAWSSigV4Presigned(c *fiber.Ctx) (string, error) {
+	algorithm := c.Query("X-Amz-Algorithm")
+	logger.Log.Debug("X-Amz-Algorithm: " + algorithm)
+
+	if algorithm != "AWS4-HMAC-SHA256" {
+		return "", errors.New("query parameter X-Amz-Algorithm must be AWS4-HMAC-SHA256")
+	}
+
+	credentials := c.Query("X-Amz-Credential")
+	if credentials == "" {
+		return "", errors.New("query parameter X-Amz-Credential is empty")
+	}
+
+	signedHeaders := strings.Split(c.Query("X-Amz-SignedHeaders"), ";")
+	if len(signedHeaders) == 0 || signedHeaders[0] == "" {
+		return "", errors.New("query parameter X-Amz-SignedHeaders is empty")
+	}
+
+	signature := c.Query("X-Amz-Signature")
+	if signature == "" {
+		return "", errors.New("query parameter X-Amz-Signature is empty")
+	}
+
+	logger.Log.Debug("Credentials: " + credentials)
+	logger.Log.Debug("SignedHeaders: " + strings.Join(signedHeaders, ";"))
+	logger.Log.Debug("Signature: " + signature)
+
+	// Check expiration
+
+	timestamp := c.Query("X-Amz-Date")
+
+	signedAt, err := time.Parse(amzDateFormat, timestamp)
+	if err != nil {
+		return "", errors.New("could not parse query parameter X-Amz-Date")
+	}
+
+	expiresSecs, err := strconv.Atoi(c.Query("X-Amz-Expires"))
+	if err != nil || expiresSecs < 1 {
+		return "", errors.New("query parameter X-Amz-Expires must be a positive integer")
+	}
+
+	expires := time.Duration(expiresSecs) * time.Second
+	if expires > maxPresignedTTL {
+		return "", errors.New("query parameter X-Amz-Expires must be at most 7 days")
+	}
+
+	if time.Now().After(signedAt.Add(expires)) {
+		return "", errors.New("presigned request has expired")
+	}
+
+	// Extract access key and scope
+
+	credentialParts := strings.Split(credentials, "/")
+
+	accessKey := credentialParts[0]
+	scope := strings.Join(credentialParts[1:], "/")
+
+	return verifySignature(
+		c,
+		accessKey,
+		scope,
+		timestamp,
+		signedHeaders,
+		unsignedPayload,
+		signature,
+	)
+}
+
+func verifySignature(
+	c *fiber.Ctx,
+	accessKey string,
+	scope string,
+	timestamp string,
+	signedHeaders []string,
+	payloadHash string,
+	signature string,
+) (string, error) {
 	logger.Log.Debug("Access key: " + accessKey)
 
 	secretKey, ok := iam.Users[accessKey]
@@ -87,7 +195,6 @@ func VerifyAWSSigV4(c *fiber.Ctx) (string, error) {
 		return "", fmt.Errorf("no secret key found for access key %s", accessKey)
 	}
 
-	scope := strings.Join(credentialParts[1:], "/")
 	logger.Log.Debug("Scope: " + scope)
 
 	// Compute signature
@@ -98,7 +205,6 @@ func VerifyAWSSigV4(c *fiber.Ctx) (string, error) {
 	}
 	logger.Log.Debug("Canonical request: " + canonicalRequest)
 
-	timestamp := c.Get("X-Amz-Date")
 	logger.Log.Debug("Timestamp: " + timestamp)
 
 	stringToSign := buildStringToSign(timestamp, scope, canonicalRequest)
@@ -199,6 +305,11 @@ func buildQueryString(rawQuery string) string {
 	keys := make([]string, 0, len(m))
 
 	for k := range m {
+		// The signature itself is never part of the signed query string
+		if k == "X-Amz-Signature" {
+			continue
+		}
+
 		keys = append(keys, k)
 	}
 
@@ -0,0 +1,309 @@
+// Package blob stores object data by content, under the SHA-256 of the stored
+// bytes, so that object versions with the same data share a single blob. The
+// references to each blob are counted in the object index, and a blob is
+// removed along with its last reference.
+package blob
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// Path returns where a blob is stored, spread over subdirectories by the first
+// byte of its hash.
+func Path(hash string) string {
+	return config.SystemPath("blobs", hash[:2], hash)
+}
+
+// Ref references a blob from an object version. New blobs are written to
+// staging first, and then moved into place, unless already stored.
+type Ref struct {
+	ID     string
+	Hash   string
+	Staged string
+}
+
+// Blobs being written are pinned, so that they are not removed while their
+// references are added. Pins are only added while holding the blob lock.
+var (
+	pinsMu sync.Mutex
+	pins   = map[string]int{}
+)
+
+func pinned(hash string) bool {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+
+	return pins[hash] > 0
+}
+
+// Pin keeps a blob from being removed until unpinned, and reports whether it
+// is already stored, in which case its data does not need to be staged. This
+// is what lets data split into many blobs be referenced all at once.
+func Pin(ctx context.Context, hash string) (bool, func(), error) {
+	unlock, err := lock.Lock(ctx, lock.Blob(hash))
+	if err != nil {
+		return false, nil, err
+	}
+	defer unlock()
+
+	pinsMu.Lock()
+	pins[hash]++
+	pinsMu.Unlock()
+
+	var once sync.Once
+
+	unpin := func() {
+		once.Do(func() {
+			pinsMu.Lock()
+			defer pinsMu.Unlock()
+
+			if pins[hash]--; pins[hash] == 0 {
+				delete(pins, hash)
+			}
+		})
+	}
+
+	_, err = storage.Stat(Path(hash))
+	if err != nil && !storage.IsNotExist(err) {
+		unpin()
+		return false, nil, err
+	}
+
+	return err == nil, unpin, nil
+}
+
+func lockAll(ctx context.Context, refs []Ref) (func(), error) {
+	resources := make([]lock.Resource, len(refs))
+
+	for i, ref := range refs {
+		resources[i] = lock.Blob(ref.Hash)
+	}
+
+	return lock.Lock(ctx, resources...)
+}
+
+func indexRefs(refs []Ref) []index.Ref {
+	out := make([]index.Ref, len(refs))
+
+	for i, ref := range refs {
+		out[i] = index.Ref{ID: ref.ID, Hash: ref.Hash}
+	}
+
+	return out
+}
+
+// Put moves staged data into the blob store, discarding it for blobs that
+// are already stored, and then references all blobs from an object version.
+// References without staged data require their blob to be stored, which is
+// how objects are copied without copying their data. All blobs are locked
+// until referenced, so they cannot be removed in between.
+func Put(ctx context.Context, bucket string, refs []Ref) error {
+	unlock, err := lockAll(ctx, refs)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored := map[string]bool{}
+
+	for _, ref := range refs {
+		if stored[ref.Hash] {
+			if ref.Staged != "" {
+				storage.Delete(ref.Staged)
+			}
+
+			continue
+		}
+
+		_, err := storage.Stat(Path(ref.Hash))
+
+		switch {
+		case err == nil:
+			if ref.Staged != "" {
+				storage.Delete(ref.Staged)
+			}
+		case storage.IsNotExist(err) && ref.Staged != "":
+			if err := storage.Rename(ref.Staged, Path(ref.Hash)); err != nil {
+				return fmt.Errorf("could not store blob: %w", err)
+			}
+		case storage.IsNotExist(err):
+			return fmt.Errorf("could not find blob %s: %w", ref.Hash, err)
+		default:
+			return err
+		}
+
+		stored[ref.Hash] = true
+	}
+
+	if err := index.Default.AddRefs(bucket, indexRefs(refs)); err != nil {
+		return fmt.Errorf("could not reference blobs: %w", err)
+	}
+
+	return nil
+}
+
+// Release removes the references to blobs from an object version, and then
+// the blobs that are no longer referenced.
+func Release(ctx context.Context, bucket string, refs []Ref) error {
+	unlock, err := lockAll(ctx, refs)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := index.Default.RemoveRefs(bucket, indexRefs(refs)); err != nil {
+		return fmt.Errorf("could not release blobs: %w", err)
+	}
+
+	for _, ref := range refs {
+		if _, err := removeUnreferenced(ref.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropBucket releases all blobs referenced from a bucket, when it is deleted.
+func DropBucket(ctx context.Context, bucket string) error {
+	hashes, err := index.Default.DropRefs(bucket)
+	if err != nil {
+		return fmt.Errorf("could not release blobs: %w", err)
+	}
+
+	for _, hash := range hashes {
+		if _, err := collect(ctx, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeUnreferenced removes a blob without references, unless pinned,
+// returning its size, which must be done while holding its lock.
+func removeUnreferenced(hash string) (int64, error) {
+	refs, err := index.Default.Refs(hash)
+	if err != nil || refs > 0 || pinned(hash) {
+		return 0, err
+	}
+
+	info, err := storage.Stat(Path(hash))
+	if storage.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := storage.Delete(Path(hash)); err != nil && !storage.IsNotExist(err) {
+		return 0, fmt.Errorf("could not remove blob: %w", err)
+	}
+
+	return info.Size, nil
+}
+
+func collect(ctx context.Context, hash string) (int64, error) {
+	unlock, err := lock.Lock(ctx, lock.Blob(hash))
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return removeUnreferenced(hash)
+}
+
+// walk calls fn for every stored blob, with its hash and size.
+func walk(fn func(hash string, size int64) error) error {
+	return storage.Walk(config.SystemPath("blobs"), func(name string, info *storage.Info) error {
+		return fn(path.Base(name), info.Size)
+	})
+}
+
+type GCResult struct {
+	Blobs          int64 `json:"blobs"`
+	Removed        int64 `json:"removed"`
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// GC removes the blobs left without references, which only happens when the
+// server stopped while writing or deleting an object. Each blob is checked
+// while locked, so blobs being referenced by concurrent writes are kept.
+func GC(ctx context.Context) (*GCResult, error) {
+	var hashes []string
+
+	err := walk(func(hash string, _ int64) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list blobs: %w", err)
+	}
+
+	res := &GCResult{Blobs: int64(len(hashes))}
+
+	for _, hash := range hashes {
+		size, err := collect(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if size > 0 {
+			res.Removed++
+			res.ReclaimedBytes += size
+		}
+	}
+
+	if res.Removed > 0 {
+		logger.Log.Infof("Removed %d unreferenced blobs (%d bytes)", res.Removed, res.ReclaimedBytes)
+	}
+
+	return res, nil
+}
+
+// Stats reports the space saved by deduplication, as the bytes that would be
+// stored if every reference had its own copy of the blob.
+type Stats struct {
+	Blobs        int64 `json:"blobs"`
+	References   int64 `json:"references"`
+	StoredBytes  int64 `json:"storedBytes"`
+	SavedBytes   int64 `json:"savedBytes"`
+	Unreferenced int64 `json:"unreferenced"`
+}
+
+func GetStats() (*Stats, error) {
+	refs, err := index.Default.AllRefs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob references: %w", err)
+	}
+
+	stats := &Stats{}
+
+	err = walk(func(hash string, size int64) error {
+		stats.Blobs++
+		stats.StoredBytes += size
+
+		if n := refs[hash]; n > 0 {
+			stats.References += n
+			stats.SavedBytes += (n - 1) * size
+		} else {
+			stats.Unreferenced++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list blobs: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,121 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+func quarantinePath(elem ...string) string {
+	return config.SystemPath(append([]string{"quarantine"}, elem...)...)
+}
+
+// Verify reports whether a blob still hashes to its name. Reads are limited by
+// throttle, when not nil. Missing blobs fail with a not exist error.
+func Verify(ctx context.Context, hash string, throttle *storage.Throttle) (bool, error) {
+	unlock, err := lock.Lock(ctx, lock.Blob(hash))
+	if err != nil {
+		return false, err
+	}
+
+	// The blob stays readable once open, even if removed
+	f, err := storage.Default.Get(ctx, Path(hash))
+	unlock()
+
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, throttle.Reader(ctx, f)); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == hash, nil
+}
+
+// Quarantine moves a corrupt blob out of the blob store, so that it is stored
+// again, from a good copy, by the next write with the same data. The corrupt
+// blob is kept until then, for inspection.
+func Quarantine(ctx context.Context, hash string) error {
+	unlock, err := lock.Lock(ctx, lock.Blob(hash))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := storage.Rename(Path(hash), quarantinePath(hash)); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not quarantine blob: %w", err)
+	}
+
+	logger.Log.Warnf("Quarantined corrupt blob %s", hash)
+
+	return nil
+}
+
+// PruneQuarantine removes quarantined blobs that were stored again, or that are
+// no longer referenced, returning how many were removed.
+func PruneQuarantine(ctx context.Context) (int64, error) {
+	entries, err := storage.List(quarantinePath())
+	if storage.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not list quarantined blobs: %w", err)
+	}
+
+	var removed int64
+
+	for _, e := range entries {
+		ok, err := pruneQuarantined(ctx, path.Base(e.Name))
+		if err != nil {
+			return removed, err
+		}
+
+		if ok {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+func pruneQuarantined(ctx context.Context, hash string) (bool, error) {
+	unlock, err := lock.Lock(ctx, lock.Blob(hash))
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	refs, err := index.Default.Refs(hash)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = storage.Stat(Path(hash))
+	if err != nil && !storage.IsNotExist(err) {
+		return false, err
+	}
+
+	// Kept while still referenced, until stored again
+	if refs > 0 && err != nil {
+		return false, nil
+	}
+
+	if err := storage.Delete(quarantinePath(hash)); err != nil && !storage.IsNotExist(err) {
+		return false, fmt.Errorf("could not remove quarantined blob: %w", err)
+	}
+
+	return true, nil
+}
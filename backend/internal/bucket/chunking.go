@@ -0,0 +1,125 @@
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/cdc"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorNoSuchChunkingConfiguration() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchChunkingConfiguration",
+		Message:    "The bucket does not have a chunking configuration",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// ChunkingConfiguration enables content-defined chunking for new objects,
+// with the given chunk sizes, in bytes. When only the average size is given,
+// the minimum and maximum sizes are a quarter and four times that.
+type ChunkingConfiguration struct {
+	MinSize     int `json:"minSize"`
+	AverageSize int `json:"averageSize"`
+	MaxSize     int `json:"maxSize"`
+}
+
+func (conf *ChunkingConfiguration) Params() cdc.Params {
+	return cdc.Params{MinSize: conf.MinSize, AvgSize: conf.AverageSize, MaxSize: conf.MaxSize}
+}
+
+func chunkingPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "chunking.json")
+}
+
+func GetBucketChunking(bucket string) (*ChunkingConfiguration, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.ReadFile(chunkingPath(bucket))
+	if storage.IsNotExist(err) {
+		return nil, ErrorNoSuchChunkingConfiguration()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket chunking: %w", err)
+	}
+
+	var conf ChunkingConfiguration
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("could not decode bucket chunking: %w", err)
+	}
+
+	return &conf, nil
+}
+
+func PutBucketChunking(bucket string, conf *ChunkingConfiguration) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if conf.AverageSize == 0 {
+		conf.AverageSize = cdc.DefaultAvgSize
+	}
+
+	if conf.MinSize == 0 {
+		conf.MinSize = conf.AverageSize / 4
+	}
+
+	if conf.MaxSize == 0 {
+		conf.MaxSize = conf.AverageSize * 4
+	}
+
+	if err := conf.Params().Validate(); err != nil {
+		return core.ErrorInvalidArgument("Invalid chunking configuration: " + err.Error())
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket chunking: %w", err)
+	}
+
+	if err := storage.WriteFile(chunkingPath(bucket), data); err != nil {
+		return fmt.Errorf("could not write bucket chunking: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteBucketChunking(bucket string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if err := storage.Delete(chunkingPath(bucket)); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete bucket chunking: %w", err)
+	}
+
+	return nil
+}
+
+// ChunkingParams returns the chunk sizes for new objects, or nil when they
+// are not chunked.
+func ChunkingParams(bucket string) (*cdc.Params, error) {
+	conf, err := GetBucketChunking(bucket)
+
+	var s3Error *core.S3Error
+
+	if errors.As(err, &s3Error) && s3Error.Code == ErrorNoSuchChunkingConfiguration().Code {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	params := conf.Params()
+
+	return &params, nil
+}
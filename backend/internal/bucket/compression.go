@@ -0,0 +1,185 @@
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/compression"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Already compressed formats, which are never compressed again
+var (
+	compressedContentTypes = []string{
+		"image/*", "video/*", "audio/*",
+		"application/zip", "application/gzip", "application/x-gzip",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/vnd.rar", "application/zstd",
+		"application/x-zstd", "application/x-lz4", "application/x-snappy-framed",
+		"application/vnd.apache.parquet", "application/pdf",
+	}
+
+	compressedExtensions = []string{
+		".gz", ".tgz", ".bz2", ".xz", ".zst", ".lz4", ".sz", ".zip", ".7z", ".rar",
+		".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".heic",
+		".mp3", ".mp4", ".m4a", ".mkv", ".webm", ".ogg", ".flac",
+		".parquet", ".orc", ".avro", ".pdf", ".docx", ".xlsx", ".pptx", ".jar",
+	}
+)
+
+func ErrorNoSuchCompressionConfiguration() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchCompressionConfiguration",
+		Message:    "The bucket does not have a compression configuration",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// CompressionConfiguration selects the objects to compress on upload, by
+// content type (e.g., text/* or application/json) or by key extension (e.g.,
+// .csv). When both are empty, all objects are compressed, except for already
+// compressed formats.
+type CompressionConfiguration struct {
+	Algorithm    string   `json:"algorithm"`
+	ContentTypes []string `json:"contentTypes,omitempty"`
+	Extensions   []string `json:"extensions,omitempty"`
+}
+
+func compressionPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "compression.json")
+}
+
+func GetBucketCompression(bucket string) (*CompressionConfiguration, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.ReadFile(compressionPath(bucket))
+	if storage.IsNotExist(err) {
+		return nil, ErrorNoSuchCompressionConfiguration()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket compression: %w", err)
+	}
+
+	var conf CompressionConfiguration
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("could not decode bucket compression: %w", err)
+	}
+
+	return &conf, nil
+}
+
+func PutBucketCompression(bucket string, conf *CompressionConfiguration) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if !compression.Supported(conf.Algorithm) {
+		return core.ErrorInvalidArgument("The compression algorithm must be zstd or s2")
+	}
+
+	for i, ext := range conf.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			conf.Extensions[i] = "." + ext
+		}
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket compression: %w", err)
+	}
+
+	if err := storage.WriteFile(compressionPath(bucket), data); err != nil {
+		return fmt.Errorf("could not write bucket compression: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteBucketCompression(bucket string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if err := storage.Delete(compressionPath(bucket)); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete bucket compression: %w", err)
+	}
+
+	return nil
+}
+
+// CompressionAlgorithm returns the algorithm to compress a new object with,
+// or an empty string when the object should be stored as-is.
+func CompressionAlgorithm(bucket, key, contentType string) (string, error) {
+	conf, err := GetBucketCompression(bucket)
+
+	var s3Error *core.S3Error
+
+	if errors.As(err, &s3Error) && s3Error.Code == ErrorNoSuchCompressionConfiguration().Code {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	contentType, _, _ = strings.Cut(strings.ToLower(contentType), ";")
+	contentType = strings.TrimSpace(contentType)
+	ext := strings.ToLower(path.Ext(key))
+
+	if matchContentType(compressedContentTypes, contentType) || matchExtension(compressedExtensions, ext) {
+		return "", nil
+	}
+
+	if len(conf.ContentTypes) == 0 && len(conf.Extensions) == 0 {
+		return conf.Algorithm, nil
+	}
+
+	if matchContentType(conf.ContentTypes, contentType) || matchExtension(conf.Extensions, ext) {
+		return conf.Algorithm, nil
+	}
+
+	return "", nil
+}
+
+func matchContentType(patterns []string, contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if p == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchExtension(extensions []string, ext string) bool {
+	if ext == "" {
+		return false
+	}
+
+	for _, e := range extensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
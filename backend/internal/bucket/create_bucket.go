@@ -1,46 +1,186 @@
 package bucket
 
 import (
+	"context"
+	"encoding/xml"
 	"fmt"
-	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/DataLabTechTV/labstore/backend/internal/config"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
 	"github.com/gofiber/fiber/v2"
 )
 
+var (
+	cannedACLs       = []string{"private", "public-read", "public-read-write", "authenticated-read"}
+	objectOwnerships = []string{"BucketOwnerEnforced", "BucketOwnerPreferred", "ObjectWriter"}
+)
+
 func ErrorBucketAlreadyExists() *core.S3Error {
 	return &core.S3Error{
 		Code:       "BucketAlreadyExists",
-		Message:    "Could not create bucket, because it already exists",
+		Message:    "The requested bucket name is not available",
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+func ErrorBucketAlreadyOwnedByYou() *core.S3Error {
+	return &core.S3Error{
+		Code:       "BucketAlreadyOwnedByYou",
+		Message:    "The bucket you tried to create already exists, and you own it",
 		StatusCode: fiber.StatusConflict,
 	}
 }
 
-func CreateBucket(bucket string) error {
-	path := filepath.Join(config.Env.StorageRoot, bucket)
+func ErrorIllegalLocationConstraint(region string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "IllegalLocationConstraintException",
+		Message:    fmt.Sprintf("The %s location constraint is incompatible with this server's region", region),
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorInvalidBucketAclWithObjectOwnership() *core.S3Error {
+	return &core.S3Error{
+		Code:       "InvalidBucketAclWithObjectOwnership",
+		Message:    "Bucket cannot have ACLs set with ObjectOwnership's BucketOwnerEnforced setting",
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+type CreateBucketConfiguration struct {
+	XMLName            xml.Name `xml:"CreateBucketConfiguration"`
+	LocationConstraint string
+}
+
+type CreateBucketOptions struct {
+	Owner             string
+	Region            string
+	ACL               string
+	ObjectOwnership   string
+	ObjectLockEnabled bool
+}
+
+func CreateBucket(bucket string, opts CreateBucketOptions) error {
+	unlock, err := lock.Lock(context.Background(), lock.Bucket(bucket, true))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+
+	if _, err := storage.Stat(bucket); !storage.IsNotExist(err) {
+		record, err := ReadRecord(bucket)
+		if err == nil && record.Owner == opts.Owner {
+			return ErrorBucketAlreadyOwnedByYou()
+		}
 
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		return ErrorBucketAlreadyExists()
 	}
 
-	if err := os.MkdirAll(path, 0755); err != nil {
+	if err := storage.Mkdir(bucket); err != nil {
 		return fmt.Errorf("could not create bucket: %w", err)
 	}
 
+	record := &Record{
+		Name:              bucket,
+		CreationDate:      time.Now().UTC(),
+		Owner:             opts.Owner,
+		Region:            opts.Region,
+		ACL:               opts.ACL,
+		ObjectOwnership:   opts.ObjectOwnership,
+		ObjectLockEnabled: opts.ObjectLockEnabled,
+	}
+
+	if err := WriteRecord(record); err != nil {
+		storage.Delete(bucket)
+		return err
+	}
+
+	// Object lock requires versioning, which is enabled along with it
+	if opts.ObjectLockEnabled {
+		conf := &VersioningConfiguration{Status: VersioningEnabled}
+
+		if err := writeVersioning(bucket, conf); err != nil {
+			storage.Delete(bucket)
+			storage.DeleteAll(config.BucketSystemPath(bucket))
+			return err
+		}
+	}
+
 	return nil
 }
 
+// parseCreateBucketOptions reads the optional CreateBucketConfiguration body
+// and the x-amz-acl, x-amz-object-ownership and object lock headers.
+func parseCreateBucketOptions(c *fiber.Ctx) (CreateBucketOptions, error) {
+	opts := CreateBucketOptions{
+		Owner:           middleware.AccessKey(c),
+		Region:          config.Env.Region,
+		ACL:             c.Get("X-Amz-Acl", "private"),
+		ObjectOwnership: c.Get("X-Amz-Object-Ownership", DefaultObjectOwnership),
+	}
+
+	if body := c.Body(); len(body) > 0 {
+		var conf CreateBucketConfiguration
+
+		if err := xml.Unmarshal(body, &conf); err != nil {
+			return opts, core.ErrorMalformedXML()
+		}
+
+		if conf.LocationConstraint != "" && conf.LocationConstraint != opts.Region {
+			return opts, ErrorIllegalLocationConstraint(conf.LocationConstraint)
+		}
+	}
+
+	if !slices.Contains(cannedACLs, opts.ACL) {
+		return opts, core.ErrorInvalidArgument("Invalid canned ACL: " + opts.ACL)
+	}
+
+	if !slices.Contains(objectOwnerships, opts.ObjectOwnership) {
+		return opts, core.ErrorInvalidArgument("Invalid x-amz-object-ownership header: " + opts.ObjectOwnership)
+	}
+
+	if opts.ObjectOwnership == "BucketOwnerEnforced" && opts.ACL != "private" {
+		return opts, ErrorInvalidBucketAclWithObjectOwnership()
+	}
+
+	if value := c.Get("X-Amz-Bucket-Object-Lock-Enabled"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, core.ErrorInvalidArgument("Invalid x-amz-bucket-object-lock-enabled header: " + value)
+		}
+
+		opts.ObjectLockEnabled = enabled
+	}
+
+	return opts, nil
+}
+
 // CreateBucket: PUT /:bucket
 func PutBucketHandler(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 
-	if err := CreateBucket(bucket); err != nil {
+	opts, err := parseCreateBucketOptions(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := CreateBucket(bucket, opts); err != nil {
 		core.HandleError(c, err)
 		return err
 	}
 
+	c.Set(fiber.HeaderLocation, "/"+bucket)
 	c.Status(fiber.StatusOK)
 	return nil
 }
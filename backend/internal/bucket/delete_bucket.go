@@ -1,29 +1,129 @@
 package bucket
 
 import (
-	"os"
-	"path/filepath"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/DataLabTechTV/labstore/backend/internal/config"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
 	"github.com/gofiber/fiber/v2"
 )
 
-func DeleteBucket(bucket string) error {
-	path := filepath.Join(config.Env.StorageRoot, bucket)
+var errNotEmpty = errors.New("not empty")
 
-	err := os.Remove(path)
-	if err != nil {
-		return core.ErrorNoSuchBucket()
+func ErrorBucketNotEmpty() *core.S3Error {
+	return &core.S3Error{
+		Code:       "BucketNotEmpty",
+		Message:    "The bucket you tried to delete is not empty",
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+// hasFiles checks whether there is at least one file under a directory, as
+// deleted objects can leave empty parent directories behind.
+func hasFiles(dir string) (bool, error) {
+	err := storage.Walk(dir, func(string, *storage.Info) error {
+		return errNotEmpty
+	})
+
+	if errors.Is(err, errNotEmpty) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// checkEmpty returns BucketNotEmpty when objects or noncurrent versions
+// remain. Objects include inline objects and delete markers, which only have
+// metadata.
+func checkEmpty(bucket string) error {
+	for _, dir := range []string{
+		bucket,
+		config.BucketSystemPath(bucket, "objects"),
+		config.BucketSystemPath(bucket, "versions"),
+	} {
+		found, err := hasFiles(dir)
+		if err != nil {
+			return fmt.Errorf("could not check if bucket is empty: %w", err)
+		}
+
+		if found {
+			return ErrorBucketNotEmpty()
+		}
 	}
 
 	return nil
 }
 
+// unpin releases the storage pool a deleted bucket was pinned to, so that a
+// new bucket with the same name is not.
+func unpin(bucket string) error {
+	if pools, ok := storage.Default.(*storage.Pools); ok {
+		return pools.Unpin(bucket)
+	}
+
+	return nil
+}
+
+func DeleteBucket(bucket string) error {
+	unlock, err := lock.Lock(context.Background(), lock.Bucket(bucket, true))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if err := checkEmpty(bucket); err != nil {
+		return err
+	}
+
+	// Bucket configuration goes first, so that an interrupted deletion leaves
+	// a bucket without a record, which is still listed and can be retried
+	if err := storage.DeleteAll(config.BucketSystemPath(bucket)); err != nil {
+		return fmt.Errorf("could not remove bucket configuration: %w", err)
+	}
+
+	if err := storage.DeleteAll(bucket); err != nil {
+		return fmt.Errorf("could not remove bucket: %w", err)
+	}
+
+	if err := index.Default.DropBucket(bucket); err != nil {
+		return fmt.Errorf("could not remove bucket index: %w", err)
+	}
+
+	return unpin(bucket)
+}
+
 // DeleteBucketHandler: DELETE /:bucket
 func DeleteBucketHandler(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
-	if err := DeleteBucket(bucket); err != nil {
+
+	force, _ := strconv.ParseBool(c.Get("X-LabStore-Force-Delete"))
+
+	if force && !middleware.IsAdmin(c) {
+		err := core.ErrorAccessDenied()
+		core.HandleError(c, err)
+		return err
+	}
+
+	var err error
+
+	if force {
+		_, err = ForceDeleteBucket(bucket)
+	} else {
+		err = DeleteBucket(bucket)
+	}
+
+	if err != nil {
 		core.HandleError(c, err)
 		return err
 	}
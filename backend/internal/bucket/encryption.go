@@ -0,0 +1,177 @@
+package bucket
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorServerSideEncryptionConfigurationNotFound() *core.S3Error {
+	return &core.S3Error{
+		Code:       "ServerSideEncryptionConfigurationNotFoundError",
+		Message:    "The server side encryption configuration was not found",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+type ApplyServerSideEncryptionByDefault struct {
+	SSEAlgorithm   string `json:"sseAlgorithm"`
+	KMSMasterKeyID string `xml:",omitempty" json:"kmsMasterKeyId,omitempty"`
+}
+
+type ServerSideEncryptionRule struct {
+	ApplyServerSideEncryptionByDefault *ApplyServerSideEncryptionByDefault `json:"applyServerSideEncryptionByDefault"`
+	BucketKeyEnabled                   bool                                `json:"bucketKeyEnabled"`
+}
+
+type ServerSideEncryptionConfiguration struct {
+	XMLName xml.Name                   `xml:"ServerSideEncryptionConfiguration" json:"-"`
+	Rules   []ServerSideEncryptionRule `xml:"Rule" json:"rules"`
+}
+
+func encryptionPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "encryption.json")
+}
+
+func GetBucketEncryption(bucket string) (*ServerSideEncryptionConfiguration, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.ReadFile(encryptionPath(bucket))
+	if storage.IsNotExist(err) {
+		return nil, ErrorServerSideEncryptionConfigurationNotFound()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket encryption: %w", err)
+	}
+
+	var conf ServerSideEncryptionConfiguration
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("could not decode bucket encryption: %w", err)
+	}
+
+	return &conf, nil
+}
+
+// DefaultEncryption returns the default encryption for new objects, or nil
+// when the bucket has none.
+func DefaultEncryption(bucket string) (*ApplyServerSideEncryptionByDefault, error) {
+	conf, err := GetBucketEncryption(bucket)
+
+	var s3Error *core.S3Error
+
+	if errors.As(err, &s3Error) && s3Error.Code == ErrorServerSideEncryptionConfigurationNotFound().Code {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conf.Rules[0].ApplyServerSideEncryptionByDefault, nil
+}
+
+func PutBucketEncryption(bucket string, conf *ServerSideEncryptionConfiguration) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if len(conf.Rules) != 1 || conf.Rules[0].ApplyServerSideEncryptionByDefault == nil {
+		return core.ErrorMalformedXML()
+	}
+
+	def := conf.Rules[0].ApplyServerSideEncryptionByDefault
+
+	switch def.SSEAlgorithm {
+	case sse.AlgorithmAES256:
+		if def.KMSMasterKeyID != "" {
+			return core.ErrorInvalidArgument("a KMSMasterKeyID is not applicable if the default sse algorithm is not aws:kms")
+		}
+	case sse.AlgorithmKMS:
+	default:
+		return core.ErrorMalformedXML()
+	}
+
+	if err := sse.CheckRequest(&sse.Request{Algorithm: def.SSEAlgorithm, KMSKeyID: def.KMSMasterKeyID}); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket encryption: %w", err)
+	}
+
+	if err := storage.WriteFile(encryptionPath(bucket), data); err != nil {
+		return fmt.Errorf("could not write bucket encryption: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteBucketEncryption(bucket string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if err := storage.Delete(encryptionPath(bucket)); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete bucket encryption: %w", err)
+	}
+
+	return nil
+}
+
+// GetBucketEncryptionHandler: GET /:bucket?encryption
+func GetBucketEncryptionHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	res, err := GetBucketEncryption(bucket)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
+
+// PutBucketEncryptionHandler: PUT /:bucket?encryption
+func PutBucketEncryptionHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	var conf ServerSideEncryptionConfiguration
+
+	if err := xml.Unmarshal(c.Body(), &conf); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutBucketEncryption(bucket, &conf); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+// DeleteBucketEncryptionHandler: DELETE /:bucket?encryption
+func DeleteBucketEncryptionHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	if err := DeleteBucketEncryption(bucket); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
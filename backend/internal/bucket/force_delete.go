@@ -0,0 +1,303 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// DeletionJob tracks the background deletion of a force-deleted bucket.
+type DeletionJob struct {
+	ID         string     `json:"id"`
+	Bucket     string     `json:"bucket"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Total      int64      `json:"total"`
+	Deleted    int64      `json:"deleted"`
+	Error      string     `json:"error,omitempty"`
+
+	mu      sync.Mutex
+	deleted atomic.Int64
+}
+
+var (
+	deletionsMu sync.Mutex
+	deletions   = map[string]*DeletionJob{}
+)
+
+func trashPath(elem ...string) string {
+	return config.SystemPath(append([]string{"trash"}, elem...)...)
+}
+
+// ForceDeleteBucket detaches the bucket and its configuration by moving both
+// into the trash, data first, so the bucket is gone as soon as this returns,
+// or left as it was on failure, and then deletes all objects in the
+// background, returning a snapshot of the job. Buckets with object lock
+// enabled are refused, as this would bypass retention.
+func ForceDeleteBucket(bucket string) (*DeletionJob, error) {
+	unlock, err := lock.Lock(context.Background(), lock.Bucket(bucket, true))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.ObjectLockEnabled {
+		return nil, ErrorInvalidBucketState("Buckets with Object Lock enabled cannot be force deleted")
+	}
+
+	job := &DeletionJob{
+		ID:        uuid.NewString(),
+		Bucket:    bucket,
+		StartedAt: time.Now().UTC(),
+	}
+
+	if err := writeJobFile(job); err != nil {
+		return nil, err
+	}
+
+	if err := storage.Rename(bucket, trashPath(job.ID, "data")); err != nil {
+		storage.DeleteAll(trashPath(job.ID))
+		return nil, fmt.Errorf("could not detach bucket: %w", err)
+	}
+
+	// The bucket is gone once its data is detached, so the data is moved back
+	// when the configuration cannot be detached as well
+	if err := storage.Rename(config.BucketSystemPath(bucket), trashPath(job.ID, "config")); err != nil && !storage.IsNotExist(err) {
+		if err := storage.Rename(trashPath(job.ID, "data"), bucket); err != nil {
+			logger.Log.Errorf("Could not restore bucket %s after a failed force deletion: %s", bucket, err)
+		} else {
+			storage.DeleteAll(trashPath(job.ID))
+		}
+
+		return nil, fmt.Errorf("could not detach bucket configuration: %w", err)
+	}
+
+	if err := index.Default.DropBucket(bucket); err != nil {
+		return nil, fmt.Errorf("could not remove bucket index: %w", err)
+	}
+
+	if err := unpin(bucket); err != nil {
+		return nil, err
+	}
+
+	// Blobs are shared with other buckets, so they stay out of the trash
+	if err := blob.DropBucket(context.Background(), bucket); err != nil {
+		return nil, err
+	}
+
+	deletionsMu.Lock()
+	deletions[job.ID] = job
+	deletionsMu.Unlock()
+
+	go job.run()
+
+	// The job is updated by run from now on, so callers get a copy
+	return job.snapshot(), nil
+}
+
+// ResumeDeletions restarts force deletions interrupted by a server shutdown.
+func ResumeDeletions() {
+	entries, err := storage.List(trashPath())
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		data, err := storage.ReadFile(path.Join(e.Name, "job.json"))
+		if err != nil {
+			logger.Log.Warnf("Removing unknown trash entry %s", e.Name)
+			storage.DeleteAll(e.Name)
+			continue
+		}
+
+		job := &DeletionJob{}
+
+		if err := json.Unmarshal(data, job); err != nil {
+			logger.Log.Warnf("Removing trash entry %s with invalid job: %s", e.Name, err)
+			storage.DeleteAll(e.Name)
+			continue
+		}
+
+		logger.Log.Infof("Resuming deletion of bucket %s", job.Bucket)
+
+		deletionsMu.Lock()
+		deletions[job.ID] = job
+		deletionsMu.Unlock()
+
+		go job.run()
+	}
+}
+
+// ListDeletions returns a snapshot of all deletion jobs, newest first.
+func ListDeletions() []*DeletionJob {
+	deletionsMu.Lock()
+	defer deletionsMu.Unlock()
+
+	jobs := make([]*DeletionJob, 0, len(deletions))
+
+	for _, job := range deletions {
+		jobs = append(jobs, job.snapshot())
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+
+	return jobs
+}
+
+func GetDeletion(id string) (*DeletionJob, bool) {
+	deletionsMu.Lock()
+	defer deletionsMu.Unlock()
+
+	job, ok := deletions[id]
+	if !ok {
+		return nil, false
+	}
+
+	return job.snapshot(), true
+}
+
+// snapshot copies the job under its lock, so that it can be encoded while
+// run updates the job.
+func (j *DeletionJob) snapshot() *DeletionJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return &DeletionJob{
+		ID:         j.ID,
+		Bucket:     j.Bucket,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		Total:      j.Total,
+		Deleted:    j.deleted.Load(),
+		Error:      j.Error,
+	}
+}
+
+func (j *DeletionJob) run() {
+	root := trashPath(j.ID)
+	dataPath := path.Join(root, "data")
+	objectsPath := path.Join(root, "config", "objects")
+
+	// Objects are counted by their metadata, when they have it, as inline
+	// objects and delete markers have no data
+	hasMetadata := func(name string) bool {
+		key := strings.TrimPrefix(name, dataPath+"/")
+		_, err := storage.Stat(path.Join(objectsPath, key+".json"))
+		return err == nil
+	}
+
+	total, err := countFiles(objectsPath, nil)
+
+	if err == nil {
+		var withoutMetadata int64
+
+		withoutMetadata, err = countFiles(dataPath, func(name string) bool {
+			return !hasMetadata(name)
+		})
+
+		total += withoutMetadata
+	}
+
+	j.mu.Lock()
+	j.Total = total
+	j.mu.Unlock()
+
+	if err == nil {
+		err = storage.Walk(dataPath, func(name string, _ *storage.Info) error {
+			counted := !hasMetadata(name)
+
+			if err := storage.Delete(name); err != nil {
+				return err
+			}
+
+			if counted {
+				j.deleted.Add(1)
+			}
+
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = storage.Walk(objectsPath, func(name string, _ *storage.Info) error {
+			if err := storage.Delete(name); err != nil {
+				return err
+			}
+
+			j.deleted.Add(1)
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = storage.DeleteAll(root)
+	}
+
+	finishedAt := time.Now().UTC()
+
+	j.mu.Lock()
+	j.FinishedAt = &finishedAt
+
+	if err != nil {
+		j.Error = err.Error()
+	}
+
+	j.mu.Unlock()
+
+	if err != nil {
+		logger.Log.Errorf("Could not delete bucket %s: %s", j.Bucket, err)
+		return
+	}
+
+	logger.Log.Infof("Deleted bucket %s (%d objects)", j.Bucket, j.deleted.Load())
+}
+
+func writeJobFile(job *DeletionJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("could not encode deletion job: %w", err)
+	}
+
+	if err := storage.WriteFile(trashPath(job.ID, "job.json"), data); err != nil {
+		return fmt.Errorf("could not write deletion job: %w", err)
+	}
+
+	return nil
+}
+
+// countFiles counts the files under a directory, only including the ones
+// matching filter, when given.
+func countFiles(dir string, filter func(name string) bool) (int64, error) {
+	var count int64
+
+	err := storage.Walk(dir, func(name string, _ *storage.Info) error {
+		if filter == nil || filter(name) {
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,72 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// TestForceDeleteBucketProgress encodes the returned job while the deletion
+// runs, which the race detector checks.
+func TestForceDeleteBucketProgress(t *testing.T) {
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+	config.Load()
+
+	ix, err := index.Open(filepath.Join(t.TempDir(), "index.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	storage.Default = storage.NewMemory(0)
+	index.Default = ix
+
+	if err := CreateBucket("doomed-bucket", CreateBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 500 {
+		if err := storage.WriteFile(fmt.Sprintf("doomed-bucket/key-%d", i), []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := ForceDeleteBucket("doomed-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		if _, err := json.Marshal(job); err != nil {
+			t.Fatal(err)
+		}
+
+		status, ok := GetDeletion(job.ID)
+		if !ok {
+			t.Fatalf("deletion %s not found", job.ID)
+		}
+
+		if status.FinishedAt != nil {
+			if status.Error != "" || status.Deleted != 500 {
+				t.Errorf("deletion finished with %d objects deleted, error %q", status.Deleted, status.Error)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("deletion never finished")
+		}
+	}
+}
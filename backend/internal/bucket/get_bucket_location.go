@@ -0,0 +1,42 @@
+package bucket
+
+import (
+	"encoding/xml"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Region  string   `xml:",chardata"`
+}
+
+func GetBucketLocation(bucket string) (*LocationConstraint, error) {
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &LocationConstraint{Region: record.Region}
+
+	// As per S3, buckets in us-east-1 have a null location constraint
+	if res.Region == "us-east-1" {
+		res.Region = ""
+	}
+
+	return res, nil
+}
+
+// GetBucketLocationHandler: GET /:bucket?location
+func GetBucketLocationHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	res, err := GetBucketLocation(bucket)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
@@ -1,12 +1,36 @@
 package bucket
 
 import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
 	"github.com/gofiber/fiber/v2"
 )
 
+func HeadBucket(bucket, expectedOwner string) (*Record, error) {
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedOwner != "" && expectedOwner != record.Owner {
+		return nil, core.ErrorAccessDenied()
+	}
+
+	return record, nil
+}
+
+// HeadBucketHandler: HEAD /:bucket
 func HeadBucketHandler(c *fiber.Ctx) error {
-	// *NOTE: This will likely share code with GET due to using the same headers.
-	// TODO: organize shared code somewhere
+	bucket := c.Params("bucket")
+	expectedOwner := c.Get("X-Amz-Expected-Bucket-Owner")
+
+	record, err := HeadBucket(bucket, expectedOwner)
+	if err != nil {
+		core.HandleHeadError(c, err)
+		return err
+	}
+
+	c.Set("X-Amz-Bucket-Region", record.Region)
+	c.Set("X-Amz-Access-Point-Alias", "false")
 
 	c.Status(fiber.StatusOK)
 	return nil
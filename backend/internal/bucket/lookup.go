@@ -0,0 +1,36 @@
+package bucket
+
+import (
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+// Lookup checks that a bucket exists, returning NoSuchBucket otherwise. Names
+// are not validated, so that buckets with legacy names stay reachable, but the
+// system directory and names that would resolve to another path never refer to
+// a bucket.
+func Lookup(bucket string) error {
+	if !addressable(bucket) {
+		return core.ErrorNoSuchBucket()
+	}
+
+	info, err := storage.Stat(bucket)
+	if err != nil || !info.IsDir {
+		return core.ErrorNoSuchBucket()
+	}
+
+	return nil
+}
+
+// addressable reports whether a name can refer to a directory directly under
+// the storage root, other than the system directory.
+func addressable(bucket string) bool {
+	if bucket == "" || bucket == "." || bucket == config.SystemDir {
+		return false
+	}
+
+	return !strings.ContainsAny(bucket, `/\`) && !strings.Contains(bucket, "..")
+}
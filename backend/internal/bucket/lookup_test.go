@@ -0,0 +1,63 @@
+package bucket
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+func TestLookup(t *testing.T) {
+	storage.Default = storage.NewMemory(0)
+
+	for _, dir := range []string{"data-bucket", config.SystemDir, "Not_A_Bucket", "ab"} {
+		if err := storage.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.WriteFile("plain-file", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		bucket string
+		found  bool
+	}{
+		{"bucket", "data-bucket", true},
+		{"missing bucket", "missing-bucket", false},
+		{"system directory", config.SystemDir, false},
+		{"legacy name", "Not_A_Bucket", true},
+		{"short legacy name", "ab", true},
+		{"parent", "..", false},
+		{"current", ".", false},
+		{"nested", "data-bucket/x", false},
+		{"backslash", `data-bucket\x`, false},
+		{"traversal", "data-bucket/../" + config.SystemDir, false},
+		{"file", "plain-file", false},
+		{"empty name", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Lookup(tt.bucket)
+
+			if tt.found {
+				if err != nil {
+					t.Fatalf("Lookup(%q) = %v, want nil", tt.bucket, err)
+				}
+
+				return
+			}
+
+			var s3Error *core.S3Error
+
+			if !errors.As(err, &s3Error) || s3Error.Code != core.ErrorNoSuchBucket().Code {
+				t.Fatalf("Lookup(%q) = %v, want NoSuchBucket", tt.bucket, err)
+			}
+		})
+	}
+}
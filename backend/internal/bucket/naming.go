@@ -0,0 +1,73 @@
+package bucket
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+var bucketNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Prefixes and suffixes reserved by S3 for access points, aliases, etc.
+var (
+	reservedPrefixes = []string{"xn--", "sthree-", "amzn-s3-demo-"}
+	reservedSuffixes = []string{"-s3alias", "--ol-s3", ".mrap", "--x-s3", "--table-s3"}
+)
+
+// Names that clash with internal directories under the storage root
+var reservedNames = map[string]struct{}{
+	config.SystemDir: {},
+}
+
+func ErrorInvalidBucketName(message string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "InvalidBucketName",
+		Message:    message,
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+// ValidateBucketName checks a bucket name against the S3 general purpose
+// bucket naming rules.
+func ValidateBucketName(name string) error {
+	if len(name) < 3 || len(name) > 63 {
+		return ErrorInvalidBucketName("Bucket name must be between 3 and 63 characters long")
+	}
+
+	if _, ok := reservedNames[name]; ok {
+		return ErrorInvalidBucketName("Bucket name is reserved")
+	}
+
+	if !bucketNameRegex.MatchString(name) {
+		return ErrorInvalidBucketName(
+			"Bucket name can only contain lowercase letters, numbers, dots and hyphens, " +
+				"and must begin and end with a letter or number",
+		)
+	}
+
+	if strings.Contains(name, "..") {
+		return ErrorInvalidBucketName("Bucket name must not contain two adjacent periods")
+	}
+
+	if net.ParseIP(name) != nil {
+		return ErrorInvalidBucketName("Bucket name must not be formatted as an IP address")
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return ErrorInvalidBucketName("Bucket name must not start with " + prefix)
+		}
+	}
+
+	for _, suffix := range reservedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return ErrorInvalidBucketName("Bucket name must not end with " + suffix)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package bucket
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	RetentionGovernance = "GOVERNANCE"
+	RetentionCompliance = "COMPLIANCE"
+)
+
+func ErrorObjectLockConfigurationNotFound() *core.S3Error {
+	return &core.S3Error{
+		Code:       "ObjectLockConfigurationNotFoundError",
+		Message:    "Object Lock configuration does not exist for this bucket",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+type DefaultRetention struct {
+	Mode  string `json:"mode"`
+	Days  int    `xml:",omitempty" json:"days,omitempty"`
+	Years int    `xml:",omitempty" json:"years,omitempty"`
+}
+
+type ObjectLockRule struct {
+	DefaultRetention *DefaultRetention `json:"defaultRetention"`
+}
+
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration" json:"-"`
+	ObjectLockEnabled string          `xml:",omitempty" json:"-"`
+	Rule              *ObjectLockRule `xml:",omitempty" json:"rule,omitempty"`
+}
+
+// RetainUntil returns the retain until date for an object created at the
+// given time, under the default retention rule.
+func (r *DefaultRetention) RetainUntil(created time.Time) time.Time {
+	return created.AddDate(r.Years, 0, r.Days).UTC()
+}
+
+func (r *DefaultRetention) validate() error {
+	if r.Mode != RetentionGovernance && r.Mode != RetentionCompliance {
+		return core.ErrorMalformedXML()
+	}
+
+	if (r.Days > 0) == (r.Years > 0) || r.Days < 0 || r.Years < 0 {
+		return core.ErrorInvalidArgument("Default retention period must be a positive number of either days or years")
+	}
+
+	return nil
+}
+
+func objectLockPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "object-lock.json")
+}
+
+// GetObjectLockConfiguration returns the object lock configuration, which
+// only exists for buckets with object lock enabled.
+func GetObjectLockConfiguration(bucket string) (*ObjectLockConfiguration, error) {
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !record.ObjectLockEnabled {
+		return nil, ErrorObjectLockConfigurationNotFound()
+	}
+
+	conf := &ObjectLockConfiguration{ObjectLockEnabled: "Enabled"}
+
+	data, err := storage.ReadFile(objectLockPath(bucket))
+	if storage.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read object lock configuration: %w", err)
+	}
+
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("could not decode object lock configuration: %w", err)
+	}
+
+	return conf, nil
+}
+
+// PutObjectLockConfiguration sets the default retention rule. Object lock can
+// also be enabled on an existing bucket, as long as versioning is enabled, but
+// it can never be disabled.
+func PutObjectLockConfiguration(bucket string, conf *ObjectLockConfiguration) error {
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return err
+	}
+
+	if conf.ObjectLockEnabled != "Enabled" {
+		return core.ErrorMalformedXML()
+	}
+
+	if conf.Rule != nil {
+		if conf.Rule.DefaultRetention == nil {
+			return core.ErrorMalformedXML()
+		}
+
+		if err := conf.Rule.DefaultRetention.validate(); err != nil {
+			return err
+		}
+	}
+
+	if !record.ObjectLockEnabled {
+		status, err := GetVersioningStatus(bucket)
+		if err != nil {
+			return err
+		}
+
+		if status != VersioningEnabled {
+			return ErrorInvalidBucketState("Versioning must be 'Enabled' on the bucket to apply a Object Lock configuration")
+		}
+
+		record.ObjectLockEnabled = true
+
+		if err := WriteRecord(record); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("could not encode object lock configuration: %w", err)
+	}
+
+	if err := storage.WriteFile(objectLockPath(bucket), data); err != nil {
+		return fmt.Errorf("could not write object lock configuration: %w", err)
+	}
+
+	return nil
+}
+
+// GetObjectLockConfigurationHandler: GET /:bucket?object-lock
+func GetObjectLockConfigurationHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	res, err := GetObjectLockConfiguration(bucket)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
+
+// PutObjectLockConfigurationHandler: PUT /:bucket?object-lock
+func PutObjectLockConfigurationHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	var conf ObjectLockConfiguration
+
+	if err := xml.Unmarshal(c.Body(), &conf); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutObjectLockConfiguration(bucket, &conf); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusOK)
+	return nil
+}
@@ -0,0 +1,208 @@
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Warnings are logged once usage reaches this percentage of a quota, unless
+// configured otherwise.
+const DefaultQuotaWarnPercent = 90
+
+func ErrorNoSuchQuotaConfiguration() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchQuotaConfiguration",
+		Message:    "There is no quota configuration",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+func ErrorNoSuchUser() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchUser",
+		Message:    "The specified user does not exist",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// Quota is a hard limit on the number of object versions and their total
+// size, as seen by clients, where zero means unlimited. A warning is logged
+// once usage reaches WarnPercent of either limit.
+type Quota struct {
+	MaxBytes    int64 `json:"maxBytes"`
+	MaxObjects  int64 `json:"maxObjects"`
+	WarnPercent int   `json:"warnPercent"`
+}
+
+func (q *Quota) validate() error {
+	if q.WarnPercent == 0 {
+		q.WarnPercent = DefaultQuotaWarnPercent
+	}
+
+	switch {
+	case q.MaxBytes < 0 || q.MaxObjects < 0:
+		return core.ErrorInvalidArgument("Quota limits must not be negative")
+	case q.MaxBytes == 0 && q.MaxObjects == 0:
+		return core.ErrorInvalidArgument("Quota must limit bytes, objects or both")
+	case q.WarnPercent < 0 || q.WarnPercent > 100:
+		return core.ErrorInvalidArgument("Quota warning threshold must be a percentage")
+	}
+
+	return nil
+}
+
+func bucketQuotaPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "quota.json")
+}
+
+// User quotas apply to all buckets owned by a user.
+func userQuotaPath(user string) string {
+	return config.SystemPath("quotas", user+".json")
+}
+
+// LookupUser checks that a user exists.
+func LookupUser(user string) error {
+	if _, ok := iam.Users[user]; !ok {
+		return ErrorNoSuchUser()
+	}
+
+	return nil
+}
+
+func readQuota(path string) (*Quota, error) {
+	data, err := storage.ReadFile(path)
+	if storage.IsNotExist(err) {
+		return nil, ErrorNoSuchQuotaConfiguration()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read quota: %w", err)
+	}
+
+	var quota Quota
+
+	if err := json.Unmarshal(data, &quota); err != nil {
+		return nil, fmt.Errorf("could not decode quota: %w", err)
+	}
+
+	return &quota, nil
+}
+
+func writeQuota(path string, quota *Quota) error {
+	if err := quota.validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(quota)
+	if err != nil {
+		return fmt.Errorf("could not encode quota: %w", err)
+	}
+
+	if err := storage.WriteFile(path, data); err != nil {
+		return fmt.Errorf("could not write quota: %w", err)
+	}
+
+	return nil
+}
+
+func deleteQuota(path string) error {
+	if err := storage.Delete(path); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete quota: %w", err)
+	}
+
+	return nil
+}
+
+// optionalQuota returns nil instead of ErrorNoSuchQuotaConfiguration.
+func optionalQuota(quota *Quota, err error) (*Quota, error) {
+	var s3Error *core.S3Error
+
+	if errors.As(err, &s3Error) && s3Error.Code == ErrorNoSuchQuotaConfiguration().Code {
+		return nil, nil
+	}
+
+	return quota, err
+}
+
+func GetBucketQuota(bucket string) (*Quota, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	return readQuota(bucketQuotaPath(bucket))
+}
+
+func PutBucketQuota(bucket string, quota *Quota) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	return writeQuota(bucketQuotaPath(bucket), quota)
+}
+
+func DeleteBucketQuota(bucket string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	return deleteQuota(bucketQuotaPath(bucket))
+}
+
+// BucketQuota returns the quota of a bucket, or nil when it has none.
+func BucketQuota(bucket string) (*Quota, error) {
+	return optionalQuota(GetBucketQuota(bucket))
+}
+
+func GetUserQuota(user string) (*Quota, error) {
+	if err := LookupUser(user); err != nil {
+		return nil, err
+	}
+
+	return readQuota(userQuotaPath(user))
+}
+
+func PutUserQuota(user string, quota *Quota) error {
+	if err := LookupUser(user); err != nil {
+		return err
+	}
+
+	return writeQuota(userQuotaPath(user), quota)
+}
+
+func DeleteUserQuota(user string) error {
+	if err := LookupUser(user); err != nil {
+		return err
+	}
+
+	return deleteQuota(userQuotaPath(user))
+}
+
+// UserQuota returns the quota of a user, or nil when they have none, which
+// includes bucket owners that are no longer users.
+func UserQuota(user string) (*Quota, error) {
+	return optionalQuota(readQuota(userQuotaPath(user)))
+}
+
+// OwnedBuckets returns the names of the buckets owned by a user.
+func OwnedBuckets(user string) ([]string, error) {
+	records, err := ListRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, record := range records {
+		if record.Owner == user {
+			names = append(names, record.Name)
+		}
+	}
+
+	return names, nil
+}
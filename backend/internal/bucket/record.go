@@ -0,0 +1,118 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+const DefaultObjectOwnership = "BucketOwnerEnforced"
+
+// Record is the persisted bucket state, created along with the bucket.
+type Record struct {
+	Name              string    `json:"name"`
+	CreationDate      time.Time `json:"creationDate"`
+	Owner             string    `json:"owner"`
+	Region            string    `json:"region"`
+	ACL               string    `json:"acl"`
+	ObjectOwnership   string    `json:"objectOwnership"`
+	ObjectLockEnabled bool      `json:"objectLockEnabled"`
+}
+
+func recordPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "bucket.json")
+}
+
+// ReadRecord loads the bucket record, falling back to defaults for buckets
+// created without one (e.g., directories added directly to the storage root).
+func ReadRecord(bucket string) (*Record, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.ReadFile(recordPath(bucket))
+	if storage.IsNotExist(err) {
+		return legacyRecord(bucket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket record: %w", err)
+	}
+
+	var record Record
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("could not decode bucket record: %w", err)
+	}
+
+	return &record, nil
+}
+
+func WriteRecord(record *Record) error {
+	path := recordPath(record.Name)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket record: %w", err)
+	}
+
+	if err := storage.WriteFile(path, data); err != nil {
+		return fmt.Errorf("could not write bucket record: %w", err)
+	}
+
+	return nil
+}
+
+func legacyRecord(bucket string) (*Record, error) {
+	info, err := storage.Stat(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat bucket: %w", err)
+	}
+
+	record := &Record{
+		Name:            bucket,
+		CreationDate:    info.ModTime.UTC(),
+		Owner:           config.Env.AdminAccessKey,
+		Region:          config.Env.Region,
+		ACL:             "private",
+		ObjectOwnership: DefaultObjectOwnership,
+	}
+
+	return record, nil
+}
+
+// ListRecords returns the records for all buckets, sorted by name.
+func ListRecords() ([]*Record, error) {
+	entries, err := storage.List("")
+	if err != nil {
+		return nil, fmt.Errorf("could not read storage root: %w", err)
+	}
+
+	var records []*Record
+
+	for _, e := range entries {
+		// Skip the system directory and any other hidden directories
+		if !e.IsDir || strings.HasPrefix(e.Name, ".") {
+			continue
+		}
+
+		record, err := ReadRecord(e.Name)
+		if err != nil {
+			logger.Log.Warnf("Skipping bucket %s: %s", e.Name, err)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+
+	return records, nil
+}
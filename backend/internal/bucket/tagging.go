@@ -0,0 +1,119 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorNoSuchTagSet() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchTagSet",
+		Message:    "The TagSet does not exist",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+func taggingPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "tagging.json")
+}
+
+func GetBucketTagging(bucket string) (map[string]string, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.ReadFile(taggingPath(bucket))
+	if storage.IsNotExist(err) {
+		return nil, ErrorNoSuchTagSet()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket tagging: %w", err)
+	}
+
+	var tags map[string]string
+
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("could not decode bucket tagging: %w", err)
+	}
+
+	return tags, nil
+}
+
+func PutBucketTagging(bucket string, tags map[string]string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket tagging: %w", err)
+	}
+
+	if err := storage.WriteFile(taggingPath(bucket), data); err != nil {
+		return fmt.Errorf("could not write bucket tagging: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteBucketTagging(bucket string) error {
+	if err := Lookup(bucket); err != nil {
+		return err
+	}
+
+	if err := storage.Delete(taggingPath(bucket)); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete bucket tagging: %w", err)
+	}
+
+	return nil
+}
+
+// GetBucketTaggingHandler: GET /:bucket?tagging
+func GetBucketTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	tags, err := GetBucketTagging(bucket)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(core.NewTagging(tags))
+}
+
+// PutBucketTaggingHandler: PUT /:bucket?tagging
+func PutBucketTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	tags, err := core.ParseTaggingXML(c.Body(), core.MaxBucketTags)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutBucketTagging(bucket, tags); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
+
+// DeleteBucketTaggingHandler: DELETE /:bucket?tagging
+func DeleteBucketTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	if err := DeleteBucketTagging(bucket); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,142 @@
+package bucket
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	VersioningUnversioned = ""
+	VersioningEnabled     = "Enabled"
+	VersioningSuspended   = "Suspended"
+)
+
+func ErrorInvalidBucketState(message string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "InvalidBucketState",
+		Message:    message,
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+type VersioningConfiguration struct {
+	XMLName   xml.Name `xml:"VersioningConfiguration" json:"-"`
+	Status    string   `xml:",omitempty" json:"status"`
+	MfaDelete string   `xml:",omitempty" json:"-"`
+}
+
+func versioningPath(bucket string) string {
+	return config.BucketSystemPath(bucket, "versioning.json")
+}
+
+// GetVersioningStatus returns Enabled, Suspended, or an empty string for
+// buckets that never had versioning enabled.
+func GetVersioningStatus(bucket string) (string, error) {
+	data, err := storage.ReadFile(versioningPath(bucket))
+	if storage.IsNotExist(err) {
+		return VersioningUnversioned, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read bucket versioning: %w", err)
+	}
+
+	var conf VersioningConfiguration
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return "", fmt.Errorf("could not decode bucket versioning: %w", err)
+	}
+
+	return conf.Status, nil
+}
+
+func writeVersioning(bucket string, conf *VersioningConfiguration) error {
+	path := versioningPath(bucket)
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("could not encode bucket versioning: %w", err)
+	}
+
+	if err := storage.WriteFile(path, data); err != nil {
+		return fmt.Errorf("could not write bucket versioning: %w", err)
+	}
+
+	return nil
+}
+
+func GetBucketVersioning(bucket string) (*VersioningConfiguration, error) {
+	if err := Lookup(bucket); err != nil {
+		return nil, err
+	}
+
+	status, err := GetVersioningStatus(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VersioningConfiguration{Status: status}, nil
+}
+
+// PutBucketVersioning enables or suspends versioning. As per S3, a bucket can
+// never go back to unversioned, and versioning cannot be suspended while
+// object lock is enabled.
+func PutBucketVersioning(bucket string, conf *VersioningConfiguration) error {
+	record, err := ReadRecord(bucket)
+	if err != nil {
+		return err
+	}
+
+	if conf.Status != VersioningEnabled && conf.Status != VersioningSuspended {
+		return core.ErrorMalformedXML()
+	}
+
+	if conf.MfaDelete == "Enabled" {
+		return core.ErrorInvalidArgument("MFA delete is not supported")
+	}
+
+	if conf.Status == VersioningSuspended && record.ObjectLockEnabled {
+		return ErrorInvalidBucketState("An Object Lock configuration is present on this bucket, so the versioning state cannot be changed")
+	}
+
+	return writeVersioning(bucket, &VersioningConfiguration{Status: conf.Status})
+}
+
+// GetBucketVersioningHandler: GET /:bucket?versioning
+func GetBucketVersioningHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	res, err := GetBucketVersioning(bucket)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
+
+// PutBucketVersioningHandler: PUT /:bucket?versioning
+func PutBucketVersioningHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	var conf VersioningConfiguration
+
+	if err := xml.Unmarshal(c.Body(), &conf); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutBucketVersioning(bucket, &conf); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusOK)
+	return nil
+}
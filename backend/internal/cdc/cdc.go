@@ -0,0 +1,170 @@
+// Package cdc splits data into content-defined chunks, with FastCDC, so that
+// chunk boundaries follow the content, instead of fixed offsets. Inserting or
+// removing bytes only changes the chunks around the edit, and the remaining
+// chunks are the same as before.
+package cdc
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	DefaultMinSize = 16 << 10
+	DefaultAvgSize = 64 << 10
+	DefaultMaxSize = 256 << 10
+
+	minChunkSize = 64
+	maxChunkSize = 16 << 20
+)
+
+// gear maps each byte to a random value, for the rolling hash. It must never
+// change, as chunk boundaries, and so deduplication, depend on it.
+var gear [256]uint64
+
+func init() {
+	// SplitMix64, seeded with "labstore"
+	x := uint64(0x6c616273746f7265)
+
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Params bound the chunk sizes. Chunks are at least MinSize bytes, except for
+// the last one, at most MaxSize bytes, and AvgSize bytes on average.
+type Params struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+func (p Params) Validate() error {
+	if p.MinSize < minChunkSize || p.MaxSize > maxChunkSize {
+		return fmt.Errorf("chunk sizes must be between %d and %d bytes", minChunkSize, maxChunkSize)
+	}
+
+	if p.MinSize >= p.AvgSize || p.AvgSize >= p.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy min < average < max")
+	}
+
+	return nil
+}
+
+// mask selects the n most significant bits of the hash, which depend on the
+// last 64 bytes, while the least significant ones only depend on the last few.
+func mask(n int) uint64 {
+	n = min(max(n, 1), 63)
+	return ^uint64(0) << (64 - n)
+}
+
+// Chunker reads data and splits it into chunks. Normalized chunking makes cut
+// points harder to find below the average size and easier above it, which
+// keeps chunk sizes close to the average.
+type Chunker struct {
+	r      io.Reader
+	params Params
+
+	maskS uint64
+	maskL uint64
+
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func NewChunker(r io.Reader, params Params) *Chunker {
+	n := bits.Len(uint(params.AvgSize)) - 1
+
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  mask(n + 2),
+		maskL:  mask(n - 2),
+		buf:    make([]byte, params.MaxSize),
+	}
+}
+
+// Next returns the next chunk, which is only valid until the following call,
+// or io.EOF after the last one.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+// fill buffers up to MaxSize bytes, which is enough to find the next cut.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+
+	if n <= c.params.MinSize {
+		return n
+	}
+
+	n = min(n, c.params.MaxSize)
+	normal := min(n, c.params.AvgSize)
+
+	var fp uint64
+
+	i := c.params.MinSize
+
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,260 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed objects are stored as a sequence of independently compressed
+// blocks of BlockSize uncompressed bytes (the last one can be shorter). Each
+// block starts with a byte giving its encoding, so that incompressible blocks
+// are stored as-is. The stored offset of each block is kept in Info, which
+// works as a seekable index: any uncompressed offset maps to a block, so range
+// reads only decompress the blocks they touch.
+
+const (
+	AlgorithmZstd = "zstd"
+	AlgorithmS2   = "s2"
+
+	BlockSize = 1 << 20
+)
+
+const (
+	blockRaw byte = iota
+	blockCompressed
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+	errCorrupted = errors.New("compressed object is corrupted")
+)
+
+// Info is the compression state stored with each compressed object version.
+type Info struct {
+	Algorithm  string  `json:"algorithm"`
+	BlockSize  int64   `json:"blockSize"`
+	StoredSize int64   `json:"storedSize"`
+	Offsets    []int64 `json:"offsets"`
+}
+
+func Supported(algorithm string) bool {
+	return algorithm == AlgorithmZstd || algorithm == AlgorithmS2
+}
+
+func encode(algorithm string, dst, src []byte) []byte {
+	switch algorithm {
+	case AlgorithmS2:
+		return s2.Encode(dst, src)
+	default:
+		return zstdEncoder.EncodeAll(src, dst[:0])
+	}
+}
+
+func decode(algorithm string, dst, src []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmS2:
+		return s2.Decode(dst, src)
+	default:
+		return zstdDecoder.DecodeAll(src, dst[:0])
+	}
+}
+
+type Writer struct {
+	w       io.Writer
+	info    *Info
+	buf     []byte
+	scratch []byte
+}
+
+// NewWriter compresses data written to it into w, with the given algorithm.
+// Close must be called to flush the last block, after which Info returns the
+// block index to store.
+func NewWriter(w io.Writer, algorithm string) (*Writer, error) {
+	if !Supported(algorithm) {
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+
+	writer := &Writer{
+		w:    w,
+		info: &Info{Algorithm: algorithm, BlockSize: BlockSize},
+		buf:  make([]byte, 0, BlockSize),
+	}
+
+	return writer, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (w *Writer) flush() error {
+	kind, block := blockCompressed, encode(w.info.Algorithm, w.scratch, w.buf)
+
+	if len(block) >= len(w.buf) {
+		kind, block = blockRaw, w.buf
+	} else {
+		w.scratch = block
+	}
+
+	w.info.Offsets = append(w.info.Offsets, w.info.StoredSize)
+
+	if _, err := w.w.Write([]byte{kind}); err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(block); err != nil {
+		return err
+	}
+
+	w.info.StoredSize += int64(1 + len(block))
+	w.buf = w.buf[:0]
+
+	return nil
+}
+
+func (w *Writer) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	return w.flush()
+}
+
+func (w *Writer) Info() *Info {
+	return w.info
+}
+
+type reader struct {
+	r      io.ReadSeeker
+	info   *Info
+	size   int64
+	offset int64
+	block  []byte
+	index  int64
+}
+
+// NewReader decompresses an object of the given uncompressed size, stored in
+// r. Seeking only decompresses the block holding the new offset. When r is an
+// io.Closer, it is closed along with the reader.
+func NewReader(r io.ReadSeeker, size int64, info *Info) io.ReadSeekCloser {
+	return &reader{r: r, info: info, size: size, index: -1}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / r.info.BlockSize
+
+	if index != r.index {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.block[r.offset-index*r.info.BlockSize:])
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *reader) load(index int64) error {
+	if index >= int64(len(r.info.Offsets)) {
+		return errCorrupted
+	}
+
+	start := r.info.Offsets[index]
+	end := r.info.StoredSize
+
+	if index+1 < int64(len(r.info.Offsets)) {
+		end = r.info.Offsets[index+1]
+	}
+
+	if end-start < 1 {
+		return errCorrupted
+	}
+
+	if _, err := r.r.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("could not seek compressed object: %w", err)
+	}
+
+	stored := make([]byte, end-start)
+
+	if _, err := io.ReadFull(r.r, stored); err != nil {
+		return fmt.Errorf("could not read compressed object: %w", err)
+	}
+
+	length := min(r.info.BlockSize, r.size-index*r.info.BlockSize)
+
+	switch stored[0] {
+	case blockRaw:
+		r.block = stored[1:]
+	case blockCompressed:
+		block, err := decode(r.info.Algorithm, r.block, stored[1:])
+		if err != nil {
+			return errCorrupted
+		}
+
+		r.block = block
+	default:
+		return errCorrupted
+	}
+
+	if int64(len(r.block)) != length {
+		return errCorrupted
+	}
+
+	r.index = index
+
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if closer, ok := r.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -6,3 +6,7 @@ const (
 	Author      = "DLTech & TDev"
 	Version     = "0.0.1"
 )
+
+// SystemDir is the directory, under the storage root, where LabStore keeps its
+// internal state (bucket records, object metadata, etc.)
+const SystemDir = ".labstore.sys"
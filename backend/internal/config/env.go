@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/DataLabTechTV/labstore/backend/internal/helper"
 	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
@@ -18,10 +19,26 @@ const dotenvPath = ".env"
 var Env ServerConfig
 
 type ServerConfig struct {
-	Port           uint16 `env:"LS_PORT" envDefault:"6789"`
-	StorageRoot    string `env:"LS_STORAGE_ROOT" envDefault:"../data"`
-	AdminAccessKey string `env:"LS_ADMIN_ACCESS_KEY" envDefault:"admin"`
-	AdminSecretKey string `env:"LS_ADMIN_SECRET_KEY" envDefault:"admin"`
+	Port                uint16        `env:"LS_PORT" envDefault:"6789"`
+	StorageRoot         string        `env:"LS_STORAGE_ROOT" envDefault:"../data"`
+	StorageDriver       string        `env:"LS_STORAGE_DRIVER" envDefault:"fs"`
+	MemoryMaxSize       int64         `env:"LS_MEMORY_MAX_SIZE" envDefault:"1073741824"`
+	ErasureDrives       []string      `env:"LS_ERASURE_DRIVES" envSeparator:","`
+	ErasureParity       int           `env:"LS_ERASURE_PARITY" envDefault:"0"`
+	Pools               []string      `env:"LS_POOLS" envSeparator:","`
+	PoolReserve         int64         `env:"LS_POOL_RESERVE" envDefault:"1073741824"`
+	Durability          string        `env:"LS_DURABILITY" envDefault:"object"`
+	SyncInterval        time.Duration `env:"LS_SYNC_INTERVAL" envDefault:"1s"`
+	LockTimeout         time.Duration `env:"LS_LOCK_TIMEOUT" envDefault:"30s"`
+	IndexPath           string        `env:"LS_INDEX_PATH"`
+	InlineThreshold     int64         `env:"LS_INLINE_THRESHOLD" envDefault:"4096"`
+	Dedupe              bool          `env:"LS_DEDUPE" envDefault:"false"`
+	ScrubInterval       time.Duration `env:"LS_SCRUB_INTERVAL" envDefault:"24h"`
+	ScrubRate           int64         `env:"LS_SCRUB_RATE" envDefault:"16777216"`
+	Region              string        `env:"LS_REGION" envDefault:"us-east-1"`
+	AdminAccessKey      string        `env:"LS_ADMIN_ACCESS_KEY" envDefault:"admin"`
+	AdminSecretKey      string        `env:"LS_ADMIN_SECRET_KEY" envDefault:"admin"`
+	EncryptionSecretKey string        `env:"LS_ENCRYPTION_SECRET_KEY"`
 }
 
 func Load() {
@@ -0,0 +1,16 @@
+package config
+
+import "path"
+
+// SystemPath returns a storage name under the system directory, where LabStore
+// keeps its internal state. Storage names are relative to the storage root.
+func SystemPath(elem ...string) string {
+	return path.Join(append([]string{SystemDir}, elem...)...)
+}
+
+// BucketSystemPath returns a storage name under the system directory for a
+// bucket, where bucket-scoped state (records, configurations, metadata) is
+// kept.
+func BucketSystemPath(bucket string, elem ...string) string {
+	return SystemPath(append([]string{"buckets", bucket}, elem...)...)
+}
@@ -25,6 +25,30 @@ func ErrorNotImplemented() *S3Error {
 	}
 }
 
+func ErrorInvalidRequest(message string) *S3Error {
+	return &S3Error{
+		Code:       "InvalidRequest",
+		Message:    message,
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorInvalidArgument(message string) *S3Error {
+	return &S3Error{
+		Code:       "InvalidArgument",
+		Message:    message,
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorMalformedXML() *S3Error {
+	return &S3Error{
+		Code:       "MalformedXML",
+		Message:    "The XML you provided was not well-formed or did not validate against our published schema",
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
 func ErrorInternalError(message string) *S3Error {
 	return &S3Error{
 		Code:       "InternalError",
@@ -35,7 +59,7 @@ func ErrorInternalError(message string) *S3Error {
 
 func ErrorNoSuchBucket() *S3Error {
 	return &S3Error{
-		Code:       "NoSuckBucket",
+		Code:       "NoSuchBucket",
 		Message:    "Bucket does not exist",
 		StatusCode: fiber.StatusNotFound,
 	}
@@ -75,3 +99,28 @@ func HandleError(c *fiber.Ctx, err error) {
 		c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 }
+
+// HandleHeadError sets the status code only, as HEAD responses have no body.
+func HandleHeadError(c *fiber.Ctx, err error) {
+	logger.Log.Error(err.Error())
+
+	var s3Error *S3Error
+
+	if errors.As(err, &s3Error) {
+		c.Status(s3Error.StatusCode)
+	} else {
+		c.Status(fiber.StatusInternalServerError)
+	}
+}
+
+// ErrorHandler keeps the S3 error responses already written by HandleError,
+// instead of letting fiber replace them with a plain text error.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var s3Error *S3Error
+
+	if errors.As(err, &s3Error) {
+		return nil
+	}
+
+	return fiber.DefaultErrorHandler(c, err)
+}
@@ -1,3 +1,12 @@
 package core
 
 // Handle response serialization (not response types)
+
+import "time"
+
+// TimestampFormat is the ISO 8601 format used by S3 in XML responses
+const TimestampFormat = "2006-01-02T15:04:05.000Z"
+
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	MaxObjectTags  = 10
+	MaxBucketTags  = 50
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+func ErrorInvalidTag(message string) *S3Error {
+	return &S3Error{
+		Code:       "InvalidTag",
+		Message:    message,
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+// NewTagging builds a tag set response, sorted by key for stable output.
+func NewTagging(tags map[string]string) *Tagging {
+	tagging := &Tagging{TagSet: []Tag{}}
+
+	for k, v := range tags {
+		tagging.TagSet = append(tagging.TagSet, Tag{Key: k, Value: v})
+	}
+
+	sort.Slice(tagging.TagSet, func(i, j int) bool {
+		return tagging.TagSet[i].Key < tagging.TagSet[j].Key
+	})
+
+	return tagging
+}
+
+// ParseTaggingXML decodes and validates a Tagging request body.
+func ParseTaggingXML(body []byte, maxTags int) (map[string]string, error) {
+	var tagging Tagging
+
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		return nil, ErrorMalformedXML()
+	}
+
+	tags := map[string]string{}
+
+	for _, tag := range tagging.TagSet {
+		if _, ok := tags[tag.Key]; ok {
+			return nil, ErrorInvalidTag("Cannot provide multiple Tags with the same key")
+		}
+
+		tags[tag.Key] = tag.Value
+	}
+
+	if err := ValidateTags(tags, maxTags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// ParseTaggingHeader parses and validates a URL query encoded tag set (e.g.,
+// x-amz-tagging).
+func ParseTaggingHeader(tagging string) (map[string]string, error) {
+	values, err := url.ParseQuery(tagging)
+	if err != nil {
+		return nil, ErrorInvalidArgument("Invalid tag set encoding")
+	}
+
+	tags := map[string]string{}
+
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, ErrorInvalidTag("Cannot provide multiple Tags with the same key")
+		}
+
+		tags[k] = v[0]
+	}
+
+	if err := ValidateTags(tags, MaxObjectTags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// ValidateTags enforces the S3 tag limits: at most maxTags tags, with keys up
+// to 128 and values up to 256 Unicode characters, and no reserved aws: keys.
+func ValidateTags(tags map[string]string, maxTags int) error {
+	if len(tags) > maxTags {
+		return ErrorInvalidTag(fmt.Sprintf("Tag set cannot have more than %d tags", maxTags))
+	}
+
+	for k, v := range tags {
+		if k == "" || utf8.RuneCountInString(k) > maxTagKeyLen {
+			return ErrorInvalidTag("The TagKey you have provided is invalid")
+		}
+
+		if utf8.RuneCountInString(v) > maxTagValueLen {
+			return ErrorInvalidTag("The TagValue you have provided is invalid")
+		}
+
+		if strings.HasPrefix(strings.ToLower(k), "aws:") {
+			return ErrorInvalidTag("Your TagKey cannot be prefixed with aws:")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,416 @@
+// Package index keeps an ordered index of the latest version of every object,
+// per bucket, so that listings only read the entries they return, instead of
+// walking the storage backend. It also counts the references to each blob, for
+// deduplicated object data, and the object versions stored in each bucket, for
+// quotas.
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketsKey = []byte("buckets")
+	metaKey    = []byte("meta")
+	stateKey   = []byte("state")
+
+	stateClean = []byte("clean")
+	stateDirty = []byte("dirty")
+)
+
+// Entry describes an object for listings. Delete markers are never indexed.
+type Entry struct {
+	Key          string    `json:"-"`
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Index is an embedded key-value store, with a nested bucket of entries, in
+// key order, for each bucket.
+//
+// The index is derived from the object metadata, and is updated along with
+// it, so it is only marked clean when closed. An index that was not closed
+// cleanly is stale, and must be rebuilt before serving requests.
+type Index struct {
+	db    *bolt.DB
+	stale bool
+	temp  string
+}
+
+// Default is the index used by all handlers, set up by Init.
+var Default *Index
+
+// Init opens the default index, at LS_INDEX_PATH, or under the storage root,
+// when unset. The memory driver gets a temporary index, removed on close.
+func Init() error {
+	path := config.Env.IndexPath
+	temp := ""
+
+	if config.Env.StorageDriver == "memory" {
+		dir, err := os.MkdirTemp("", "labstore-index-")
+		if err != nil {
+			return fmt.Errorf("could not create index directory: %w", err)
+		}
+
+		path = filepath.Join(dir, "index.db")
+		temp = dir
+	} else if path == "" {
+		path = filepath.Join(config.Env.StorageRoot, filepath.FromSlash(config.SystemPath("index.db")))
+	}
+
+	// Lost updates are caught by the stale check, so syncing every update is
+	// only worth it when writes are synced as well
+	ix, err := Open(path, temp != "" || config.Env.Durability != "object")
+	if err != nil {
+		return err
+	}
+
+	ix.temp = temp
+	Default = ix
+
+	return nil
+}
+
+// Close closes the default index.
+func Close() error {
+	return Default.Close()
+}
+
+// Open opens or creates an index, and marks it dirty until closed.
+func Open(path string, noSync bool) (*Index, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create index directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("index %s is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open index: %w", err)
+	}
+
+	ix := &Index{db: db}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// Indexes created before usage was tracked have to be rebuilt
+		untracked := tx.Bucket(versionsKey) == nil
+
+		for _, name := range [][]byte{bucketsKey, refsKey, blobsKey, versionsKey, usageKey} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(metaKey)
+		if err != nil {
+			return err
+		}
+
+		ix.stale = untracked || string(meta.Get(stateKey)) != string(stateClean)
+
+		return meta.Put(stateKey, stateDirty)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open index: %w", err)
+	}
+
+	db.NoSync = noSync
+
+	return ix, nil
+}
+
+// Stale reports whether the index was not closed cleanly, or is new, and so
+// might not match the object metadata.
+func (ix *Index) Stale() bool {
+	return ix.stale
+}
+
+// Close marks the index clean, unless it is still stale, and closes it.
+func (ix *Index) Close() error {
+	if !ix.stale {
+		ix.db.NoSync = false
+
+		err := ix.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(metaKey).Put(stateKey, stateClean)
+		})
+		if err != nil {
+			ix.db.Close()
+			return fmt.Errorf("could not close index: %w", err)
+		}
+	}
+
+	if err := ix.db.Close(); err != nil {
+		return err
+	}
+
+	if ix.temp != "" {
+		return os.RemoveAll(ix.temp)
+	}
+
+	return nil
+}
+
+// Put adds or replaces the entry for a key.
+func (ix *Index) Put(bucket string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketsKey).CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(entry.Key), data)
+	})
+}
+
+// Delete removes the entry for a key, if there is one.
+func (ix *Index) Delete(bucket, key string) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketsKey).Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// DropBucket removes all entries for a bucket, along with its usage.
+func (ix *Index) DropBucket(bucket string) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketsKey).DeleteBucket([]byte(bucket))
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+
+		return dropUsage(tx, bucket)
+	})
+}
+
+// rebuildBatchSize is the number of entries written per transaction while
+// rebuilding, which keeps memory bounded for large buckets.
+const rebuildBatchSize = 10000
+
+// Rebuilder receives the entries, blob references and object versions of a
+// rebuilt index, which are written in batches.
+type Rebuilder struct {
+	ix    *Index
+	batch []func(tx *bolt.Tx) error
+}
+
+// Put adds the entry for a key.
+func (r *Rebuilder) Put(bucket string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return r.add(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketsKey).CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(entry.Key), data)
+	})
+}
+
+// AddRef references a blob from an object version.
+func (r *Rebuilder) AddRef(bucket, id, hash string) error {
+	return r.add(func(tx *bolt.Tx) error {
+		return addRef(tx, bucket, []byte(id), []byte(hash))
+	})
+}
+
+func (r *Rebuilder) add(op func(tx *bolt.Tx) error) error {
+	r.batch = append(r.batch, op)
+
+	if len(r.batch) == rebuildBatchSize {
+		return r.flush()
+	}
+
+	return nil
+}
+
+func (r *Rebuilder) flush() error {
+	err := r.ix.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range r.batch {
+			if err := op(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	r.batch = r.batch[:0]
+
+	return err
+}
+
+// Rebuild replaces all entries, blob references and object versions with the
+// ones passed to the rebuilder by fill, and then marks the index as no longer
+// stale.
+func (ix *Index) Rebuild(fill func(r *Rebuilder) error) error {
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketsKey, refsKey, blobsKey, versionsKey, usageKey} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not reset index: %w", err)
+	}
+
+	r := &Rebuilder{ix: ix, batch: make([]func(tx *bolt.Tx) error, 0, rebuildBatchSize)}
+
+	if err := fill(r); err != nil {
+		return err
+	}
+
+	if err := r.flush(); err != nil {
+		return fmt.Errorf("could not write index: %w", err)
+	}
+
+	ix.stale = false
+
+	return nil
+}
+
+type ListOptions struct {
+	Prefix    string
+	Delimiter string
+
+	// Keys and common prefixes up to and including StartAfter are skipped
+	StartAfter string
+
+	MaxKeys int
+}
+
+type Page struct {
+	Entries        []*Entry
+	CommonPrefixes []string
+	IsTruncated    bool
+
+	// Last key or common prefix in the page
+	NextMarker string
+}
+
+// List returns a page of entries for keys under a prefix, in key order. Keys
+// sharing a prefix up to the delimiter are rolled up into common prefixes,
+// each counting as a single entry, which are skipped over with a single seek,
+// so a page only reads the entries it returns.
+func (ix *Index) List(bucket string, opts ListOptions) (*Page, error) {
+	page := &Page{}
+
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketsKey).Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		count := 0
+
+		start := max(opts.Prefix, opts.StartAfter)
+		k, v := c.Seek([]byte(start))
+
+		if k != nil && string(k) == opts.StartAfter {
+			k, v = c.Next()
+		}
+
+		for k != nil && strings.HasPrefix(string(k), opts.Prefix) {
+			key := string(k)
+
+			if opts.Delimiter != "" {
+				rest := key[len(opts.Prefix):]
+
+				if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+					prefix := opts.Prefix + rest[:i+len(opts.Delimiter)]
+
+					if prefix > opts.StartAfter {
+						if count == opts.MaxKeys {
+							page.IsTruncated = true
+							return nil
+						}
+
+						page.CommonPrefixes = append(page.CommonPrefixes, prefix)
+						page.NextMarker = prefix
+						count++
+					}
+
+					// Skip the remaining keys under the common prefix
+					next := successor(prefix)
+					if next == nil {
+						return nil
+					}
+
+					k, v = c.Seek(next)
+					continue
+				}
+			}
+
+			if count == opts.MaxKeys {
+				page.IsTruncated = true
+				return nil
+			}
+
+			entry := &Entry{Key: key}
+
+			if err := json.Unmarshal(v, entry); err != nil {
+				return fmt.Errorf("could not decode index entry %s: %w", key, err)
+			}
+
+			page.Entries = append(page.Entries, entry)
+			page.NextMarker = key
+			count++
+
+			k, v = c.Next()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// successor returns the smallest key greater than all keys with the given
+// prefix, or nil when there is none.
+func successor(prefix string) []byte {
+	next := []byte(prefix)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i] < 0xff {
+			next[i]++
+			return next[:i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// References to blobs are kept per bucket, from an ID that is unique to an
+// object version, or to a part of it, to the blob hash, along with the number
+// of references to each blob. Adding and removing a reference is idempotent,
+// so both can be safely repeated when resuming an interrupted write.
+
+var (
+	refsKey  = []byte("refs")
+	blobsKey = []byte("blobs")
+)
+
+func decodeCount(v []byte) int64 {
+	if len(v) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func encodeCount(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// addCount changes the reference count of a blob, dropping it at zero.
+func addCount(tx *bolt.Tx, hash []byte, delta int64) error {
+	blobs := tx.Bucket(blobsKey)
+	n := decodeCount(blobs.Get(hash)) + delta
+
+	if n <= 0 {
+		return blobs.Delete(hash)
+	}
+
+	return blobs.Put(hash, encodeCount(n))
+}
+
+// Ref is a reference to a blob, from an ID that is unique to an object
+// version, or to a part of it.
+type Ref struct {
+	ID   string
+	Hash string
+}
+
+// AddRefs references blobs from an object version, unless already done.
+func (ix *Index) AddRefs(bucket string, refs []Ref) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		for _, ref := range refs {
+			if err := addRef(tx, bucket, []byte(ref.ID), []byte(ref.Hash)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func addRef(tx *bolt.Tx, bucket string, id, hash []byte) error {
+	refs, err := tx.Bucket(refsKey).CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	if prev := refs.Get(id); prev != nil {
+		if string(prev) == string(hash) {
+			return nil
+		}
+
+		if err := addCount(tx, prev, -1); err != nil {
+			return err
+		}
+	}
+
+	if err := refs.Put(id, hash); err != nil {
+		return err
+	}
+
+	return addCount(tx, hash, 1)
+}
+
+// RemoveRefs removes the references from an object version, if any.
+func (ix *Index) RemoveRefs(bucket string, refs []Ref) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refsKey).Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		for _, ref := range refs {
+			hash := b.Get([]byte(ref.ID))
+			if hash == nil {
+				continue
+			}
+
+			if err := addCount(tx, hash, -1); err != nil {
+				return err
+			}
+
+			if err := b.Delete([]byte(ref.ID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DropRefs removes all references from a bucket, and returns the blobs that
+// were referenced.
+func (ix *Index) DropRefs(bucket string) ([]string, error) {
+	var hashes []string
+
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		refs := tx.Bucket(refsKey).Bucket([]byte(bucket))
+		if refs == nil {
+			return nil
+		}
+
+		seen := map[string]bool{}
+
+		err := refs.ForEach(func(_, hash []byte) error {
+			if !seen[string(hash)] {
+				seen[string(hash)] = true
+				hashes = append(hashes, string(hash))
+			}
+
+			return addCount(tx, hash, -1)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(refsKey).DeleteBucket([]byte(bucket))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+
+		return err
+	})
+
+	return hashes, err
+}
+
+// Refs returns the number of references to a blob.
+func (ix *Index) Refs(hash string) (int64, error) {
+	var n int64
+
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		n = decodeCount(tx.Bucket(blobsKey).Get([]byte(hash)))
+		return nil
+	})
+
+	return n, err
+}
+
+// AllRefs returns the number of references to every referenced blob.
+func (ix *Index) AllRefs() (map[string]int64, error) {
+	counts := map[string]int64{}
+
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blobsKey).ForEach(func(hash, v []byte) error {
+			counts[string(hash)] = decodeCount(v)
+			return nil
+		})
+	})
+
+	return counts, err
+}
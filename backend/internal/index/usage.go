@@ -0,0 +1,129 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Usage is tracked per bucket, from an ID that is unique to an object version
+// to its size, along with the number and total size of the versions, for
+// quotas. Delete markers are never tracked. Like blob references, adding and
+// removing a version is idempotent, so both can be safely repeated.
+
+var (
+	versionsKey = []byte("versions")
+	usageKey    = []byte("usage")
+)
+
+// Usage is the number of object versions stored in a bucket, and their total
+// size, as seen by clients.
+type Usage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func decodeUsage(v []byte) *Usage {
+	if len(v) != 16 {
+		return &Usage{}
+	}
+
+	return &Usage{
+		Objects: int64(binary.BigEndian.Uint64(v[:8])),
+		Bytes:   int64(binary.BigEndian.Uint64(v[8:])),
+	}
+}
+
+func encodeUsage(u *Usage) []byte {
+	return binary.BigEndian.AppendUint64(encodeCount(u.Objects), uint64(u.Bytes))
+}
+
+// addUsage changes the usage of a bucket, dropping it once empty.
+func addUsage(tx *bolt.Tx, bucket string, objects, bytes int64) error {
+	b := tx.Bucket(usageKey)
+	u := decodeUsage(b.Get([]byte(bucket)))
+
+	u.Objects += objects
+	u.Bytes += bytes
+
+	if u.Objects <= 0 {
+		return b.Delete([]byte(bucket))
+	}
+
+	return b.Put([]byte(bucket), encodeUsage(u))
+}
+
+// AddVersion tracks an object version of the given size, unless already done.
+func (ix *Index) AddVersion(bucket, id string, size int64) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		return addVersion(tx, bucket, []byte(id), size)
+	})
+}
+
+func addVersion(tx *bolt.Tx, bucket string, id []byte, size int64) error {
+	versions, err := tx.Bucket(versionsKey).CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	if versions.Get(id) != nil {
+		return nil
+	}
+
+	if err := versions.Put(id, encodeCount(size)); err != nil {
+		return err
+	}
+
+	return addUsage(tx, bucket, 1, size)
+}
+
+// RemoveVersion stops tracking an object version, if tracked.
+func (ix *Index) RemoveVersion(bucket, id string) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		versions := tx.Bucket(versionsKey).Bucket([]byte(bucket))
+		if versions == nil {
+			return nil
+		}
+
+		v := versions.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		if err := versions.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		return addUsage(tx, bucket, -1, -decodeCount(v))
+	})
+}
+
+// Usage returns the usage of a bucket, which is empty for unknown buckets.
+func (ix *Index) Usage(bucket string) (*Usage, error) {
+	var u *Usage
+
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		u = decodeUsage(tx.Bucket(usageKey).Get([]byte(bucket)))
+		return nil
+	})
+
+	return u, err
+}
+
+// dropUsage removes the tracked versions and usage of a bucket.
+func dropUsage(tx *bolt.Tx, bucket string) error {
+	err := tx.Bucket(versionsKey).DeleteBucket([]byte(bucket))
+	if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+
+	return tx.Bucket(usageKey).Delete([]byte(bucket))
+}
+
+// AddVersion tracks an object version of the given size.
+func (r *Rebuilder) AddVersion(bucket, id string, size int64) error {
+	return r.add(func(tx *bolt.Tx) error {
+		return addVersion(tx, bucket, []byte(id), size)
+	})
+}
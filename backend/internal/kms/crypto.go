@@ -0,0 +1,103 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+)
+
+const KeySize = 32
+
+var (
+	ErrNoRootKey  = errors.New("LS_ENCRYPTION_SECRET_KEY is not set")
+	errDecryption = errors.New("could not decrypt, the key or encryption context is wrong")
+)
+
+// RootKey decodes LS_ENCRYPTION_SECRET_KEY, a base64 encoded 256-bit key,
+// which wraps the SSE-S3 data keys and the material of every KMS key.
+func RootKey() ([]byte, error) {
+	if config.Env.EncryptionSecretKey == "" {
+		return nil, ErrNoRootKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.Env.EncryptionSecretKey)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("LS_ENCRYPTION_SECRET_KEY must be a base64 encoded 256-bit key")
+	}
+
+	return key, nil
+}
+
+// NewKey returns random key material.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate key: %w", err)
+	}
+
+	return key, nil
+}
+
+// Seal encrypts plaintext with AES-256-GCM under kek, prefixed by a random
+// nonce. The additional data must be given again to Open.
+func Seal(kek, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func Open(kek, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errDecryption
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errDecryption
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// contextAD serializes an encryption context as additional data. Map keys
+// are sorted by encoding/json, so the result is canonical.
+func contextAD(context map[string]string) []byte {
+	if len(context) == 0 {
+		return nil
+	}
+
+	data, _ := json.Marshal(context)
+	return data
+}
@@ -0,0 +1,69 @@
+package kms
+
+import "fmt"
+
+// DataKey is an object data key, as stored encrypted under a KMS key version.
+type DataKey struct {
+	KeyID      string
+	KeyVersion int
+	Ciphertext []byte
+}
+
+// GenerateDataKey returns a new data key in plaintext, and encrypted under
+// the current version of the KMS key (envelope encryption). The encryption
+// context must be given again to decrypt it.
+func GenerateDataKey(id string, context map[string]string) ([]byte, *DataKey, error) {
+	id = NormalizeKeyID(id)
+
+	key, err := enabledKey(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := key.current()
+
+	material, err := key.material(current.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plaintext, err := NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := Seal(material, plaintext, contextAD(context))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encrypt data key: %w", err)
+	}
+
+	dataKey := &DataKey{
+		KeyID:      id,
+		KeyVersion: current.Version,
+		Ciphertext: ciphertext,
+	}
+
+	return plaintext, dataKey, nil
+}
+
+// Decrypt returns the plaintext data key, failing when the KMS key is
+// disabled or the encryption context does not match.
+func Decrypt(dataKey *DataKey, context map[string]string) ([]byte, error) {
+	key, err := enabledKey(dataKey.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	material, err := key.material(dataKey.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(material, dataKey.Ciphertext, contextAD(context))
+}
+
+// CheckKey fails when the key cannot be used to generate data keys.
+func CheckKey(id string) error {
+	_, err := enabledKey(NormalizeKeyID(id))
+	return err
+}
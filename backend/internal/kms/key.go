@@ -0,0 +1,364 @@
+package kms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultKeyID is used for SSE-KMS requests without a key ID, taking the
+// place of the AWS managed aws/s3 key. It is created on first use.
+const DefaultKeyID = "default"
+
+var (
+	keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	// Serializes changes to keys, which are rewritten as a whole
+	keysMu sync.Mutex
+)
+
+func ErrorNotFound(id string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "KMS.NotFoundException",
+		Message:    fmt.Sprintf("Key '%s' does not exist", id),
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorDisabled(id string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "KMS.DisabledException",
+		Message:    fmt.Sprintf("Key '%s' is disabled", id),
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorAlreadyExists(id string) *core.S3Error {
+	return &core.S3Error{
+		Code:       "KMS.AlreadyExistsException",
+		Message:    fmt.Sprintf("Key '%s' already exists", id),
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+// KeyVersion holds the material of a key version, wrapped by the root key.
+// Rotation adds a version, and older versions are kept for decryption.
+type KeyVersion struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Material  []byte    `json:"material"`
+}
+
+type Key struct {
+	ID          string       `json:"id"`
+	Description string       `json:"description,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	Enabled     bool         `json:"enabled"`
+	Versions    []KeyVersion `json:"versions"`
+}
+
+// KeyInfo describes a key, without its material.
+type KeyInfo struct {
+	ID             string     `json:"id"`
+	Description    string     `json:"description,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	Enabled        bool       `json:"enabled"`
+	CurrentVersion int        `json:"currentVersion"`
+	RotatedAt      *time.Time `json:"rotatedAt,omitempty"`
+}
+
+func (k *Key) Info() *KeyInfo {
+	current := k.current()
+
+	info := &KeyInfo{
+		ID:             k.ID,
+		Description:    k.Description,
+		CreatedAt:      k.CreatedAt,
+		Enabled:        k.Enabled,
+		CurrentVersion: current.Version,
+	}
+
+	if current.Version > 1 {
+		info.RotatedAt = &current.CreatedAt
+	}
+
+	return info
+}
+
+func (k *Key) current() *KeyVersion {
+	return &k.Versions[len(k.Versions)-1]
+}
+
+func (k *Key) version(version int) (*KeyVersion, error) {
+	for i := range k.Versions {
+		if k.Versions[i].Version == version {
+			return &k.Versions[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("key %s has no version %d", k.ID, version)
+}
+
+func keysPath(elem ...string) string {
+	return config.SystemPath(append([]string{"kms", "keys"}, elem...)...)
+}
+
+// NormalizeKeyID accepts key IDs as given by S3 clients, either plain, as an
+// alias/ name, or as a key ARN. An empty key ID means the default key.
+func NormalizeKeyID(id string) string {
+	if id == "" {
+		return DefaultKeyID
+	}
+
+	if _, after, ok := strings.Cut(id, ":key/"); ok && strings.HasPrefix(id, "arn:") {
+		id = after
+	}
+
+	return strings.TrimPrefix(id, "alias/")
+}
+
+func readKey(id string) (*Key, error) {
+	if !keyIDPattern.MatchString(id) {
+		return nil, ErrorNotFound(id)
+	}
+
+	data, err := storage.ReadFile(keysPath(id + ".json"))
+	if storage.IsNotExist(err) {
+		return nil, ErrorNotFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %w", err)
+	}
+
+	var key Key
+
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("could not decode key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func writeKey(key *Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("could not encode key: %w", err)
+	}
+
+	if err := storage.WriteFile(keysPath(key.ID+".json"), data); err != nil {
+		return fmt.Errorf("could not write key: %w", err)
+	}
+
+	return nil
+}
+
+// newKeyVersion generates key material, wrapped by the root key.
+func newKeyVersion(version int) (*KeyVersion, error) {
+	root, err := RootKey()
+	if errors.Is(err, ErrNoRootKey) {
+		return nil, core.ErrorInvalidRequest("Key management is not configured on this server")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	material, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := Seal(root, material, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not wrap key material: %w", err)
+	}
+
+	keyVersion := &KeyVersion{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		Material:  wrapped,
+	}
+
+	return keyVersion, nil
+}
+
+func CreateKey(id, description string) (*KeyInfo, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	return createKey(id, description)
+}
+
+func createKey(id, description string) (*KeyInfo, error) {
+	if !keyIDPattern.MatchString(id) {
+		return nil, core.ErrorInvalidArgument("Key IDs must have 1 to 64 letters, digits, underscores or dashes")
+	}
+
+	if _, err := storage.Stat(keysPath(id + ".json")); err == nil {
+		return nil, ErrorAlreadyExists(id)
+	}
+
+	keyVersion, err := newKeyVersion(1)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:          id,
+		Description: description,
+		CreatedAt:   keyVersion.CreatedAt,
+		Enabled:     true,
+		Versions:    []KeyVersion{*keyVersion},
+	}
+
+	if err := writeKey(key); err != nil {
+		return nil, err
+	}
+
+	return key.Info(), nil
+}
+
+// ListKeys returns all keys, sorted by ID.
+func ListKeys() ([]*KeyInfo, error) {
+	entries, err := storage.List(keysPath())
+	if storage.IsNotExist(err) {
+		return []*KeyInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys: %w", err)
+	}
+
+	keys := []*KeyInfo{}
+
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(path.Base(e.Name), ".json")
+		if !ok {
+			continue
+		}
+
+		key, err := readKey(id)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key.Info())
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func DescribeKey(id string) (*KeyInfo, error) {
+	key, err := readKey(NormalizeKeyID(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Info(), nil
+}
+
+// RotateKey adds a new version of the key material, used for all new data
+// keys. Existing data keys are still decrypted by their original version.
+func RotateKey(id string) (*KeyInfo, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	key, err := readKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.Enabled {
+		return nil, ErrorDisabled(id)
+	}
+
+	keyVersion, err := newKeyVersion(key.current().Version + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Versions = append(key.Versions, *keyVersion)
+
+	if err := writeKey(key); err != nil {
+		return nil, err
+	}
+
+	return key.Info(), nil
+}
+
+// DisableKey stops the key from being used for encryption or decryption, so
+// all objects encrypted under it become unreadable.
+func DisableKey(id string) (*KeyInfo, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	key, err := readKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Enabled = false
+
+	if err := writeKey(key); err != nil {
+		return nil, err
+	}
+
+	return key.Info(), nil
+}
+
+// enabledKey loads a key for encryption or decryption, creating the default
+// key on first use.
+func enabledKey(id string) (*Key, error) {
+	key, err := readKey(id)
+
+	var s3Error *core.S3Error
+
+	if id == DefaultKeyID && errors.As(err, &s3Error) && s3Error.Code == ErrorNotFound(id).Code {
+		keysMu.Lock()
+
+		if key, err = readKey(id); err != nil {
+			if _, err = createKey(id, "Default key for SSE-KMS"); err == nil {
+				key, err = readKey(id)
+			}
+		}
+
+		keysMu.Unlock()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.Enabled {
+		return nil, ErrorDisabled(id)
+	}
+
+	return key, nil
+}
+
+func (k *Key) material(version int) ([]byte, error) {
+	keyVersion, err := k.version(version)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := RootKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(root, keyVersion.Material, nil)
+}
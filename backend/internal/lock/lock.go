@@ -0,0 +1,243 @@
+// Package lock coordinates concurrent requests on the same buckets and
+// objects, through read/write locks over their names.
+package lock
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorOperationAborted() *core.S3Error {
+	return &core.S3Error{
+		Code:       "OperationAborted",
+		Message:    "A conflicting conditional operation is currently in progress against this resource. Try again.",
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+// Resource is a lock request on a name, which is either a bucket, an object or
+// a blob, as returned by Bucket, Object and Blob.
+type Resource struct {
+	Name  string
+	Write bool
+}
+
+// Bucket names are locked for reading by all object operations, so that
+// locking a bucket for writing waits for them.
+func Bucket(bucket string, write bool) Resource {
+	return Resource{Name: bucket, Write: write}
+}
+
+// Object names sort right after their bucket, which is what keeps the lock
+// order consistent, given bucket names cannot contain slashes.
+func Object(bucket, key string, write bool) Resource {
+	return Resource{Name: bucket + "/" + key, Write: write}
+}
+
+// Blob names start with a dot, which bucket names cannot, so they never clash
+// with buckets and objects. Blobs are always locked on their own, while their
+// references change.
+func Blob(hash string) Resource {
+	return Resource{Name: ".blobs/" + hash, Write: true}
+}
+
+// ObjectInBucket locks an object along with its bucket, for reading, which is
+// what most object operations need.
+func ObjectInBucket(bucket, key string, write bool) []Resource {
+	return []Resource{Bucket(bucket, false), Object(bucket, key, write)}
+}
+
+type entry struct {
+	readers        int
+	writer         bool
+	writersWaiting int
+
+	// Holders and waiters, so the entry is dropped when no longer used
+	refs int
+
+	// Closed and replaced whenever the lock is released
+	released chan struct{}
+}
+
+// Manager holds read/write locks over names, created on demand. Writers are
+// preferred over new readers, so they are never starved. A zero timeout waits
+// for as long as the context allows.
+type Manager struct {
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		Timeout: timeout,
+		entries: map[string]*entry{},
+	}
+}
+
+// Default is the lock manager for the bucket and object namespace, with its
+// timeout set on startup, from LS_LOCK_TIMEOUT.
+var Default = NewManager(0)
+
+// Lock acquires the locks on all resources, using the default manager.
+func Lock(ctx context.Context, resources ...Resource) (func(), error) {
+	return Default.Lock(ctx, resources...)
+}
+
+// Lock acquires the locks on all resources, in name order, which prevents
+// deadlocks between requests locking overlapping names. Resources repeated
+// with different modes are locked for writing. It fails with OperationAborted
+// when the locks are not acquired within the timeout, and otherwise returns a
+// function that releases them.
+func (m *Manager) Lock(ctx context.Context, resources ...Resource) (func(), error) {
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	resources = normalize(resources)
+
+	for i, r := range resources {
+		if err := m.acquire(ctx, r); err != nil {
+			m.releaseAll(resources[:i])
+
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, ErrorOperationAborted()
+			}
+
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+
+	unlock := func() {
+		once.Do(func() {
+			m.releaseAll(resources)
+		})
+	}
+
+	return unlock, nil
+}
+
+// normalize sorts resources by name, merging duplicates.
+func normalize(resources []Resource) []Resource {
+	merged := map[string]bool{}
+
+	for _, r := range resources {
+		merged[r.Name] = merged[r.Name] || r.Write
+	}
+
+	normalized := make([]Resource, 0, len(merged))
+
+	for name, write := range merged {
+		// Names may come from request parameters, which fiber reuses
+		normalized = append(normalized, Resource{Name: strings.Clone(name), Write: write})
+	}
+
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i].Name < normalized[j].Name
+	})
+
+	return normalized
+}
+
+func (m *Manager) acquire(ctx context.Context, r Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[r.Name]
+	if !ok {
+		e = &entry{released: make(chan struct{})}
+		m.entries[r.Name] = e
+	}
+
+	e.refs++
+
+	for {
+		if r.Write && !e.writer && e.readers == 0 {
+			e.writer = true
+			return nil
+		}
+
+		if !r.Write && !e.writer && e.writersWaiting == 0 {
+			e.readers++
+			return nil
+		}
+
+		if r.Write {
+			e.writersWaiting++
+		}
+
+		released := e.released
+
+		m.mu.Unlock()
+
+		var err error
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		m.mu.Lock()
+
+		if r.Write {
+			e.writersWaiting--
+		}
+
+		if err != nil {
+			// Readers held back by this writer may now go ahead
+			if r.Write {
+				e.notify()
+			}
+
+			m.drop(r.Name, e)
+			return err
+		}
+	}
+}
+
+func (m *Manager) releaseAll(resources []Resource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range resources {
+		e := m.entries[r.Name]
+
+		if r.Write {
+			e.writer = false
+		} else {
+			e.readers--
+		}
+
+		e.notify()
+		m.drop(r.Name, e)
+	}
+}
+
+// notify wakes up all waiters on an entry, which then check whether they can
+// go ahead.
+func (e *entry) notify() {
+	close(e.released)
+	e.released = make(chan struct{})
+}
+
+func (m *Manager) drop(name string, e *entry) {
+	e.refs--
+
+	if e.refs == 0 {
+		delete(m.entries, name)
+	}
+}
@@ -0,0 +1,299 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+)
+
+// waitFor polls until the entry of a name is referenced by the given number of
+// holders and waiters.
+func waitFor(t *testing.T, m *Manager, name string, refs int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		e := m.entries[name]
+		n := 0
+
+		if e != nil {
+			n = e.refs
+		}
+
+		m.mu.Unlock()
+
+		if n == refs {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("%s never reached %d references", name, refs)
+}
+
+func lockOrFail(t *testing.T, m *Manager, resources ...Resource) func() {
+	t.Helper()
+
+	unlock, err := m.Lock(context.Background(), resources...)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	return unlock
+}
+
+func TestWriterPreference(t *testing.T) {
+	m := NewManager(0)
+
+	unlockReader := lockOrFail(t, m, Resource{Name: "a"})
+
+	var mu sync.Mutex
+	var order []string
+
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		order = append(order, event)
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		unlock := lockOrFail(t, m, Resource{Name: "a", Write: true})
+		record("writer")
+		time.Sleep(10 * time.Millisecond)
+		record("writer done")
+		unlock()
+	}()
+
+	waitFor(t, m, "a", 2)
+
+	// A new reader must wait behind the waiting writer, even though the lock
+	// is only held for reading
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		unlock := lockOrFail(t, m, Resource{Name: "a"})
+		record("reader")
+		unlock()
+	}()
+
+	waitFor(t, m, "a", 3)
+
+	record("first reader done")
+	unlockReader()
+	wg.Wait()
+
+	want := []string{"first reader done", "writer", "writer done", "reader"}
+
+	if !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	if len(m.entries) != 0 {
+		t.Errorf("%d entries left after release", len(m.entries))
+	}
+}
+
+func TestReadersShare(t *testing.T) {
+	m := NewManager(0)
+
+	unlock1 := lockOrFail(t, m, Resource{Name: "a"})
+	unlock2 := lockOrFail(t, m, Resource{Name: "a"})
+
+	unlock1()
+	unlock2()
+
+	// Releasing twice must not release the lock of another holder
+	unlock3 := lockOrFail(t, m, Resource{Name: "a"})
+	unlock1()
+
+	m.mu.Lock()
+	readers := m.entries["a"].readers
+	m.mu.Unlock()
+
+	if readers != 1 {
+		t.Errorf("readers = %d, want 1", readers)
+	}
+
+	unlock3()
+}
+
+func TestNormalize(t *testing.T) {
+	got := normalize([]Resource{
+		Object("bucket", "key", false),
+		Bucket("bucket", false),
+		Object("bucket", "key", true),
+		Bucket("another", true),
+	})
+
+	want := []Resource{
+		{Name: "another", Write: true},
+		{Name: "bucket", Write: false},
+		{Name: "bucket/key", Write: true},
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("normalize = %v, want %v", got, want)
+	}
+}
+
+func TestOverlappingLocksDoNotDeadlock(t *testing.T) {
+	m := NewManager(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	names := []string{"a", "b", "c", "d"}
+
+	// Only changed under the write lock of their name, which the race
+	// detector checks
+	counters := map[string]*int{}
+
+	for _, name := range names {
+		counters[name] = new(int)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range 32 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Each caller passes an overlapping subset, in its own order
+			var resources []Resource
+
+			for j := range names {
+				name := names[(i+j)%len(names)]
+
+				if (i>>j)&1 == 0 || j == 0 {
+					resources = append(resources, Resource{Name: name, Write: true})
+				}
+			}
+
+			if i%2 == 1 {
+				slices.Reverse(resources)
+			}
+
+			for range 200 {
+				unlock, err := m.Lock(ctx, resources...)
+				if err != nil {
+					t.Errorf("Lock(%v): %v", resources, err)
+					return
+				}
+
+				for _, r := range resources {
+					*counters[r.Name]++
+				}
+
+				unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		t.Fatal("locks deadlocked")
+	}
+
+	if len(m.entries) != 0 {
+		t.Errorf("%d entries left after release", len(m.entries))
+	}
+}
+
+func TestCancelReleases(t *testing.T) {
+	m := NewManager(0)
+
+	unlockB := lockOrFail(t, m, Resource{Name: "b"})
+	defer unlockB()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	// Acquires a, and then waits for b
+	go func() {
+		_, err := m.Lock(ctx, Resource{Name: "b", Write: true}, Resource{Name: "a", Write: true})
+		done <- err
+	}()
+
+	waitFor(t, m, "b", 2)
+
+	// A new reader is held back by the waiting writer
+	readerDone := make(chan error)
+
+	go func() {
+		unlock, err := m.Lock(context.Background(), Resource{Name: "b"})
+		if err == nil {
+			unlock()
+		}
+
+		readerDone <- err
+	}()
+
+	waitFor(t, m, "b", 3)
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Lock after cancel = %v, want context.Canceled", err)
+	}
+
+	// Once the writer gives up, the reader goes ahead
+	select {
+	case err := <-readerDone:
+		if err != nil {
+			t.Fatalf("reader: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader still waiting after the writer was cancelled")
+	}
+
+	// The lock on a, acquired before waiting, must be released
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unlockA, err := m.Lock(ctx, Resource{Name: "a", Write: true})
+	if err != nil {
+		t.Fatalf("Lock(a) after cancel: %v", err)
+	}
+
+	unlockA()
+	unlockB()
+
+	if len(m.entries) != 0 {
+		t.Errorf("%d entries left after release", len(m.entries))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m := NewManager(20 * time.Millisecond)
+
+	unlock := lockOrFail(t, m, Resource{Name: "a", Write: true})
+	defer unlock()
+
+	_, err := m.Lock(context.Background(), Resource{Name: "a"})
+
+	var s3Error *core.S3Error
+
+	if !errors.As(err, &s3Error) || s3Error.Code != ErrorOperationAborted().Code {
+		t.Errorf("Lock past the timeout = %v, want OperationAborted", err)
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// IsAdmin checks whether the request was signed with the admin credentials.
+func IsAdmin(c *fiber.Ctx) bool {
+	return AccessKey(c) != "" && AccessKey(c) == config.Env.AdminAccessKey
+}
+
+// WithAdmin restricts a handler to the admin user.
+func WithAdmin(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !IsAdmin(c) {
+			err := core.ErrorAccessDenied()
+			core.HandleError(c, err)
+			return nil
+		}
+
+		return handler(c)
+	}
+}
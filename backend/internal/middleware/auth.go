@@ -3,6 +3,7 @@ package middleware
 import (
 	"github.com/DataLabTechTV/labstore/backend/internal/auth"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -14,10 +15,16 @@ var ErrorInvalidAccessKey = &core.S3Error{
 
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" && !auth.IsPresigned(c) {
+			logger.Log.Debug("Anonymous request: ", c.Path())
+			return c.Next()
+		}
+
 		accessKey, err := auth.VerifyAWSSigV4(c)
 		if err != nil {
-			core.HandleError(c, err)
-			return c.Next()
+			logger.Log.Error(err.Error())
+			core.HandleError(c, ErrorInvalidAccessKey)
+			return nil
 		}
 
 		c.Locals("accessKey", accessKey)
@@ -25,3 +32,10 @@ func AuthMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// AccessKey returns the access key of the authenticated principal, or an empty
+// string for anonymous requests.
+func AccessKey(c *fiber.Ctx) string {
+	accessKey, _ := c.Locals("accessKey").(string)
+	return accessKey
+}
@@ -1,38 +1,108 @@
 package middleware
 
 import (
+	"net/url"
+
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
 	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/gofiber/fiber/v2"
 )
 
+// WithIAM checks the policy for the given action over the :bucket route
+// parameter, before calling the handler. Route-level middleware cannot be used
+// for this, as it runs before the action is known.
 func WithIAM(action iam.Action, handler fiber.Handler) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		c.Locals("iamAction", action)
+		check := CheckIAM
+
+		if existingTagActions[action] {
+			check = CheckIAMBeforeTags
+		}
+
+		if !check(c, action, c.Params("bucket")) {
+			err := core.ErrorAccessDenied()
+			core.HandleError(c, err)
+			return nil
+		}
+
 		return handler(c)
 	}
 }
 
-func IAMMiddleware() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		action, _ := c.Locals("iamAction").(string)
-		if action == "" {
-			return c.Next()
-		}
+// CheckIAM checks whether the requester is allowed to run an action over a
+// bucket, for handlers that need to authorize additional resources (e.g., the
+// source of a copy).
+func CheckIAM(c *fiber.Ctx, action iam.Action, bucket string) bool {
+	return CheckIAMWithConditions(c, action, bucket, requestConditions(c))
+}
 
-		bucket := c.Params("bucket")
-		if bucket == "" {
-			return c.Next()
-		}
+func CheckIAMWithConditions(c *fiber.Ctx, action iam.Action, bucket string, conditions iam.Conditions) bool {
+	if bucket == "" {
+		return true
+	}
 
-		accessKey := c.Locals("accessKey").(string)
+	return iam.CheckPolicy(AccessKey(c), bucket, string(action), conditions)
+}
+
+// Actions whose handlers read the object and call CheckObjectTags, so that
+// conditions on s3:ExistingObjectTag can be deferred until then.
+var existingTagActions = map[iam.Action]bool{
+	iam.GetObject:                  true,
+	iam.GetObjectVersion:           true,
+	iam.GetObjectTagging:           true,
+	iam.PutObjectTagging:           true,
+	iam.DeleteObjectTagging:        true,
+	iam.GetObjectVersionTagging:    true,
+	iam.PutObjectVersionTagging:    true,
+	iam.DeleteObjectVersionTagging: true,
+}
 
-		if !iam.CheckPolicy(accessKey, bucket, action) {
-			core.HandleError(c, core.ErrorAccessDenied())
-			// !FIXME: proper S3 error handling
-			return fiber.ErrForbidden
+// CheckIAMBeforeTags is CheckIAM for actions over an object that is not read
+// yet, deferring conditions on its existing tags. The handler must call
+// CheckObjectTags once the object is read.
+func CheckIAMBeforeTags(c *fiber.Ctx, action iam.Action, bucket string) bool {
+	deferred, _ := c.Locals("iamDeferred").([]iam.Action)
+	c.Locals("iamDeferred", append(deferred, action))
+
+	conditions := requestConditions(c).Defer(iam.ExistingObjectTag)
+
+	return CheckIAMWithConditions(c, action, bucket, conditions)
+}
+
+// CheckObjectTags checks the actions authorized by CheckIAMBeforeTags again,
+// once the existing tags of the object and any tags set by the request body
+// are known, so that policies can rely on s3:ExistingObjectTag and
+// s3:RequestObjectTag.
+func CheckObjectTags(c *fiber.Ctx, bucket string, existing, requested map[string]string) bool {
+	deferred, _ := c.Locals("iamDeferred").([]iam.Action)
+
+	conditions := requestConditions(c).
+		AddTags(iam.ExistingObjectTag, existing).
+		AddTags(iam.RequestObjectTag, requested)
+
+	for _, action := range deferred {
+		if !CheckIAMWithConditions(c, action, bucket, conditions) {
+			return false
 		}
+	}
 
-		return c.Next()
+	return true
+}
+
+// requestConditions collects condition keys from request headers, where
+// x-amz-tagging provides s3:RequestObjectTag. Malformed tags are rejected by
+// the handler.
+func requestConditions(c *fiber.Ctx) iam.Conditions {
+	conditions := iam.Conditions{}
+
+	values, err := url.ParseQuery(c.Get("X-Amz-Tagging"))
+	if err != nil {
+		return conditions
 	}
+
+	for k := range values {
+		conditions[iam.RequestObjectTag+k] = values.Get(k)
+	}
+
+	return conditions
 }
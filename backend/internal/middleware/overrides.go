@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ResponseOverrides are the query parameters that override the corresponding
+// response headers of GetObject.
+var ResponseOverrides = []struct {
+	Param  string
+	Header string
+}{
+	{"response-content-type", fiber.HeaderContentType},
+	{"response-content-language", fiber.HeaderContentLanguage},
+	{"response-expires", fiber.HeaderExpires},
+	{"response-cache-control", fiber.HeaderCacheControl},
+	{"response-content-disposition", fiber.HeaderContentDisposition},
+	{"response-content-encoding", fiber.HeaderContentEncoding},
+}
+
+// WithResponseOverrides rejects response header overrides on anonymous
+// requests, which S3 only allows for signed requests. As per S3, this is
+// checked before the policy, so it must wrap WithIAM.
+func WithResponseOverrides(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if AccessKey(c) != "" {
+			return handler(c)
+		}
+
+		for _, o := range ResponseOverrides {
+			if c.Query(o.Param) != "" {
+				err := core.ErrorInvalidRequest(
+					"Request specific response headers cannot be used for anonymous GET requests.",
+				)
+				core.HandleError(c, err)
+				return nil
+			}
+		}
+
+		return handler(c)
+	}
+}
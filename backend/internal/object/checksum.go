@@ -0,0 +1,94 @@
+package object
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CRC-64/NVME polynomial, in reversed form, as expected by hash/crc64
+const crc64NVMEPoly = 0x9a6c9329ac4bc9b5
+
+var crc64NVMETable = crc64.MakeTable(crc64NVMEPoly)
+
+func ErrorBadDigest() *core.S3Error {
+	return &core.S3Error{
+		Code:       "BadDigest",
+		Message:    "The Content-MD5 or checksum value that you specified did not match what the server received",
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+func ErrorInvalidDigest() *core.S3Error {
+	return &core.S3Error{
+		Code:       "InvalidDigest",
+		Message:    "The Content-MD5 or checksum value that you specified is not valid",
+		StatusCode: fiber.StatusBadRequest,
+	}
+}
+
+// Supported x-amz-checksum-* algorithms, as lowercase header suffixes
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"crc32":     func() hash.Hash { return crc32.NewIEEE() },
+	"crc32c":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"crc64nvme": func() hash.Hash { return crc64.New(crc64NVMETable) },
+	"sha1":      sha1.New,
+	"sha256":    sha256.New,
+}
+
+// VerifyBodyChecksum validates the request body against Content-MD5 and any
+// x-amz-checksum-* header. When required, at least one must be present.
+func VerifyBodyChecksum(c *fiber.Ctx, required bool) error {
+	body := c.Body()
+	found := false
+
+	if contentMD5 := c.Get("Content-MD5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return ErrorInvalidDigest()
+		}
+
+		actual := md5.Sum(body)
+		if string(expected) != string(actual[:]) {
+			return ErrorBadDigest()
+		}
+
+		found = true
+	}
+
+	for algorithm, newHash := range checksumAlgorithms {
+		value := c.Get("X-Amz-Checksum-" + algorithm)
+		if value == "" {
+			continue
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ErrorInvalidDigest()
+		}
+
+		h := newHash()
+		h.Write(body)
+
+		if string(expected) != string(h.Sum(nil)) {
+			return ErrorBadDigest()
+		}
+
+		found = true
+	}
+
+	if required && !found {
+		return core.ErrorInvalidRequest(
+			"Missing required header for this request: Content-MD5 or x-amz-checksum-*",
+		)
+	}
+
+	return nil
+}
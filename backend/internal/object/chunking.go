@@ -0,0 +1,317 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/cdc"
+	"github.com/DataLabTechTV/labstore/backend/internal/compression"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+// Chunk is a content-defined chunk of an object version, stored as a blob,
+// given by the SHA-256 of its stored data. Each chunk is compressed on its
+// own, so that it can be shared by objects with different chunk boundaries.
+type Chunk struct {
+	Hash        string            `json:"hash"`
+	Size        int64             `json:"size"`
+	Compression *compression.Info `json:"compression,omitempty"`
+}
+
+func (c *Chunk) storedSize() int64 {
+	if c.Compression != nil {
+		return c.Compression.StoredSize
+	}
+
+	return c.Size
+}
+
+// chunking returns the chunk sizes for new object data, or nil when it is not
+// chunked. Encrypted data is never chunked, as each object version has its own
+// data key, so its chunks would never be shared.
+func chunking(bucketName string, req *sse.Request, size int64) (*cdc.Params, error) {
+	if req != nil || size == 0 {
+		return nil, nil
+	}
+
+	return bucket.ChunkingParams(bucketName)
+}
+
+// writeChunks is the same as writeBlob, but splits the data into chunks, and
+// stores each one as a blob. Chunks that are already stored are pinned, and
+// only the new ones are staged, so that the version only references its
+// blobs once all of them are stored.
+func writeChunks(
+	ctx context.Context, bucketName, key string, meta *Metadata, r io.Reader, params cdc.Params, algorithm string,
+) error {
+	var (
+		chunks []Chunk
+		refs   []blob.Ref
+		staged = map[string]string{}
+		unpins = map[string]func(){}
+	)
+
+	defer func() {
+		for _, unpin := range unpins {
+			unpin()
+		}
+	}()
+
+	discard := func() {
+		for _, name := range staged {
+			storage.Delete(name)
+		}
+	}
+
+	chunker := cdc.NewChunker(r, params)
+
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discard()
+			return err
+		}
+
+		var buf bytes.Buffer
+
+		info, err := encodeData(&buf, bytes.NewReader(data), nil, algorithm)
+		if err != nil {
+			discard()
+			return err
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		hash := hex.EncodeToString(sum[:])
+
+		chunks = append(chunks, Chunk{Hash: hash, Size: int64(len(data)), Compression: info})
+		ref := blob.Ref{ID: meta.chunkRef(key, len(refs)), Hash: hash}
+
+		if _, ok := unpins[hash]; !ok {
+			exists, unpin, err := blob.Pin(ctx, hash)
+			if err != nil {
+				discard()
+				return err
+			}
+
+			unpins[hash] = unpin
+
+			if !exists {
+				name := stagingPath()
+
+				if _, err := storage.Default.Put(ctx, name, &buf); err != nil {
+					storage.Delete(name)
+					discard()
+					return err
+				}
+
+				staged[hash] = name
+				ref.Staged = name
+			}
+		}
+
+		refs = append(refs, ref)
+	}
+
+	if err := blob.Put(ctx, bucketName, refs); err != nil {
+		discard()
+		return err
+	}
+
+	meta.Chunks = chunks
+
+	return nil
+}
+
+// linksChunks reports whether a copy can reference the chunks of its source,
+// which requires them to be compressed with the same algorithm.
+func linksChunks(srcMeta *Metadata, algorithm string) bool {
+	if len(srcMeta.Chunks) == 0 {
+		return false
+	}
+
+	for _, chunk := range srcMeta.Chunks {
+		if chunk.Compression == nil && algorithm != "" ||
+			chunk.Compression != nil && chunk.Compression.Algorithm != algorithm {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyChunks is the same as copyBlob, but splits the copy into chunks. When
+// the chunks stay the same, the copy only references the source chunks.
+func copyChunks(
+	ctx context.Context, srcPath, srcARN string, srcMeta *Metadata, srcReq *sse.Request,
+	dstBucket, dstKey string, dstMeta *Metadata, params cdc.Params, algorithm string,
+) error {
+	if linksChunks(srcMeta, algorithm) {
+		dstMeta.Chunks = srcMeta.Chunks
+
+		if err := blob.Put(ctx, dstBucket, dstMeta.blobRefs(dstKey)); err != nil {
+			dstMeta.Chunks = nil
+			return err
+		}
+
+		return nil
+	}
+
+	src, err := openData(ctx, srcPath, srcARN, srcMeta, srcReq)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeChunks(ctx, dstBucket, dstKey, dstMeta, src, params, algorithm)
+}
+
+// chunkReader reads the data of a chunked object version. Seeking only opens
+// the chunk holding the new offset, so range reads skip all other chunks.
+type chunkReader struct {
+	ctx    context.Context
+	chunks []Chunk
+	ends   []int64
+	offset int64
+
+	// Current chunk, opened at the offset, if any
+	index int
+	r     io.ReadSeekCloser
+}
+
+func newChunkReader(ctx context.Context, chunks []Chunk) *chunkReader {
+	ends := make([]int64, len(chunks))
+
+	var end int64
+
+	for i, chunk := range chunks {
+		end += chunk.Size
+		ends[i] = end
+	}
+
+	return &chunkReader{ctx: ctx, chunks: chunks, ends: ends, index: -1}
+}
+
+func (r *chunkReader) size() int64 {
+	if len(r.ends) == 0 {
+		return 0
+	}
+
+	return r.ends[len(r.ends)-1]
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size() {
+		return 0, io.EOF
+	}
+
+	if r.r == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	end := r.ends[r.index]
+
+	n, err := r.r.Read(p[:min(int64(len(p)), end-r.offset)])
+	r.offset += int64(n)
+
+	if r.offset == end {
+		r.closeChunk()
+	}
+
+	if errors.Is(err, io.EOF) {
+		if n > 0 {
+			return n, nil
+		}
+
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// open opens the chunk holding the current offset, positioned at it.
+func (r *chunkReader) open() error {
+	index := sort.Search(len(r.ends), func(i int) bool { return r.ends[i] > r.offset })
+	chunk := r.chunks[index]
+
+	f, err := storage.Default.Get(r.ctx, blob.Path(chunk.Hash))
+	if err != nil {
+		return fmt.Errorf("could not open chunk %s: %w", chunk.Hash, err)
+	}
+
+	var cr io.ReadSeekCloser = f
+
+	if chunk.Compression != nil {
+		cr = compression.NewReader(f, chunk.Size, chunk.Compression)
+	}
+
+	if _, err := cr.Seek(r.offset-(r.ends[index]-chunk.Size), io.SeekStart); err != nil {
+		cr.Close()
+		return fmt.Errorf("could not seek chunk %s: %w", chunk.Hash, err)
+	}
+
+	r.index = index
+	r.r = cr
+
+	return nil
+}
+
+func (r *chunkReader) closeChunk() {
+	if r.r != nil {
+		r.r.Close()
+		r.r = nil
+	}
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if offset != r.offset {
+		r.closeChunk()
+		r.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.closeChunk()
+	return nil
+}
+
+// chunksStored reports whether all chunks of an object version are stored,
+// with the expected size. Errors other than a missing chunk are returned.
+func chunksStored(chunks []Chunk) (bool, error) {
+	for _, chunk := range chunks {
+		if ok, err := dataStored(blob.Path(chunk.Hash), chunk.storedSize()); !ok || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
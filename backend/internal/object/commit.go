@@ -0,0 +1,350 @@
+package object
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// New object data is staged first, and then committed along with its metadata
+// through a commit record, written once the data is fully staged. When the
+// server stops before the commit completes, it is rolled forward on startup,
+// while staged data without a record is discarded. This way, an object never
+// has its data and metadata out of sync.
+
+type commitRecord struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Status   string    `json:"status"`
+	Staged   string    `json:"staged"`
+	Metadata *Metadata `json:"metadata"`
+}
+
+func commitsPath(elem ...string) string {
+	return config.SystemPath(append([]string{"commits"}, elem...)...)
+}
+
+// commitObject makes staged data the latest version of an object, archiving
+// the current latest version as required by the versioning status. Staged
+// data is removed when the commit fails, and so is the reference to the blob
+// of a deduplicated object. Inline and deduplicated objects have no staged
+// data.
+func commitObject(bucketName, key, status, staged string, meta *Metadata) error {
+	record := &commitRecord{
+		Bucket:   bucketName,
+		Key:      key,
+		Status:   status,
+		Staged:   staged,
+		Metadata: meta,
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		record.discard()
+		return fmt.Errorf("could not encode commit record: %w", err)
+	}
+
+	recordPath := commitsPath(uuid.NewString() + ".json")
+
+	if err := storage.WriteFile(recordPath, data); err != nil {
+		record.discard()
+		return fmt.Errorf("could not write commit record: %w", err)
+	}
+
+	if err = record.apply(); err != nil {
+		record.discard()
+	}
+
+	storage.Delete(recordPath)
+
+	return err
+}
+
+// discard cleans up after a failed commit.
+func (r *commitRecord) discard() {
+	if r.Staged != "" {
+		storage.Delete(r.Staged)
+	}
+
+	if err := releaseBlobs(r.Bucket, r.Key, r.Metadata); err != nil {
+		logger.Log.Warnf("Could not release blobs of %s/%s: %s", r.Bucket, r.Key, err)
+	}
+}
+
+// apply runs the steps of a commit, which can be safely repeated. The staged
+// data is only gone once it was moved into place, so archiving is skipped
+// from then on.
+func (r *commitRecord) apply() error {
+	// Objects without metadata have no blob, and are never the new version
+	replaced, err := readVersionMetadata(metadataPath(r.Bucket, r.Key))
+	if err != nil && !storage.IsNotExist(err) {
+		return err
+	}
+
+	if r.Staged == "" {
+		err = r.applyMetadata(replaced)
+	} else {
+		err = r.applyStaged()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := WriteMetadata(r.Bucket, r.Key, r.Metadata); err != nil {
+		return err
+	}
+
+	r.releaseReplaced(replaced)
+
+	return nil
+}
+
+func (r *commitRecord) applyStaged() error {
+	if _, err := storage.Stat(r.Staged); err != nil {
+		return nil
+	}
+
+	if err := archiveLatest(r.Bucket, r.Key, r.Status); err != nil {
+		return err
+	}
+
+	if err := storage.Rename(r.Staged, objectPath(r.Bucket, r.Key)); err != nil {
+		return fmt.Errorf("could not commit object: %w", err)
+	}
+
+	return nil
+}
+
+// applyMetadata is the same as applyStaged, for inline and deduplicated
+// objects, where archiving is skipped once the new version is in place. Any
+// data left by the replaced version of an unversioned object is removed.
+func (r *commitRecord) applyMetadata(latest *Metadata) error {
+	committed := latest != nil &&
+		latest.VersionID == r.Metadata.VersionID &&
+		latest.LastModified.Equal(r.Metadata.LastModified)
+
+	if !committed {
+		if err := archiveLatest(r.Bucket, r.Key, r.Status); err != nil {
+			return err
+		}
+	}
+
+	err := storage.Delete(objectPath(r.Bucket, r.Key))
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not replace object: %w", err)
+	}
+
+	return nil
+}
+
+// releaseReplaced releases the blobs of the latest version of an unversioned
+// object, once overwritten, and removes it from the bucket usage. Failing to
+// do so only leaves blobs and usage behind until the index is rebuilt, so it
+// is not a commit error.
+func (r *commitRecord) releaseReplaced(replaced *Metadata) {
+	if r.Status != bucket.VersioningUnversioned || replaced == nil {
+		return
+	}
+
+	if replaced.blobRef(r.Key) == r.Metadata.blobRef(r.Key) {
+		return
+	}
+
+	if err := untrackVersion(r.Bucket, r.Key, replaced); err != nil {
+		logger.Log.Warnf("Could not update usage of replaced object %s/%s: %s", r.Bucket, r.Key, err)
+	}
+
+	if err := releaseBlobs(r.Bucket, r.Key, replaced); err != nil {
+		logger.Log.Warnf("Could not release blobs of replaced object %s/%s: %s", r.Bucket, r.Key, err)
+	}
+}
+
+// Recover completes or cleans up the writes interrupted when the server last
+// stopped, and must run before any requests are served. Every object is only
+// checked, and the index rebuilt, when the index is stale.
+func Recover() error {
+	entries, err := storage.List(commitsPath())
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not list commit records: %w", err)
+	}
+
+	for _, e := range entries {
+		var record commitRecord
+
+		data, err := storage.ReadFile(e.Name)
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+		}
+		if err == nil {
+			err = record.apply()
+		}
+
+		if err != nil {
+			logger.Log.Warnf("Discarding interrupted write %s: %s", e.Name, err)
+		} else {
+			logger.Log.Infof("Completed interrupted write of %s/%s", record.Bucket, record.Key)
+		}
+
+		storage.Delete(e.Name)
+	}
+
+	// Anything left in the staging area was never committed
+	if err := storage.DeleteAll(config.SystemPath("tmp")); err != nil {
+		return fmt.Errorf("could not clean up staging area: %w", err)
+	}
+
+	// Half-committed objects are only left behind when the server did not
+	// stop cleanly, which also leaves the index stale
+	if !index.Default.Stale() {
+		return nil
+	}
+
+	logger.Log.Info("Object index may be out of date, checking objects and rebuilding it")
+
+	buckets, err := storage.List(config.SystemPath("buckets"))
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not list buckets: %w", err)
+	}
+
+	for _, b := range buckets {
+		if err := recoverBucket(path.Base(b.Name)); err != nil {
+			return err
+		}
+	}
+
+	if err := RebuildIndex(); err != nil {
+		return err
+	}
+
+	// Blobs of interrupted writes and deletions are only known after the
+	// references are rebuilt
+	if _, err := blob.GC(context.Background()); err != nil {
+		return fmt.Errorf("could not collect unreferenced blobs: %w", err)
+	}
+
+	return nil
+}
+
+// recoverBucket removes object versions whose data does not match their
+// metadata, which happens when the server stopped before the data was flushed
+// to disk, or when written by versions without commit records.
+func recoverBucket(bucketName string) error {
+	objectsDir := config.BucketSystemPath(bucketName, "objects")
+
+	var keys []string
+
+	err := storage.Walk(objectsDir, func(name string, _ *storage.Info) error {
+		if key, ok := strings.CutSuffix(strings.TrimPrefix(name, objectsDir+"/"), ".json"); ok {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not list object metadata: %w", err)
+	}
+
+	for _, key := range keys {
+		half, err := halfCommitted(metadataPath(bucketName, key), objectPath(bucketName, key))
+		if err != nil {
+			logger.Log.Warnf("Skipping recovery of object %s/%s: %s", bucketName, key, err)
+			continue
+		}
+
+		if half {
+			logger.Log.Warnf("Removing half-committed object %s/%s", bucketName, key)
+
+			storage.Delete(objectPath(bucketName, key))
+
+			if err := DeleteMetadata(bucketName, key); err != nil {
+				return err
+			}
+
+			if err := promoteNoncurrentVersion(bucketName, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	versionsDir := config.BucketSystemPath(bucketName, "versions")
+
+	return storage.Walk(versionsDir, func(name string, _ *storage.Info) error {
+		dataPath, ok := strings.CutSuffix(name, ".json")
+		if !ok {
+			return nil
+		}
+
+		version := strings.TrimPrefix(dataPath, versionsDir+"/")
+
+		half, err := halfCommitted(name, dataPath)
+		if err != nil {
+			logger.Log.Warnf("Skipping recovery of object version %s: %s", version, err)
+			return nil
+		}
+
+		if half {
+			logger.Log.Warnf("Removing half-committed object version %s", version)
+
+			storage.Delete(dataPath)
+			storage.Delete(name)
+		}
+
+		return nil
+	})
+}
+
+// halfCommitted reports whether the metadata of an object version is missing,
+// or refers to data that is missing, or has the wrong size, including blobs
+// and chunks. Any other error, such as metadata that cannot be read or
+// decoded, is returned, so that the version is never removed because of it.
+func halfCommitted(metaPath, dataPath string) (bool, error) {
+	meta, err := readVersionMetadata(metaPath)
+	if storage.IsNotExist(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(meta.Chunks) > 0 {
+		stored, err := chunksStored(meta.Chunks)
+		return !stored, err
+	}
+
+	if meta.Blob != "" {
+		dataPath = blob.Path(meta.Blob)
+	} else if !meta.hasFile() {
+		return false, nil
+	}
+
+	stored, err := dataStored(dataPath, meta.DataSize())
+
+	return !stored, err
+}
+
+// dataStored reports whether a file exists with the given size, returning
+// errors other than the file not existing.
+func dataStored(path string, size int64) (bool, error) {
+	info, err := storage.Stat(path)
+	if storage.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return info.Size == size, nil
+}
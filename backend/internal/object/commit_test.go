@@ -0,0 +1,76 @@
+package object
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// TestRecoverBucket checks that only versions whose data is missing or
+// truncated are removed, and never versions whose metadata cannot be decoded.
+func TestRecoverBucket(t *testing.T) {
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+	config.Load()
+
+	storage.Default = storage.NewMemory(0)
+
+	write := func(path string, data []byte) {
+		t.Helper()
+
+		if err := storage.WriteFile(path, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeMeta := func(path string, size int64) {
+		t.Helper()
+
+		data, err := json.Marshal(&Metadata{Size: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		write(path, data)
+	}
+
+	const bucket = "recover-bucket"
+
+	versions := map[string]bool{
+		"committed": false,
+		"missing":   true,
+		"truncated": true,
+		"corrupt":   false,
+	}
+
+	writeMeta(versionMetadataPath(bucket, "committed", "v"), 4)
+	write(versionDataPath(bucket, "committed", "v"), []byte("data"))
+
+	writeMeta(versionMetadataPath(bucket, "missing", "v"), 4)
+
+	writeMeta(versionMetadataPath(bucket, "truncated", "v"), 4)
+	write(versionDataPath(bucket, "truncated", "v"), []byte("da"))
+
+	write(versionMetadataPath(bucket, "corrupt", "v"), []byte("{"))
+	write(versionDataPath(bucket, "corrupt", "v"), []byte("data"))
+
+	if err := recoverBucket(bucket); err != nil {
+		t.Fatalf("recoverBucket: %v", err)
+	}
+
+	for key, removed := range versions {
+		_, err := storage.Stat(versionMetadataPath(bucket, key, "v"))
+
+		if storage.IsNotExist(err) != removed {
+			t.Errorf("%s: metadata removed = %v, want %v", key, storage.IsNotExist(err), removed)
+		}
+	}
+
+	if _, err := storage.Stat(versionDataPath(bucket, "corrupt", "v")); err != nil {
+		t.Errorf("corrupt: data removed: %v", err)
+	}
+}
@@ -0,0 +1,388 @@
+package object
+
+import (
+	"context"
+	"encoding/xml"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DirectiveCopy    = "COPY"
+	DirectiveReplace = "REPLACE"
+)
+
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string
+	LastModified string
+}
+
+type CopySource struct {
+	Bucket    string
+	Key       string
+	VersionID string
+}
+
+type CopyPreconditions struct {
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   *time.Time
+	IfUnmodifiedSince *time.Time
+}
+
+type CopyOptions struct {
+	MetadataDirective string
+	TaggingDirective  string
+	Metadata          *Metadata
+	Tags              map[string]string
+	Preconditions     CopyPreconditions
+	SourceSSE         *sse.Request
+	SSE               *sse.Request
+}
+
+// ParseCopySource parses x-amz-copy-source, formatted as
+// [/]bucket/key[?versionId=id], with the key being URL-encoded.
+func ParseCopySource(copySource string) (*CopySource, error) {
+	path, query, _ := strings.Cut(copySource, "?")
+
+	path, err := url.PathUnescape(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, core.ErrorInvalidArgument("Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+
+	bucket, key, ok := strings.Cut(path, "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, core.ErrorInvalidArgument("Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	src := &CopySource{Bucket: bucket, Key: key}
+
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil || !values.Has("versionId") {
+			return nil, core.ErrorInvalidArgument("Unsupported copy source parameter")
+		}
+
+		src.VersionID = values.Get("versionId")
+
+		if err := ValidateVersionID(src.VersionID); err != nil {
+			return nil, err
+		}
+	}
+
+	return src, nil
+}
+
+func parseCopyPreconditions(c *fiber.Ctx) (CopyPreconditions, error) {
+	pre := CopyPreconditions{
+		IfMatch:     c.Get("X-Amz-Copy-Source-If-Match"),
+		IfNoneMatch: c.Get("X-Amz-Copy-Source-If-None-Match"),
+	}
+
+	for header, target := range map[string]**time.Time{
+		"X-Amz-Copy-Source-If-Modified-Since":   &pre.IfModifiedSince,
+		"X-Amz-Copy-Source-If-Unmodified-Since": &pre.IfUnmodifiedSince,
+	} {
+		value := c.Get(header)
+		if value == "" {
+			continue
+		}
+
+		t, err := http.ParseTime(value)
+		if err != nil {
+			return pre, core.ErrorInvalidArgument("Invalid date format for " + header)
+		}
+
+		*target = &t
+	}
+
+	return pre, nil
+}
+
+// Check evaluates the preconditions against the source object metadata, as
+// per S3: a true if-match takes precedence over a false if-unmodified-since,
+// and a false if-none-match takes precedence over a true if-modified-since.
+func (p CopyPreconditions) Check(meta *Metadata) error {
+	lastModified := meta.LastModified.Truncate(time.Second)
+
+	if p.IfMatch != "" {
+		if !etagMatches(p.IfMatch, meta.ETag) {
+			return ErrorPreconditionFailed()
+		}
+	} else if p.IfUnmodifiedSince != nil && lastModified.After(*p.IfUnmodifiedSince) {
+		return ErrorPreconditionFailed()
+	}
+
+	if p.IfNoneMatch != "" {
+		if etagMatches(p.IfNoneMatch, meta.ETag) {
+			return ErrorPreconditionFailed()
+		}
+	} else if p.IfModifiedSince != nil && !lastModified.After(*p.IfModifiedSince) {
+		return ErrorPreconditionFailed()
+	}
+
+	return nil
+}
+
+func etagMatches(condition, etag string) bool {
+	for candidate := range strings.SplitSeq(condition, ",") {
+		candidate = strings.Trim(strings.TrimSpace(candidate), `"`)
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseDirective(c *fiber.Ctx, header string) (string, error) {
+	directive := strings.ToUpper(c.Get(header, DirectiveCopy))
+
+	if directive != DirectiveCopy && directive != DirectiveReplace {
+		return "", core.ErrorInvalidArgument("Unknown " + header + " value: " + directive)
+	}
+
+	return directive, nil
+}
+
+// CopyObject copies a version of an object, or its latest version, into a new
+// version of the destination object, returning the source and destination
+// metadata. Data is staged first, so that a copy onto the same key survives the
+// latest version being archived. Copies of deduplicated objects only reference
+// the source blob, when the stored data stays the same.
+func CopyObject(ctx context.Context, src *CopySource, dstBucket, dstKey string, opts CopyOptions) (*Metadata, *Metadata, error) {
+	if err := ValidateKey(dstKey); err != nil {
+		return nil, nil, err
+	}
+
+	// Both objects are locked at once, so concurrent copies in opposite
+	// directions cannot deadlock
+	resources := append(
+		lock.ObjectInBucket(src.Bucket, src.Key, false),
+		lock.ObjectInBucket(dstBucket, dstKey, true)...,
+	)
+
+	unlock, err := lock.Lock(ctx, resources...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	if err := bucket.Lookup(src.Bucket); err != nil {
+		return nil, nil, err
+	}
+
+	if err := bucket.Lookup(dstBucket); err != nil {
+		return nil, nil, err
+	}
+
+	srcMeta, srcPath, err := ResolveVersion(src.Bucket, src.Key, src.VersionID)
+	if err != nil {
+		if srcMeta != nil && srcMeta.DeleteMarker && src.VersionID != "" {
+			return nil, nil, core.ErrorInvalidRequest("The source of a copy request may not specifically refer to a delete marker by version id.")
+		}
+
+		return nil, nil, err
+	}
+
+	if err := opts.Preconditions.Check(srcMeta); err != nil {
+		return nil, nil, err
+	}
+
+	if srcMeta.Quarantine != nil {
+		return nil, nil, ErrorObjectQuarantined()
+	}
+
+	if _, err := sse.DataKey(srcMeta.Encryption, opts.SourceSSE, sse.ObjectARN(src.Bucket, src.Key)); err != nil {
+		return nil, nil, err
+	}
+
+	sameObject := src.Bucket == dstBucket && src.Key == dstKey
+
+	if sameObject && src.VersionID == "" && opts.MetadataDirective != DirectiveReplace && opts.SSE == nil {
+		return nil, nil, core.ErrorInvalidRequest(
+			"This copy request is illegal because it is trying to copy an object " +
+				"to itself without changing the object's metadata, storage class, " +
+				"website redirect location or encryption attributes.",
+		)
+	}
+
+	status, err := bucket.GetVersioningStatus(dstBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dstMeta := &Metadata{
+		VersionID:    newVersionID(status),
+		ETag:         srcMeta.ETag,
+		Size:         srcMeta.Size,
+		LastModified: time.Now().UTC(),
+		Headers:      maps.Clone(srcMeta.Headers),
+		UserMetadata: maps.Clone(srcMeta.UserMetadata),
+		Tags:         maps.Clone(srcMeta.Tags),
+	}
+
+	if opts.MetadataDirective == DirectiveReplace {
+		dstMeta.Headers = opts.Metadata.Headers
+		dstMeta.UserMetadata = opts.Metadata.UserMetadata
+	}
+
+	if opts.TaggingDirective == DirectiveReplace {
+		dstMeta.Tags = opts.Tags
+	}
+
+	// Retention and legal holds are never copied from the source
+	dstMeta.Retention = opts.Metadata.Retention
+	dstMeta.LegalHold = opts.Metadata.LegalHold
+
+	if err := applyObjectLock(dstBucket, dstMeta); err != nil {
+		return nil, nil, err
+	}
+
+	reservation, err := reserveQuota(dstBucket, dstKey, status, dstMeta.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reservation.release()
+
+	dstSSE, err := resolveEncryption(dstBucket, opts.SSE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	algorithm, err := bucket.CompressionAlgorithm(dstBucket, dstKey, dstMeta.Headers[fiber.HeaderContentType])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params, err := chunking(dstBucket, dstSSE, dstMeta.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srcARN, dstARN := sse.ObjectARN(src.Bucket, src.Key), sse.ObjectARN(dstBucket, dstKey)
+	staged := ""
+
+	switch {
+	case inlines(dstMeta.Size):
+		err = copyInline(ctx, srcPath, srcARN, srcMeta, opts.SourceSSE, dstMeta, dstARN, dstSSE, algorithm)
+	case params != nil:
+		err = copyChunks(ctx, srcPath, srcARN, srcMeta, opts.SourceSSE, dstBucket, dstKey, dstMeta, *params, algorithm)
+	case dedupes() || linksBlob(srcMeta, opts.SourceSSE, dstSSE, algorithm):
+		err = copyBlob(ctx, srcPath, srcARN, srcMeta, opts.SourceSSE, dstBucket, dstKey, dstMeta, dstSSE, algorithm)
+	default:
+		staged, dstMeta.Encryption, dstMeta.Compression, err = stageTranscoded(
+			ctx, srcPath, srcARN, srcMeta, opts.SourceSSE, dstARN, dstSSE, algorithm,
+		)
+	}
+
+	if err != nil {
+		return nil, nil, writeError(err, "Failed to copy object")
+	}
+
+	if err := commitObject(dstBucket, dstKey, status, staged, dstMeta); err != nil {
+		return nil, nil, err
+	}
+
+	reservation.warn()
+
+	return srcMeta, dstMeta, nil
+}
+
+// CopyObjectHandler: PUT /:bucket/:key (with x-amz-copy-source)
+func CopyObjectHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	src, err := ParseCopySource(c.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	action := iam.GetObject
+
+	if src.VersionID != "" {
+		action = iam.GetObjectVersion
+	}
+
+	if !middleware.CheckIAM(c, action, src.Bucket) {
+		err := core.ErrorAccessDenied()
+		core.HandleError(c, err)
+		return err
+	}
+
+	opts := CopyOptions{Metadata: MetadataFromRequest(c, key)}
+
+	if opts.MetadataDirective, err = parseDirective(c, "X-Amz-Metadata-Directive"); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if opts.TaggingDirective, err = parseDirective(c, "X-Amz-Tagging-Directive"); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if opts.Tags, err = core.ParseTaggingHeader(c.Get("X-Amz-Tagging")); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := ObjectLockFromRequest(c, opts.Metadata); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if opts.SourceSSE, err = sse.ParseCustomerKey(c, sse.CopySourceCustomerHeaderPrefix); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if opts.SSE, err = sse.ParseRequest(c); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if opts.Preconditions, err = parseCopyPreconditions(c); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	srcMeta, meta, err := CopyObject(c.UserContext(), src, bucket, key, opts)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if srcMeta.VersionID != "" {
+		c.Set("X-Amz-Copy-Source-Version-Id", srcMeta.VersionID)
+	}
+
+	meta.SetVersionHeaders(c)
+	meta.Encryption.SetResponseHeaders(c)
+
+	res := CopyObjectResult{
+		ETag:         QuoteETag(meta.ETag),
+		LastModified: core.FormatTimestamp(meta.LastModified),
+	}
+
+	return c.XML(res)
+}
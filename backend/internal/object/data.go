@@ -0,0 +1,324 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/compression"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+// resolveEncryption returns the encryption for a new object version, falling
+// back to the bucket's default encryption when none was requested. SSE-S3 and
+// SSE-KMS are checked upfront, so that writes only fail on I/O errors.
+func resolveEncryption(bucketName string, req *sse.Request) (*sse.Request, error) {
+	if req == nil {
+		def, err := bucket.DefaultEncryption(bucketName)
+		if err != nil || def == nil {
+			return nil, err
+		}
+
+		req = &sse.Request{Algorithm: def.SSEAlgorithm, KMSKeyID: def.KMSMasterKeyID}
+	}
+
+	if err := sse.CheckRequest(req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// inlines reports whether an object of the given size is small enough to be
+// stored inline, as set by LS_INLINE_THRESHOLD.
+func inlines(size int64) bool {
+	return size < config.Env.InlineThreshold
+}
+
+// hasFile reports whether the data of an object version is stored as a
+// separate file, which is not the case for delete markers, inline objects,
+// deduplicated objects and chunked objects.
+func (m *Metadata) hasFile() bool {
+	return !m.DeleteMarker && !m.Inline && m.Blob == "" && len(m.Chunks) == 0
+}
+
+// inlineFile reads inline data, as if it were a stored file.
+type inlineFile struct {
+	*bytes.Reader
+}
+
+func (f inlineFile) Close() error {
+	return nil
+}
+
+// openStored opens the data of an object version as stored, from its file, or
+// from the metadata, when inline, or from its blob, when deduplicated.
+func (m *Metadata) openStored(ctx context.Context, name string) (storage.File, error) {
+	if m.Inline {
+		return inlineFile{bytes.NewReader(m.InlineData)}, nil
+	}
+
+	if m.Blob != "" {
+		return storage.Default.Get(ctx, blob.Path(m.Blob))
+	}
+
+	return storage.Default.Get(ctx, name)
+}
+
+// encodeInline is the same as writeData, but keeps the data in the metadata,
+// instead of storing it.
+func encodeInline(meta *Metadata, arn string, r io.Reader, req *sse.Request, algorithm string) error {
+	var (
+		dataKey []byte
+		err     error
+	)
+
+	if req != nil {
+		if meta.Encryption, dataKey, err = sse.NewDataKey(req, arn); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if meta.Compression, err = encodeData(&buf, r, dataKey, algorithm); err != nil {
+		return err
+	}
+
+	meta.Inline = true
+	meta.InlineData = buf.Bytes()
+
+	return nil
+}
+
+// writeData stores object data under name, compressing it with algorithm
+// when not empty, and then encrypting it for the object identified by arn when
+// req is not nil. It returns the encryption and compression state to store in
+// the object metadata.
+func writeData(
+	ctx context.Context, name, arn string, r io.Reader, req *sse.Request, algorithm string,
+) (*sse.Info, *compression.Info, error) {
+	return encodeStream(arn, r, req, algorithm, func(r io.Reader) error {
+		_, err := storage.Default.Put(ctx, name, r)
+		return err
+	})
+}
+
+// encodeStream is the same as writeData, but passes the encoded data to put,
+// as a stream, instead of storing it.
+func encodeStream(
+	arn string, r io.Reader, req *sse.Request, algorithm string, put func(r io.Reader) error,
+) (*sse.Info, *compression.Info, error) {
+	var (
+		encryption *sse.Info
+		dataKey    []byte
+		err        error
+	)
+
+	if req != nil {
+		if encryption, dataKey, err = sse.NewDataKey(req, arn); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if dataKey == nil && algorithm == "" {
+		return nil, nil, put(r)
+	}
+
+	pr, pw := io.Pipe()
+
+	var compressed *compression.Info
+
+	encoded := make(chan error, 1)
+
+	go func() {
+		var err error
+
+		compressed, err = encodeData(pw, r, dataKey, algorithm)
+		pw.CloseWithError(err)
+		encoded <- err
+	}()
+
+	err = put(pr)
+
+	// Unblocks the encoder, when put stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	if encodeErr := <-encoded; err == nil {
+		err = encodeErr
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryption, compressed, nil
+}
+
+// encodeData compresses and encrypts data from r into w, as requested by a
+// non-empty algorithm and data key.
+func encodeData(w io.Writer, r io.Reader, dataKey []byte, algorithm string) (*compression.Info, error) {
+	var (
+		encrypter  io.WriteCloser
+		compressor *compression.Writer
+		err        error
+	)
+
+	if dataKey != nil {
+		if encrypter, err = sse.NewWriter(w, dataKey); err != nil {
+			return nil, err
+		}
+
+		w = encrypter
+	}
+
+	if algorithm != "" {
+		if compressor, err = compression.NewWriter(w, algorithm); err != nil {
+			return nil, err
+		}
+
+		w = compressor
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+
+	var info *compression.Info
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return nil, err
+		}
+
+		info = compressor.Info()
+	}
+
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// openData opens the data of an object version for reading, decrypting and
+// decompressing it when needed. SSE-C objects require the customer key in req.
+func openData(ctx context.Context, name, arn string, meta *Metadata, req *sse.Request) (io.ReadSeekCloser, error) {
+	if len(meta.Chunks) > 0 {
+		return newChunkReader(ctx, meta.Chunks), nil
+	}
+
+	dataKey, err := sse.DataKey(meta.Encryption, req, arn)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := meta.openStored(ctx, name)
+	if err != nil {
+		// Unreadable data is not reported as missing
+		var s3Error *core.S3Error
+
+		if errors.As(err, &s3Error) {
+			return nil, err
+		}
+
+		return nil, ErrorNoSuchKey()
+	}
+
+	var r io.ReadSeekCloser = f
+
+	if dataKey != nil {
+		if r, err = sse.NewReader(f, meta.StoredSize(), dataKey); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not decrypt object: %w", err)
+		}
+	}
+
+	if meta.Compression != nil {
+		r = compression.NewReader(r, meta.Size, meta.Compression)
+	}
+
+	return r, nil
+}
+
+// stageTranscoded stages a copy of an object version, decrypting and
+// decompressing the source, and then compressing and encrypting the copy as
+// requested. Unencrypted copies of unencrypted objects with the same
+// compression are staged as a plain file copy instead, which can use reflinks.
+func stageTranscoded(
+	ctx context.Context, srcPath, srcARN string, srcMeta *Metadata, srcReq *sse.Request,
+	dstARN string, dstReq *sse.Request, algorithm string,
+) (string, *sse.Info, *compression.Info, error) {
+	if srcMeta.hasFile() && srcMeta.Encryption == nil && srcReq == nil && dstReq == nil && srcMeta.compressedWith(algorithm) {
+		staged, err := stageFile(ctx, srcPath)
+		return staged, nil, srcMeta.Compression, err
+	}
+
+	src, err := openData(ctx, srcPath, srcARN, srcMeta, srcReq)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer src.Close()
+
+	staged := stagingPath()
+
+	encryption, compressed, err := writeData(ctx, staged, dstARN, src, dstReq, algorithm)
+	if err != nil {
+		storage.Delete(staged)
+		return "", nil, nil, err
+	}
+
+	return staged, encryption, compressed, nil
+}
+
+// copyInline is the same as stageTranscoded, but keeps the copy inline, in the
+// destination metadata.
+func copyInline(
+	ctx context.Context, srcPath, srcARN string, srcMeta *Metadata, srcReq *sse.Request,
+	dstMeta *Metadata, dstARN string, dstReq *sse.Request, algorithm string,
+) error {
+	src, err := openData(ctx, srcPath, srcARN, srcMeta, srcReq)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return encodeInline(dstMeta, dstARN, src, dstReq, algorithm)
+}
+
+// StoredSize returns the size of the object data before encryption, which is
+// smaller than its logical size when compressed.
+func (m *Metadata) StoredSize() int64 {
+	if m.Compression != nil {
+		return m.Compression.StoredSize
+	}
+
+	return m.Size
+}
+
+// DataSize returns the size of the object data as stored, after compression
+// and encryption.
+func (m *Metadata) DataSize() int64 {
+	if m.Encryption != nil {
+		return sse.EncryptedSize(m.StoredSize())
+	}
+
+	return m.StoredSize()
+}
+
+func (m *Metadata) compressedWith(algorithm string) bool {
+	if m.Compression == nil {
+		return algorithm == ""
+	}
+
+	return m.Compression.Algorithm == algorithm
+}
@@ -0,0 +1,131 @@
+package object
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+// dedupes reports whether new object data is stored in blobs, as set by
+// LS_DEDUPE. Existing blobs are still read, and linked by copies, when unset.
+func dedupes() bool {
+	return config.Env.Dedupe
+}
+
+// blobRef identifies an object version among the references to its blob. The
+// last modified time tells apart the versions of an object, including null
+// versions, and is kept when versions are archived or promoted.
+func (m *Metadata) blobRef(key string) string {
+	return key + "\x00" + strconv.FormatInt(m.LastModified.UnixNano(), 10)
+}
+
+// chunkRef identifies a chunk of an object version, given its position.
+func (m *Metadata) chunkRef(key string, i int) string {
+	return m.blobRef(key) + "\x00" + strconv.Itoa(i)
+}
+
+// writeBlob is the same as writeData, but stores the data as a blob, hashed
+// while written, and references it from the object version in meta.
+func writeBlob(
+	ctx context.Context, bucketName, key string, meta *Metadata, r io.Reader, req *sse.Request, algorithm string,
+) error {
+	staged := stagingPath()
+	sum := sha256.New()
+
+	var err error
+
+	meta.Encryption, meta.Compression, err = encodeStream(
+		sse.ObjectARN(bucketName, key), r, req, algorithm,
+		func(r io.Reader) error {
+			_, err := storage.Default.Put(ctx, staged, io.TeeReader(r, sum))
+			return err
+		},
+	)
+	if err != nil {
+		storage.Delete(staged)
+		return err
+	}
+
+	hash := hex.EncodeToString(sum.Sum(nil))
+
+	ref := blob.Ref{ID: meta.blobRef(key), Hash: hash, Staged: staged}
+
+	if err := blob.Put(ctx, bucketName, []blob.Ref{ref}); err != nil {
+		storage.Delete(staged)
+		return err
+	}
+
+	meta.Blob = hash
+
+	return nil
+}
+
+// linksBlob reports whether a copy can reference the blob of its source, which
+// requires the stored data to stay the same, so unencrypted, and compressed
+// with the same algorithm.
+func linksBlob(srcMeta *Metadata, srcReq, dstReq *sse.Request, algorithm string) bool {
+	return srcMeta.Blob != "" && srcMeta.Encryption == nil && srcReq == nil && dstReq == nil &&
+		srcMeta.compressedWith(algorithm)
+}
+
+// copyBlob is the same as stageTranscoded, but stores the copy as a blob. When
+// the stored data stays the same, the copy only references the source blob.
+func copyBlob(
+	ctx context.Context, srcPath, srcARN string, srcMeta *Metadata, srcReq *sse.Request,
+	dstBucket, dstKey string, dstMeta *Metadata, dstReq *sse.Request, algorithm string,
+) error {
+	if linksBlob(srcMeta, srcReq, dstReq, algorithm) {
+		ref := blob.Ref{ID: dstMeta.blobRef(dstKey), Hash: srcMeta.Blob}
+
+		if err := blob.Put(ctx, dstBucket, []blob.Ref{ref}); err != nil {
+			return err
+		}
+
+		dstMeta.Blob = srcMeta.Blob
+		dstMeta.Compression = srcMeta.Compression
+
+		return nil
+	}
+
+	src, err := openData(ctx, srcPath, srcARN, srcMeta, srcReq)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeBlob(ctx, dstBucket, dstKey, dstMeta, src, dstReq, algorithm)
+}
+
+// blobRefs returns the references of an object version to its blobs, which
+// are either its single blob, or the blobs of its chunks, in order.
+func (m *Metadata) blobRefs(key string) []blob.Ref {
+	if m.Blob != "" {
+		return []blob.Ref{{ID: m.blobRef(key), Hash: m.Blob}}
+	}
+
+	refs := make([]blob.Ref, len(m.Chunks))
+
+	for i, chunk := range m.Chunks {
+		refs[i] = blob.Ref{ID: m.chunkRef(key, i), Hash: chunk.Hash}
+	}
+
+	return refs
+}
+
+// releaseBlobs releases the blobs of an object version, once permanently
+// deleted, or never committed.
+func releaseBlobs(bucketName, key string, meta *Metadata) error {
+	refs := meta.blobRefs(key)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	return blob.Release(context.Background(), bucketName, refs)
+}
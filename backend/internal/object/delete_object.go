@@ -1,35 +1,120 @@
 package object
 
 import (
-	"os"
-	"path/filepath"
+	"context"
 
-	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/gofiber/fiber/v2"
 )
 
-func DeleteObject(bucket, key string) error {
-	objPath := filepath.Join(config.Env.StorageRoot, bucket, key)
+// DeleteObject removes an object, or, when the bucket is versioned, adds a
+// delete marker. A specific version is permanently deleted when versionID is
+// given, unless it is locked. The returned metadata describes the delete
+// marker or deleted version, and is nil when there was no object to delete,
+// which, as per S3, is not an error.
+func DeleteObject(ctx context.Context, bucketName, key, versionID string, bypassGovernance bool) (*Metadata, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateVersionID(versionID); err != nil {
+		return nil, err
+	}
 
-	err := os.Remove(objPath)
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
 	if err != nil {
-		return ErrorNoSuchKey()
+		return nil, err
 	}
+	defer unlock()
 
-	return nil
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, err
+	}
+
+	status, err := bucket.GetVersioningStatus(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	if versionID == "" && status != bucket.VersioningUnversioned {
+		return putDeleteMarker(bucketName, key, status)
+	}
+
+	latest, err := readLatest(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if versionID == "" || (latest != nil && latest.ExposedVersionID() == versionID) {
+		if latest == nil {
+			return nil, nil
+		}
+
+		if err := latest.CheckLock(bypassGovernance); err != nil {
+			return nil, err
+		}
+
+		if err := removeLatest(bucketName, key, latest); err != nil {
+			return nil, err
+		}
+
+		if err := promoteNoncurrentVersion(bucketName, key); err != nil {
+			return nil, err
+		}
+
+		return latest, nil
+	}
+
+	meta, err := readVersionMetadata(versionMetadataPath(bucketName, key, versionID))
+	if storage.IsNotExist(err) {
+		return nil, ErrorNoSuchVersion()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := meta.CheckLock(bypassGovernance); err != nil {
+		return nil, err
+	}
+
+	if err := deleteNoncurrentVersion(bucketName, key, versionID, meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
 }
 
 // DeleteObjectHandler: DELETE /:bucket/:key
 func DeleteObjectHandler(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 	key := c.Params("key")
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
 
-	if err := DeleteObject(bucket, key); err != nil {
+	if versionID != "" && !middleware.CheckIAM(c, iam.DeleteObjectVersion, bucket) {
+		err := core.ErrorAccessDenied()
 		core.HandleError(c, err)
 		return err
 	}
 
+	meta, err := DeleteObject(c.UserContext(), bucket, key, versionID, BypassGovernance(c, bucket))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if meta != nil {
+		meta.SetVersionHeaders(c)
+	}
+
 	c.Status(fiber.StatusNoContent)
 	return nil
 }
@@ -0,0 +1,183 @@
+package object
+
+import (
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxDeleteObjects = 1000
+	maxDeleteWorkers = 16
+)
+
+type ObjectIdentifier struct {
+	Key       string
+	VersionId string `xml:",omitempty"`
+}
+
+type Delete struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Objects []ObjectIdentifier `xml:"Object"`
+	Quiet   bool
+}
+
+type DeletedObject struct {
+	Key                   string
+	VersionId             string `xml:",omitempty"`
+	DeleteMarker          bool   `xml:",omitempty"`
+	DeleteMarkerVersionId string `xml:",omitempty"`
+}
+
+type DeleteError struct {
+	Key       string
+	VersionId string `xml:",omitempty"`
+	Code      string
+	Message   string
+}
+
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+// DeleteObjects deletes a batch of objects concurrently, with at most
+// maxDeleteWorkers deletions running at the same time. Each key is authorized
+// individually, and results are reported in request order.
+func DeleteObjects(c *fiber.Ctx, bucketName string, req *Delete) (*DeleteResult, error) {
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, err
+	}
+
+	deleted := make([]*Metadata, len(req.Objects))
+	errs := make([]error, len(req.Objects))
+
+	// Keys are validated, and IAM checks, which rely on the request context,
+	// are run before dispatching
+	for i, obj := range req.Objects {
+		if err := ValidateKey(obj.Key); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		action := iam.DeleteObject
+
+		if obj.VersionId != "" {
+			action = iam.DeleteObjectVersion
+		}
+
+		if !middleware.CheckIAM(c, action, bucketName) {
+			errs[i] = core.ErrorAccessDenied()
+		}
+	}
+
+	bypassGovernance := BypassGovernance(c, bucketName)
+	ctx := c.UserContext()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxDeleteWorkers)
+
+	for i, obj := range req.Objects {
+		if errs[i] != nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			deleted[i], errs[i] = DeleteObject(ctx, bucketName, obj.Key, obj.VersionId, bypassGovernance)
+		}()
+	}
+
+	wg.Wait()
+
+	res := &DeleteResult{}
+
+	for i, obj := range req.Objects {
+		if errs[i] == nil {
+			if !req.Quiet {
+				res.Deleted = append(res.Deleted, newDeletedObject(obj, deleted[i]))
+			}
+			continue
+		}
+
+		deleteErr := DeleteError{
+			Key:       obj.Key,
+			VersionId: obj.VersionId,
+			Code:      "InternalError",
+			Message:   errs[i].Error(),
+		}
+
+		var s3Error *core.S3Error
+
+		if errors.As(errs[i], &s3Error) {
+			deleteErr.Code = s3Error.Code
+			deleteErr.Message = s3Error.Message
+		}
+
+		res.Errors = append(res.Errors, deleteErr)
+	}
+
+	return res, nil
+}
+
+// newDeletedObject reports a deleted version, or the delete marker that was
+// added when no version was given.
+func newDeletedObject(obj ObjectIdentifier, meta *Metadata) DeletedObject {
+	deleted := DeletedObject{Key: obj.Key, VersionId: obj.VersionId}
+
+	if meta == nil || !meta.DeleteMarker {
+		return deleted
+	}
+
+	deleted.DeleteMarker = true
+
+	if obj.VersionId == "" {
+		deleted.DeleteMarkerVersionId = meta.VersionID
+	}
+
+	return deleted
+}
+
+// DeleteObjectsHandler: POST /:bucket?delete
+func DeleteObjectsHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	if err := VerifyBodyChecksum(c, true); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	var req Delete
+
+	if err := xml.Unmarshal(c.Body(), &req); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	res, err := DeleteObjects(c, bucket, &req)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
@@ -1,7 +1,10 @@
 package object
 
 import (
+	"errors"
+
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -12,3 +15,33 @@ func ErrorNoSuchKey() *core.S3Error {
 		StatusCode: fiber.StatusNotFound,
 	}
 }
+
+func ErrorNoSuchVersion() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchVersion",
+		Message:    "The specified version does not exist",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+func ErrorPreconditionFailed() *core.S3Error {
+	return &core.S3Error{
+		Code:       "PreconditionFailed",
+		Message:    "At least one of the preconditions you specified did not hold",
+		StatusCode: fiber.StatusPreconditionFailed,
+	}
+}
+
+// writeError logs a failed write, passing S3 errors through, such as a full
+// storage backend, and hiding anything else behind an InternalError.
+func writeError(err error, message string) error {
+	logger.Log.Error(err)
+
+	var s3Error *core.S3Error
+
+	if errors.As(err, &s3Error) {
+		return err
+	}
+
+	return core.ErrorInternalError(message)
+}
@@ -1,27 +1,60 @@
 package object
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"mime"
-	"os"
-	"path/filepath"
-	"time"
 
-	"github.com/DataLabTechTV/labstore/backend/internal/config"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/gofiber/fiber/v2"
 )
 
-func GetObject(bucket, key string) (io.ReadSeeker, error) {
-	objPath := filepath.Join(config.Env.StorageRoot, bucket, key)
+// GetObject returns the metadata and data of an object version, decrypting
+// it with the customer key in sseReq for SSE-C.
+func GetObject(ctx context.Context, bucket, key, versionID string, sseReq *sse.Request) (*Metadata, io.ReadSeekCloser, error) {
+	// Only held until the data is open, which then stays readable even if the
+	// object is replaced
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucket, key, false)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	meta, path, err := ResolveVersion(bucket, key, versionID)
+	if err != nil {
+		return meta, nil, err
+	}
+
+	if meta.Quarantine != nil {
+		return meta, nil, ErrorObjectQuarantined()
+	}
 
-	f, err := os.Open(objPath)
+	// Data is closed by fasthttp once the response body is sent
+	r, err := openData(ctx, path, sse.ObjectARN(bucket, key), meta, sseReq)
 	if err != nil {
-		return nil, ErrorNoSuchKey()
+		return nil, nil, err
 	}
-	defer f.Close()
 
-	return f, nil
+	return meta, r, nil
+}
+
+// ResponseHeaderOverrides collects response-* query parameters into a map of
+// response headers. Anonymous requests with overrides are rejected by
+// middleware.WithResponseOverrides.
+func ResponseHeaderOverrides(c *fiber.Ctx) map[string]string {
+	overrides := map[string]string{}
+
+	for _, o := range middleware.ResponseOverrides {
+		if value := c.Query(o.Param); value != "" {
+			overrides[o.Header] = value
+		}
+	}
+
+	return overrides
 }
 
 // GetObjectHandler: GET /:bucket/:key
@@ -29,16 +62,78 @@ func GetObjectHandler(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 	key := c.Params("key")
 
-	f, err := GetObject(bucket, key)
+	overrides := ResponseHeaderOverrides(c)
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	sseReq, err := sse.ParseCustomerKey(c, sse.CustomerHeaderPrefix)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if versionID != "" && !middleware.CheckIAMBeforeTags(c, iam.GetObjectVersion, bucket) {
+		err := core.ErrorAccessDenied()
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta, f, err := GetObject(c.UserContext(), bucket, key, versionID, sseReq)
 	if err != nil {
+		if meta != nil {
+			meta.SetVersionHeaders(c)
+		}
+
+		core.HandleError(c, err)
+		return err
+	}
+
+	if !middleware.CheckObjectTags(c, bucket, meta.Tags, nil) {
+		f.Close()
+
+		err := core.ErrorAccessDenied()
+		core.HandleError(c, err)
+		return err
+	}
+
+	byteRange, err := ParseRange(c.Get(fiber.HeaderRange), meta.Size)
+	if err != nil {
+		f.Close()
+
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", meta.Size))
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta.SetResponseHeaders(c)
+	meta.SetVersionHeaders(c)
+	meta.SetObjectLockHeaders(c)
+	meta.Encryption.SetResponseHeaders(c)
+
+	for header, value := range overrides {
+		c.Set(header, value)
+	}
+
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if byteRange == nil {
+		return c.SendStream(f, int(meta.Size))
+	}
+
+	section, err := byteRange.Section(f)
+	if err != nil {
+		f.Close()
+
+		err := core.ErrorInternalError("Failed to read object")
 		core.HandleError(c, err)
 		return err
 	}
 
-	ext := filepath.Ext(key)
-	mimeType := mime.TypeByExtension(ext)
-	c.Type(mimeType)
+	c.Status(fiber.StatusPartialContent)
+	c.Set(fiber.HeaderContentRange, byteRange.ContentRange(meta.Size))
 
-	c.Response().Header.SetLastModified(time.Now())
-	return c.SendStream(f)
+	return c.SendStream(section, int(byteRange.Length))
 }
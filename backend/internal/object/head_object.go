@@ -1,34 +1,82 @@
 package object
 
 import (
-	"path/filepath"
+	"context"
 
-	"github.com/DataLabTechTV/labstore/backend/internal/config"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
-	"github.com/DataLabTechTV/labstore/backend/internal/helper"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/gofiber/fiber/v2"
 )
 
-func HeadObject(bucket, key string) error {
-	objPath := filepath.Join(config.Env.StorageRoot, bucket, key)
+// HeadObject returns the metadata of an object version. SSE-C objects
+// require the customer key, which is verified against the stored data key.
+func HeadObject(ctx context.Context, bucket, key, versionID string, sseReq *sse.Request) (*Metadata, error) {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucket, key, false)...)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	if !helper.FileExists(objPath) {
-		return ErrorNoSuchKey()
+	meta, _, err := ResolveVersion(bucket, key, versionID)
+	if err != nil {
+		return meta, err
 	}
 
-	return nil
+	if _, err := sse.DataKey(meta.Encryption, sseReq, sse.ObjectARN(bucket, key)); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
 }
 
 // HeadObjectHandler: Head /:bucket/:key
 func HeadObjectHandler(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 	key := c.Params("key")
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleHeadError(c, err)
+		return err
+	}
+
+	sseReq, err := sse.ParseCustomerKey(c, sse.CustomerHeaderPrefix)
+	if err != nil {
+		core.HandleHeadError(c, err)
+		return err
+	}
 
-	if err := HeadObject(bucket, key); err != nil {
-		core.HandleError(c, err)
+	if versionID != "" && !middleware.CheckIAMBeforeTags(c, iam.GetObjectVersion, bucket) {
+		err := core.ErrorAccessDenied()
+		core.HandleHeadError(c, err)
 		return err
 	}
 
+	meta, err := HeadObject(c.UserContext(), bucket, key, versionID, sseReq)
+	if err != nil {
+		if meta != nil {
+			meta.SetVersionHeaders(c)
+		}
+
+		core.HandleHeadError(c, err)
+		return err
+	}
+
+	if !middleware.CheckObjectTags(c, bucket, meta.Tags, nil) {
+		err := core.ErrorAccessDenied()
+		core.HandleHeadError(c, err)
+		return err
+	}
+
+	meta.SetResponseHeaders(c)
+	meta.SetVersionHeaders(c)
+	meta.SetObjectLockHeaders(c)
+	meta.Encryption.SetResponseHeaders(c)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Response().Header.SetContentLength(int(meta.Size))
+
 	c.Status(fiber.StatusOK)
 	return nil
 }
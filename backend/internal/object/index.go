@@ -0,0 +1,160 @@
+package object
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+func indexEntry(key string, meta *Metadata) *index.Entry {
+	return &index.Entry{
+		Key:          key,
+		ETag:         meta.ETag,
+		Size:         meta.Size,
+		LastModified: meta.LastModified,
+	}
+}
+
+// indexLatest updates the index entry of an object, after its latest version
+// changed. Delete markers hide the object from listings.
+func indexLatest(bucketName, key string, meta *Metadata) error {
+	var err error
+
+	if meta == nil || meta.DeleteMarker {
+		err = index.Default.Delete(bucketName, key)
+	} else {
+		err = index.Default.Put(bucketName, indexEntry(key, meta))
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not update object index: %w", err)
+	}
+
+	return nil
+}
+
+// RebuildIndex reconstructs the index from the objects in storage, including
+// inline objects, which only have metadata, and objects stored without
+// metadata, along with the blob references and usage of all object versions.
+func RebuildIndex() error {
+	entries, err := storage.List("")
+	if err != nil {
+		return fmt.Errorf("could not read storage root: %w", err)
+	}
+
+	objects := 0
+
+	err = index.Default.Rebuild(func(r *index.Rebuilder) error {
+		for _, e := range entries {
+			if !e.IsDir || strings.HasPrefix(e.Name, ".") {
+				continue
+			}
+
+			if err := rebuildBucket(r, e.Name, &objects); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Rebuilt object index with %d objects", objects)
+
+	return nil
+}
+
+func rebuildBucket(r *index.Rebuilder, bucketName string, objects *int) error {
+	add := func(key string) error {
+		meta, err := ReadMetadata(bucketName, key)
+		if err != nil {
+			logger.Log.Warnf("Skipping object %s/%s: %s", bucketName, key, err)
+			return nil
+		}
+
+		for _, ref := range meta.blobRefs(key) {
+			if err := r.AddRef(bucketName, ref.ID, ref.Hash); err != nil {
+				return err
+			}
+		}
+
+		if meta.DeleteMarker {
+			return nil
+		}
+
+		if err := r.AddVersion(bucketName, meta.blobRef(key), meta.Size); err != nil {
+			return err
+		}
+
+		*objects++
+
+		return r.Put(bucketName, indexEntry(key, meta))
+	}
+
+	objectsDir := config.BucketSystemPath(bucketName, "objects")
+
+	err := storage.Walk(objectsDir, func(name string, _ *storage.Info) error {
+		if key, ok := strings.CutSuffix(strings.TrimPrefix(name, objectsDir+"/"), ".json"); ok {
+			return add(key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not list objects in %s: %w", bucketName, err)
+	}
+
+	// Objects without metadata only have their data
+	err = storage.Walk(bucketName, func(name string, _ *storage.Info) error {
+		key := strings.TrimPrefix(name, bucketName+"/")
+
+		if _, err := storage.Stat(metadataPath(bucketName, key)); storage.IsNotExist(err) {
+			return add(key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not list objects in %s: %w", bucketName, err)
+	}
+
+	versionsDir := config.BucketSystemPath(bucketName, "versions")
+
+	err = storage.Walk(versionsDir, func(name string, _ *storage.Info) error {
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+
+		meta, err := readVersionMetadata(name)
+		if err != nil {
+			logger.Log.Warnf("Skipping object version %s: %s", name, err)
+			return nil
+		}
+
+		key := path.Dir(strings.TrimPrefix(name, versionsDir+"/"))
+
+		for _, ref := range meta.blobRefs(key) {
+			if err := r.AddRef(bucketName, ref.ID, ref.Hash); err != nil {
+				return err
+			}
+		}
+
+		if meta.DeleteMarker {
+			return nil
+		}
+
+		return r.AddVersion(bucketName, meta.blobRef(key), meta.Size)
+	})
+	if err != nil {
+		return fmt.Errorf("could not list object versions in %s: %w", bucketName, err)
+	}
+
+	return nil
+}
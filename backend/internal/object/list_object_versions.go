@@ -0,0 +1,268 @@
+package object
+
+import (
+	"encoding/xml"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxListKeys = 1000
+
+// ObjectVersion is either a Version or a DeleteMarker entry, which S3
+// interleaves in a single sequence, ordered by key and then newest first.
+type ObjectVersion struct {
+	XMLName      xml.Name
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	LastModified string
+	ETag         string `xml:",omitempty"`
+	Size         *int64 `xml:",omitempty"`
+	StorageClass string `xml:",omitempty"`
+}
+
+type CommonPrefix struct {
+	Prefix string
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string
+	Prefix              string
+	Delimiter           string `xml:",omitempty"`
+	KeyMarker           string
+	VersionIdMarker     string
+	NextKeyMarker       string `xml:",omitempty"`
+	NextVersionIdMarker string `xml:",omitempty"`
+	MaxKeys             int
+	IsTruncated         bool
+	Versions            []ObjectVersion
+	CommonPrefixes      []CommonPrefix `xml:",omitempty"`
+}
+
+type ListVersionsOptions struct {
+	Prefix          string
+	Delimiter       string
+	KeyMarker       string
+	VersionIdMarker string
+	MaxKeys         int
+}
+
+// listVersionedKeys returns all keys with a latest or noncurrent version,
+// including keys whose latest version is a delete marker, which only exist as
+// metadata.
+func listVersionedKeys(bucketName string) ([]string, error) {
+	keys := map[string]struct{}{}
+
+	collect := func(root string, keyOf func(rel string) (string, bool)) error {
+		return storage.Walk(root, func(name string, _ *storage.Info) error {
+			if key, ok := keyOf(strings.TrimPrefix(name, root+"/")); ok {
+				keys[key] = struct{}{}
+			}
+
+			return nil
+		})
+	}
+
+	if err := collect(bucketName, func(rel string) (string, bool) {
+		return rel, true
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := collect(config.BucketSystemPath(bucketName, "objects"), func(rel string) (string, bool) {
+		return strings.CutSuffix(rel, ".json")
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := collect(config.BucketSystemPath(bucketName, "versions"), func(rel string) (string, bool) {
+		if !strings.HasSuffix(rel, ".json") {
+			return "", false
+		}
+
+		return path.Dir(rel), true
+	}); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]string, 0, len(keys))
+
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+
+	sort.Strings(sorted)
+
+	return sorted, nil
+}
+
+func newObjectVersion(key string, meta *Metadata, isLatest bool) ObjectVersion {
+	v := ObjectVersion{
+		XMLName:      xml.Name{Local: "Version"},
+		Key:          key,
+		VersionId:    meta.ExposedVersionID(),
+		IsLatest:     isLatest,
+		LastModified: core.FormatTimestamp(meta.LastModified),
+	}
+
+	if meta.DeleteMarker {
+		v.XMLName.Local = "DeleteMarker"
+		return v
+	}
+
+	size := meta.Size
+
+	v.ETag = QuoteETag(meta.ETag)
+	v.Size = &size
+	v.StorageClass = "STANDARD"
+
+	return v
+}
+
+// ListObjectVersions lists all versions and delete markers, with the latest
+// version of each key first. Keys sharing a prefix up to the delimiter are
+// rolled up into common prefixes, each counting as a single entry.
+func ListObjectVersions(bucketName string, opts ListVersionsOptions) (*ListVersionsResult, error) {
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, err
+	}
+
+	res := &ListVersionsResult{
+		Name:            bucketName,
+		Prefix:          opts.Prefix,
+		Delimiter:       opts.Delimiter,
+		KeyMarker:       opts.KeyMarker,
+		VersionIdMarker: opts.VersionIdMarker,
+		MaxKeys:         opts.MaxKeys,
+	}
+
+	keys, err := listVersionedKeys(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	lastPrefix := ""
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+
+		// Without a version ID marker, listing resumes after the key marker
+		if key < opts.KeyMarker || (key == opts.KeyMarker && opts.VersionIdMarker == "") {
+			continue
+		}
+
+		if opts.Delimiter != "" {
+			rest := strings.TrimPrefix(key, opts.Prefix)
+
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				prefix := opts.Prefix + rest[:i+len(opts.Delimiter)]
+
+				if prefix == lastPrefix || prefix <= opts.KeyMarker {
+					continue
+				}
+
+				if count == opts.MaxKeys {
+					res.IsTruncated = true
+					return res, nil
+				}
+
+				res.CommonPrefixes = append(res.CommonPrefixes, CommonPrefix{Prefix: prefix})
+				res.NextKeyMarker = prefix
+				res.NextVersionIdMarker = ""
+				lastPrefix = prefix
+				count++
+				continue
+			}
+		}
+
+		latest, err := readLatest(bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+
+		noncurrent, err := ListNoncurrentVersions(bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+
+		var versions []*Metadata
+
+		if latest != nil {
+			versions = append(versions, latest)
+		}
+
+		versions = append(versions, noncurrent...)
+
+		// Skip up to and including the version ID marker, for the marker key
+		skipping := key == opts.KeyMarker
+
+		for i, meta := range versions {
+			if skipping {
+				if meta.ExposedVersionID() == opts.VersionIdMarker {
+					skipping = false
+				}
+				continue
+			}
+
+			if count == opts.MaxKeys {
+				res.IsTruncated = true
+				return res, nil
+			}
+
+			res.Versions = append(res.Versions, newObjectVersion(key, meta, i == 0 && latest != nil))
+			res.NextKeyMarker = key
+			res.NextVersionIdMarker = meta.ExposedVersionID()
+			count++
+		}
+	}
+
+	res.NextKeyMarker = ""
+	res.NextVersionIdMarker = ""
+
+	return res, nil
+}
+
+// ListObjectVersionsHandler: GET /:bucket?versions
+func ListObjectVersionsHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+
+	opts := ListVersionsOptions{
+		Prefix:          c.Query("prefix"),
+		Delimiter:       c.Query("delimiter"),
+		KeyMarker:       c.Query("key-marker"),
+		VersionIdMarker: c.Query("version-id-marker"),
+	}
+
+	if opts.VersionIdMarker != "" && opts.KeyMarker == "" {
+		err := core.ErrorInvalidArgument("A version-id marker cannot be specified without a key marker.")
+		core.HandleError(c, err)
+		return err
+	}
+
+	maxKeys, err := parseMaxKeys(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	opts.MaxKeys = maxKeys
+
+	res, err := ListObjectVersions(bucket, opts)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
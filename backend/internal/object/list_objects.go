@@ -0,0 +1,225 @@
+package object
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+	Owner        *Owner `xml:",omitempty"`
+}
+
+// ListBucketResult is the response to both ListObjects and ListObjectsV2,
+// which only differ in how listing resumes, through markers or continuation
+// tokens.
+type ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	KeyCount              *int   `xml:",omitempty"`
+	MaxKeys               int
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	Contents              []Object
+	CommonPrefixes        []CommonPrefix `xml:",omitempty"`
+}
+
+type ListObjectsOptions struct {
+	V2                bool
+	Prefix            string
+	Delimiter         string
+	Marker            string
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int
+	EncodingType      string
+	FetchOwner        bool
+}
+
+// encodeKey URL-encodes a key for encoding-type=url, keeping slashes, so
+// clients can safely parse keys with characters not allowed in XML.
+func encodeKey(key, encodingType string) string {
+	if encodingType != "url" {
+		return key
+	}
+
+	encoded := strings.ReplaceAll(url.QueryEscape(key), "+", "%20")
+
+	return strings.ReplaceAll(encoded, "%2F", "/")
+}
+
+// ListObjects lists the latest version of the objects in a bucket, from the
+// object index. Keys sharing a prefix up to the delimiter are rolled up into
+// common prefixes, each counting as a single entry.
+func ListObjects(bucketName string, opts ListObjectsOptions) (*ListBucketResult, error) {
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, err
+	}
+
+	enc := func(s string) string {
+		return encodeKey(s, opts.EncodingType)
+	}
+
+	res := &ListBucketResult{
+		Name:         bucketName,
+		Prefix:       enc(opts.Prefix),
+		Delimiter:    enc(opts.Delimiter),
+		MaxKeys:      opts.MaxKeys,
+		EncodingType: opts.EncodingType,
+	}
+
+	startAfter := opts.Marker
+
+	if opts.V2 {
+		res.StartAfter = enc(opts.StartAfter)
+		res.ContinuationToken = opts.ContinuationToken
+
+		startAfter = opts.StartAfter
+
+		if opts.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(opts.ContinuationToken)
+			if err != nil {
+				return nil, core.ErrorInvalidArgument("The continuation token provided is incorrect")
+			}
+
+			startAfter = string(token)
+		}
+	} else {
+		res.Marker = enc(opts.Marker)
+	}
+
+	page, err := index.Default.List(bucketName, index.ListOptions{
+		Prefix:     opts.Prefix,
+		Delimiter:  opts.Delimiter,
+		StartAfter: startAfter,
+		MaxKeys:    opts.MaxKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects have no owner of their own, so they belong to the bucket owner
+	var owner *Owner
+
+	if !opts.V2 || opts.FetchOwner {
+		record, err := bucket.ReadRecord(bucketName)
+		if err != nil {
+			return nil, err
+		}
+
+		owner = &Owner{ID: record.Owner, DisplayName: record.Owner}
+	}
+
+	for _, entry := range page.Entries {
+		res.Contents = append(res.Contents, Object{
+			Key:          enc(entry.Key),
+			LastModified: core.FormatTimestamp(entry.LastModified),
+			ETag:         QuoteETag(entry.ETag),
+			Size:         entry.Size,
+			StorageClass: "STANDARD",
+			Owner:        owner,
+		})
+	}
+
+	for _, prefix := range page.CommonPrefixes {
+		res.CommonPrefixes = append(res.CommonPrefixes, CommonPrefix{Prefix: enc(prefix)})
+	}
+
+	res.IsTruncated = page.IsTruncated
+
+	if opts.V2 {
+		keyCount := len(page.Entries) + len(page.CommonPrefixes)
+		res.KeyCount = &keyCount
+
+		if page.IsTruncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(page.NextMarker))
+		}
+	} else if page.IsTruncated {
+		res.NextMarker = enc(page.NextMarker)
+	}
+
+	return res, nil
+}
+
+// parseMaxKeys reads the max-keys query parameter, capped at the maximum
+// number of keys per listing.
+func parseMaxKeys(c *fiber.Ctx) (int, error) {
+	value := c.Query("max-keys")
+	if value == "" {
+		return maxListKeys, nil
+	}
+
+	maxKeys, err := strconv.Atoi(value)
+	if err != nil || maxKeys < 0 {
+		return 0, core.ErrorInvalidArgument("Provided max-keys not an integer or within integer range")
+	}
+
+	return min(maxKeys, maxListKeys), nil
+}
+
+// ListObjectsHandler: GET /:bucket
+func ListObjectsHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	requestID := middleware.NewRequestID()
+
+	opts := ListObjectsOptions{
+		V2:                c.Query("list-type") == "2",
+		Prefix:            c.Query("prefix"),
+		Delimiter:         c.Query("delimiter"),
+		Marker:            c.Query("marker"),
+		StartAfter:        c.Query("start-after"),
+		ContinuationToken: c.Query("continuation-token"),
+		EncodingType:      c.Query("encoding-type"),
+		FetchOwner:        c.QueryBool("fetch-owner"),
+	}
+
+	if opts.EncodingType != "" && opts.EncodingType != "url" {
+		err := core.ErrorInvalidArgument("Invalid Encoding Method specified in Request")
+		core.HandleError(c, err)
+		return err
+	}
+
+	maxKeys, err := parseMaxKeys(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	opts.MaxKeys = maxKeys
+
+	res, err := ListObjects(bucket, opts)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Set("Server", "LabStore")
+	c.Set("X-Amz-Request-Id", requestID)
+
+	return c.XML(res)
+}
@@ -0,0 +1,225 @@
+package object
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/compression"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+const userMetadataPrefix = "x-amz-meta-"
+
+// Stored content headers, returned as-is on GET and HEAD
+var contentHeaders = []string{
+	fiber.HeaderContentType,
+	fiber.HeaderContentEncoding,
+	fiber.HeaderContentLanguage,
+	fiber.HeaderContentDisposition,
+	fiber.HeaderCacheControl,
+	fiber.HeaderExpires,
+}
+
+type Metadata struct {
+	VersionID    string            `json:"versionId,omitempty"`
+	DeleteMarker bool              `json:"deleteMarker,omitempty"`
+	ETag         string            `json:"etag"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Retention    *ObjectRetention  `json:"retention,omitempty"`
+	LegalHold    bool              `json:"legalHold,omitempty"`
+	Encryption   *sse.Info         `json:"encryption,omitempty"`
+	Compression  *compression.Info `json:"compression,omitempty"`
+
+	// Small objects keep their data, as stored, in the metadata, instead of a
+	// separate file
+	Inline     bool   `json:"inline,omitempty"`
+	InlineData []byte `json:"inlineData,omitempty"`
+
+	// Deduplicated objects keep their data in a blob, shared with any other
+	// object version with the same stored data, given by its SHA-256
+	Blob string `json:"blob,omitempty"`
+
+	// Chunked objects keep their data in blobs, one for each content-defined
+	// chunk, in order
+	Chunks []Chunk `json:"chunks,omitempty"`
+
+	// Versions whose data failed verification by a scrub are quarantined, and
+	// their data is not served until healed
+	Quarantine *Quarantine `json:"quarantine,omitempty"`
+}
+
+// ValidateKey rejects keys that would resolve outside of their bucket once
+// joined into a storage path: empty keys, keys starting with a slash, and keys
+// with a . or .. segment.
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return core.ErrorInvalidArgument("Object key is not valid")
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return core.ErrorInvalidArgument("Object key is not valid")
+		}
+	}
+
+	return nil
+}
+
+func metadataPath(bucket, key string) string {
+	return config.BucketSystemPath(bucket, "objects", key+".json")
+}
+
+// ReadMetadata loads the metadata for an object, falling back to what can be
+// derived from the object file, for objects stored without metadata.
+func ReadMetadata(bucket, key string) (*Metadata, error) {
+	data, err := storage.ReadFile(metadataPath(bucket, key))
+	if storage.IsNotExist(err) {
+		return metadataFromFile(objectPath(bucket, key), key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read object metadata: %w", err)
+	}
+
+	var meta Metadata
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("could not decode object metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+func WriteMetadata(bucket, key string, meta *Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("could not encode object metadata: %w", err)
+	}
+
+	if err := storage.WriteFile(metadataPath(bucket, key), data); err != nil {
+		return fmt.Errorf("could not write object metadata: %w", err)
+	}
+
+	if err := indexLatest(bucket, key, meta); err != nil {
+		return err
+	}
+
+	return trackVersion(bucket, key, meta)
+}
+
+func DeleteMetadata(bucket, key string) error {
+	err := storage.Delete(metadataPath(bucket, key))
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete object metadata: %w", err)
+	}
+
+	return indexLatest(bucket, key, nil)
+}
+
+func metadataFromFile(objPath, key string) (*Metadata, error) {
+	info, err := storage.Stat(objPath)
+	if err != nil || info.IsDir {
+		return nil, ErrorNoSuchKey()
+	}
+
+	f, err := storage.Open(objPath)
+	if err != nil {
+		return nil, ErrorNoSuchKey()
+	}
+	defer f.Close()
+
+	hash := md5.New()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, core.ErrorInternalError("Failed to read object")
+	}
+
+	meta := &Metadata{
+		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		Size:         info.Size,
+		LastModified: info.ModTime,
+		Headers: map[string]string{
+			fiber.HeaderContentType: defaultContentType(key),
+		},
+	}
+
+	return meta, nil
+}
+
+func defaultContentType(key string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(key)); mimeType != "" {
+		return mimeType
+	}
+
+	return fiber.MIMEOctetStream
+}
+
+// NewMetadata returns empty metadata for a new object, with its content type
+// guessed from the key. ETag, size and last modified date are set on write.
+func NewMetadata(key string) *Metadata {
+	return &Metadata{
+		Headers: map[string]string{
+			fiber.HeaderContentType: defaultContentType(key),
+		},
+		UserMetadata: map[string]string{},
+	}
+}
+
+// MetadataFromRequest extracts content headers and user metadata from the
+// request headers.
+func MetadataFromRequest(c *fiber.Ctx, key string) *Metadata {
+	meta := NewMetadata(key)
+
+	for _, header := range contentHeaders {
+		if value := c.Get(header); value != "" {
+			meta.Headers[header] = value
+		}
+	}
+
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		name := strings.ToLower(string(k))
+
+		if after, ok := strings.CutPrefix(name, userMetadataPrefix); ok {
+			meta.UserMetadata[after] = string(v)
+		}
+	})
+
+	return meta
+}
+
+// SetResponseHeaders sets object headers for GET and HEAD responses.
+func (m *Metadata) SetResponseHeaders(c *fiber.Ctx) {
+	for header, value := range m.Headers {
+		c.Set(header, value)
+	}
+
+	for name, value := range m.UserMetadata {
+		c.Set(userMetadataPrefix+name, value)
+	}
+
+	if len(m.Tags) > 0 {
+		c.Set("X-Amz-Tagging-Count", strconv.Itoa(len(m.Tags)))
+	}
+
+	c.Set(fiber.HeaderETag, QuoteETag(m.ETag))
+	c.Response().Header.SetLastModified(m.LastModified)
+}
+
+func QuoteETag(etag string) string {
+	return `"` + etag + `"`
+}
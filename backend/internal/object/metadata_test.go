@@ -0,0 +1,31 @@
+package object
+
+import "testing"
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"plain", "data.txt", true},
+		{"nested", "a/b/c.txt", true},
+		{"dots in name", "a/..b/c..", true},
+		{"hidden", ".config", true},
+		{"empty", "", false},
+		{"absolute", "/etc/passwd", false},
+		{"parent", "../secret/data.txt", false},
+		{"inner parent", "a/../../secret/data.txt", false},
+		{"trailing parent", "a/..", false},
+		{"current", "./x", false},
+		{"inner current", "a/./b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateKey(tt.key); (err == nil) != tt.valid {
+				t.Errorf("ValidateKey(%q) = %v, want valid %v", tt.key, err, tt.valid)
+			}
+		})
+	}
+}
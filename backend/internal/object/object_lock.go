@@ -0,0 +1,397 @@
+package object
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	LegalHoldOn  = "ON"
+	LegalHoldOff = "OFF"
+)
+
+func ErrorObjectLocked() *core.S3Error {
+	return &core.S3Error{
+		Code:       "AccessDenied",
+		Message:    "Access Denied because object protected by object lock",
+		StatusCode: fiber.StatusForbidden,
+	}
+}
+
+func ErrorNoSuchObjectLockConfiguration() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchObjectLockConfiguration",
+		Message:    "The specified object does not have a ObjectLock configuration",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+func ErrorMissingObjectLockConfiguration() *core.S3Error {
+	return core.ErrorInvalidRequest("Bucket is missing Object Lock Configuration")
+}
+
+// ObjectRetention is the retention stored with each object version.
+type ObjectRetention struct {
+	Mode            string    `json:"mode"`
+	RetainUntilDate time.Time `json:"retainUntilDate"`
+}
+
+type Retention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Mode            string   `xml:",omitempty"`
+	RetainUntilDate string   `xml:",omitempty"`
+}
+
+type LegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string
+}
+
+// Active reports whether the retention period has not yet expired.
+func (r *ObjectRetention) Active() bool {
+	return r != nil && time.Now().Before(r.RetainUntilDate)
+}
+
+// CheckLock fails when a version cannot be permanently deleted or replaced,
+// due to a legal hold or an active retention period. Governance retention can
+// be bypassed by users with s3:BypassGovernanceRetention.
+func (m *Metadata) CheckLock(bypassGovernance bool) error {
+	if m.LegalHold {
+		return ErrorObjectLocked()
+	}
+
+	if !m.Retention.Active() {
+		return nil
+	}
+
+	if m.Retention.Mode == bucket.RetentionGovernance && bypassGovernance {
+		return nil
+	}
+
+	return ErrorObjectLocked()
+}
+
+// SetObjectLockHeaders sets the retention and legal hold headers for GET and
+// HEAD responses.
+func (m *Metadata) SetObjectLockHeaders(c *fiber.Ctx) {
+	if m.Retention != nil {
+		c.Set("X-Amz-Object-Lock-Mode", m.Retention.Mode)
+		c.Set("X-Amz-Object-Lock-Retain-Until-Date", m.Retention.RetainUntilDate.Format(time.RFC3339))
+	}
+
+	if m.LegalHold {
+		c.Set("X-Amz-Object-Lock-Legal-Hold", LegalHoldOn)
+	}
+}
+
+// BypassGovernance reports whether the request asks to bypass governance
+// retention and is allowed to.
+func BypassGovernance(c *fiber.Ctx, bucketName string) bool {
+	bypass, _ := strconv.ParseBool(c.Get("X-Amz-Bypass-Governance-Retention"))
+	return bypass && middleware.CheckIAM(c, iam.BypassGovernanceRetention, bucketName)
+}
+
+func parseRetention(mode, retainUntilDate string) (*ObjectRetention, error) {
+	if mode != bucket.RetentionGovernance && mode != bucket.RetentionCompliance {
+		return nil, core.ErrorInvalidArgument("Unknown wormMode directive: " + mode)
+	}
+
+	date, err := time.Parse(time.RFC3339, retainUntilDate)
+	if err != nil {
+		return nil, core.ErrorInvalidArgument("The retain until date must be provided in ISO 8601 format")
+	}
+
+	if !date.After(time.Now()) {
+		return nil, core.ErrorInvalidArgument("The retain until date must be in the future")
+	}
+
+	return &ObjectRetention{Mode: mode, RetainUntilDate: date.UTC()}, nil
+}
+
+func parseLegalHold(status string) (bool, error) {
+	switch status {
+	case LegalHoldOn:
+		return true, nil
+	case LegalHoldOff:
+		return false, nil
+	default:
+		return false, core.ErrorInvalidArgument("Legal Hold must be either of 'ON' or 'OFF'")
+	}
+}
+
+// ObjectLockFromRequest reads the x-amz-object-lock-* headers of PutObject and
+// CopyObject into the metadata of the new version.
+func ObjectLockFromRequest(c *fiber.Ctx, meta *Metadata) error {
+	mode := c.Get("X-Amz-Object-Lock-Mode")
+	retainUntilDate := c.Get("X-Amz-Object-Lock-Retain-Until-Date")
+
+	if (mode == "") != (retainUntilDate == "") {
+		return core.ErrorInvalidArgument("x-amz-object-lock-retain-until-date and x-amz-object-lock-mode must both be supplied")
+	}
+
+	if mode != "" {
+		retention, err := parseRetention(mode, retainUntilDate)
+		if err != nil {
+			return err
+		}
+
+		meta.Retention = retention
+	}
+
+	if status := c.Get("X-Amz-Object-Lock-Legal-Hold"); status != "" {
+		legalHold, err := parseLegalHold(status)
+		if err != nil {
+			return err
+		}
+
+		meta.LegalHold = legalHold
+	}
+
+	return nil
+}
+
+// applyObjectLock validates the lock settings of a new version against the
+// bucket, and applies the bucket's default retention when none was given.
+func applyObjectLock(bucketName string, meta *Metadata) error {
+	record, err := bucket.ReadRecord(bucketName)
+	if err != nil {
+		return err
+	}
+
+	if !record.ObjectLockEnabled {
+		if meta.Retention != nil || meta.LegalHold {
+			return ErrorMissingObjectLockConfiguration()
+		}
+
+		return nil
+	}
+
+	if meta.Retention != nil {
+		return nil
+	}
+
+	conf, err := bucket.GetObjectLockConfiguration(bucketName)
+	if err != nil {
+		return err
+	}
+
+	if conf.Rule != nil && conf.Rule.DefaultRetention != nil {
+		meta.Retention = &ObjectRetention{
+			Mode:            conf.Rule.DefaultRetention.Mode,
+			RetainUntilDate: conf.Rule.DefaultRetention.RetainUntil(meta.LastModified),
+		}
+	}
+
+	return nil
+}
+
+// resolveLockedVersion resolves an object version for the retention and legal
+// hold subresources, which require object lock to be enabled on the bucket.
+func resolveLockedVersion(bucketName, key, versionID string) (*Metadata, string, error) {
+	record, err := bucket.ReadRecord(bucketName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !record.ObjectLockEnabled {
+		return nil, "", ErrorMissingObjectLockConfiguration()
+	}
+
+	return ResolveVersion(bucketName, key, versionID)
+}
+
+func GetObjectRetention(bucketName, key, versionID string) (*Retention, error) {
+	meta, _, err := resolveLockedVersion(bucketName, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Retention == nil {
+		return nil, ErrorNoSuchObjectLockConfiguration()
+	}
+
+	res := &Retention{
+		Mode:            meta.Retention.Mode,
+		RetainUntilDate: core.FormatTimestamp(meta.Retention.RetainUntilDate),
+	}
+
+	return res, nil
+}
+
+// PutObjectRetention sets or removes the retention of a version. Compliance
+// retention can only be extended, while shortening or removing governance
+// retention requires bypassing it.
+func PutObjectRetention(ctx context.Context, bucketName, key, versionID string, req *Retention, bypassGovernance bool) error {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, path, err := resolveLockedVersion(bucketName, key, versionID)
+	if err != nil {
+		return err
+	}
+
+	var retention *ObjectRetention
+
+	if req.Mode != "" || req.RetainUntilDate != "" {
+		if retention, err = parseRetention(req.Mode, req.RetainUntilDate); err != nil {
+			return err
+		}
+	}
+
+	if current := meta.Retention; current.Active() {
+		weakened := retention == nil ||
+			retention.RetainUntilDate.Before(current.RetainUntilDate) ||
+			(current.Mode == bucket.RetentionCompliance && retention.Mode != bucket.RetentionCompliance)
+
+		if weakened && (current.Mode == bucket.RetentionCompliance || !bypassGovernance) {
+			return ErrorObjectLocked()
+		}
+	}
+
+	meta.Retention = retention
+
+	return updateVersionMetadata(bucketName, key, path, meta)
+}
+
+func GetObjectLegalHold(bucketName, key, versionID string) (*LegalHold, error) {
+	meta, _, err := resolveLockedVersion(bucketName, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &LegalHold{Status: LegalHoldOff}
+
+	if meta.LegalHold {
+		res.Status = LegalHoldOn
+	}
+
+	return res, nil
+}
+
+func PutObjectLegalHold(ctx context.Context, bucketName, key, versionID string, req *LegalHold) error {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, path, err := resolveLockedVersion(bucketName, key, versionID)
+	if err != nil {
+		return err
+	}
+
+	if meta.LegalHold, err = parseLegalHold(req.Status); err != nil {
+		return err
+	}
+
+	return updateVersionMetadata(bucketName, key, path, meta)
+}
+
+// GetObjectRetentionHandler: GET /:bucket/:key?retention
+func GetObjectRetentionHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	res, err := GetObjectRetention(bucket, key, versionID)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
+
+// PutObjectRetentionHandler: PUT /:bucket/:key?retention
+func PutObjectRetentionHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	var req Retention
+
+	if err := xml.Unmarshal(c.Body(), &req); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	err = PutObjectRetention(c.UserContext(), bucket, key, versionID, &req, BypassGovernance(c, bucket))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+// GetObjectLegalHoldHandler: GET /:bucket/:key?legal-hold
+func GetObjectLegalHoldHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	res, err := GetObjectLegalHold(bucket, key, versionID)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	return c.XML(res)
+}
+
+// PutObjectLegalHoldHandler: PUT /:bucket/:key?legal-hold
+func PutObjectLegalHoldHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	var req LegalHold
+
+	if err := xml.Unmarshal(c.Body(), &req); err != nil {
+		err := core.ErrorMalformedXML()
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutObjectLegalHold(c.UserContext(), bucket, key, versionID, &req); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	c.Status(fiber.StatusOK)
+	return nil
+}
@@ -2,50 +2,144 @@ package object
 
 import (
 	"bytes"
-	"io"
-	"os"
-	"path/filepath"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"time"
 
-	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
 	"github.com/gofiber/fiber/v2"
 )
 
-func PutObject(bucket string, key string, data []byte) error {
-	bucketPath := filepath.Join(config.Env.StorageRoot, bucket)
-	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		return core.ErrorNoSuchBucket()
+// PutObject writes a new object version, encrypted as requested by sseReq or
+// by the bucket's default encryption. Nothing is written when the version
+// would exceed the quota of the bucket or its owner.
+func PutObject(ctx context.Context, bucketName, key string, data []byte, meta *Metadata, sseReq *sse.Request) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := bucket.Lookup(bucketName); err != nil {
+		return err
 	}
 
-	objPath := filepath.Join(bucketPath, key)
-	objDir := filepath.Dir(objPath)
-	os.MkdirAll(objDir, 0755)
+	status, err := bucket.GetVersioningStatus(bucketName)
+	if err != nil {
+		return err
+	}
 
-	f, err := os.Create(objPath)
+	reservation, err := reserveQuota(bucketName, key, status, int64(len(data)))
 	if err != nil {
-		return core.ErrorInternalError("Failed to create object")
+		return err
+	}
+	defer reservation.release()
+
+	meta.LastModified = time.Now().UTC()
+
+	if err := applyObjectLock(bucketName, meta); err != nil {
+		return err
+	}
+
+	if sseReq, err = resolveEncryption(bucketName, sseReq); err != nil {
+		return err
+	}
+
+	algorithm, err := bucket.CompressionAlgorithm(bucketName, key, meta.Headers[fiber.HeaderContentType])
+	if err != nil {
+		return err
+	}
+
+	params, err := chunking(bucketName, sseReq, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	arn := sse.ObjectARN(bucketName, key)
+	staged := ""
+
+	switch {
+	case inlines(int64(len(data))):
+		err = encodeInline(meta, arn, bytes.NewReader(data), sseReq, algorithm)
+	case params != nil:
+		err = writeChunks(ctx, bucketName, key, meta, bytes.NewReader(data), *params, algorithm)
+	case dedupes():
+		err = writeBlob(ctx, bucketName, key, meta, bytes.NewReader(data), sseReq, algorithm)
+	default:
+		staged = stagingPath()
+		meta.Encryption, meta.Compression, err = writeData(ctx, staged, arn, bytes.NewReader(data), sseReq, algorithm)
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, bytes.NewReader(data))
 	if err != nil {
-		return core.ErrorInternalError("Failed to write object")
+		if staged != "" {
+			storage.Delete(staged)
+		}
+
+		return writeError(err, "Failed to write object")
+	}
+
+	hash := md5.Sum(data)
+
+	meta.VersionID = newVersionID(status)
+	meta.ETag = hex.EncodeToString(hash[:])
+	meta.Size = int64(len(data))
+
+	if err := commitObject(bucketName, key, status, staged, meta); err != nil {
+		return err
 	}
 
+	reservation.warn()
+
 	return nil
 }
 
 // PutObjectHandler: PUT /:bucket/:key
 func PutObjectHandler(c *fiber.Ctx) error {
+	if c.Get("X-Amz-Copy-Source") != "" {
+		return CopyObjectHandler(c)
+	}
+
 	bucket := c.Params("bucket")
 	key := c.Params("key")
 	data := c.Body()
 
-	if err := PutObject(bucket, key, data); err != nil {
+	meta := MetadataFromRequest(c, key)
+
+	tags, err := core.ParseTaggingHeader(c.Get("X-Amz-Tagging"))
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+	meta.Tags = tags
+
+	if err := ObjectLockFromRequest(c, meta); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	sseReq, err := sse.ParseRequest(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := PutObject(c.UserContext(), bucket, key, data, meta, sseReq); err != nil {
 		core.HandleError(c, err)
 		return err
 	}
 
+	meta.SetVersionHeaders(c)
+	meta.Encryption.SetResponseHeaders(c)
+	c.Set(fiber.HeaderETag, QuoteETag(meta.ETag))
 	c.Status(fiber.StatusOK)
 	return nil
 }
@@ -0,0 +1,318 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorQuotaExceeded() *core.S3Error {
+	return &core.S3Error{
+		Code:       "QuotaExceeded",
+		Message:    "The object would exceed the storage quota of the bucket or its owner",
+		StatusCode: fiber.StatusForbidden,
+	}
+}
+
+// trackVersion adds an object version to the usage of its bucket, unless it
+// is a delete marker. The blob reference ID is used, as it is unique to the
+// version.
+func trackVersion(bucketName, key string, meta *Metadata) error {
+	if meta.DeleteMarker {
+		return nil
+	}
+
+	if err := index.Default.AddVersion(bucketName, meta.blobRef(key), meta.Size); err != nil {
+		return fmt.Errorf("could not track object usage: %w", err)
+	}
+
+	return nil
+}
+
+// untrackVersion removes an object version from the usage of its bucket.
+func untrackVersion(bucketName, key string, meta *Metadata) error {
+	if meta.DeleteMarker {
+		return nil
+	}
+
+	if err := index.Default.RemoveVersion(bucketName, meta.blobRef(key)); err != nil {
+		return fmt.Errorf("could not track object usage: %w", err)
+	}
+
+	return nil
+}
+
+// quotaScope is a quota along with the buckets it applies to.
+type quotaScope struct {
+	name    string
+	quota   *bucket.Quota
+	buckets []string
+
+	// Committed usage when the reservation was made
+	used *index.Usage
+}
+
+func (s *quotaScope) usage() (*index.Usage, error) {
+	total := &index.Usage{}
+
+	for _, name := range s.buckets {
+		u, err := index.Default.Usage(name)
+		if err != nil {
+			return nil, err
+		}
+
+		total.Objects += u.Objects
+		total.Bytes += u.Bytes
+	}
+
+	return total, nil
+}
+
+// exceeds reports whether growing past the committed and pending usage breaks
+// either limit. Writes that do not grow the usage are always allowed.
+func (s *quotaScope) exceeds(pending, growth *index.Usage) bool {
+	q := s.quota
+
+	if q.MaxObjects > 0 && growth.Objects > 0 && s.used.Objects+pending.Objects+growth.Objects > q.MaxObjects {
+		return true
+	}
+
+	return q.MaxBytes > 0 && growth.Bytes > 0 && s.used.Bytes+pending.Bytes+growth.Bytes > q.MaxBytes
+}
+
+// warn logs a warning for each limit whose warning threshold is reached by
+// growing from the committed usage.
+func (s *quotaScope) warn(growth *index.Usage) {
+	q := s.quota
+
+	crosses := func(used, growth, limit int64) bool {
+		threshold := (limit*int64(q.WarnPercent) + 99) / 100
+		return limit > 0 && used < threshold && used+growth >= threshold
+	}
+
+	if crosses(s.used.Objects, growth.Objects, q.MaxObjects) {
+		logger.Log.Warnf(
+			"Quota of %s is %d%% used, at %d of %d objects",
+			s.name, q.WarnPercent, s.used.Objects+growth.Objects, q.MaxObjects,
+		)
+	}
+
+	if crosses(s.used.Bytes, growth.Bytes, q.MaxBytes) {
+		logger.Log.Warnf(
+			"Quota of %s is %d%% used, at %d of %d bytes",
+			s.name, q.WarnPercent, s.used.Bytes+growth.Bytes, q.MaxBytes,
+		)
+	}
+}
+
+// Writes that passed the quota checks, but are not yet committed, are kept as
+// pending, per scope, so that concurrent writes cannot overshoot a quota.
+var pendingQuota = struct {
+	sync.Mutex
+	usage map[string]*index.Usage
+}{usage: map[string]*index.Usage{}}
+
+// quotaReservation is the growth of a write reserved against the quotas of its
+// bucket and the bucket owner.
+type quotaReservation struct {
+	scopes []*quotaScope
+	growth *index.Usage
+}
+
+// reserveQuota checks that writing an object version of the given size stays
+// within the quotas of a bucket and its owner, counting out the versions it
+// replaces, and reserves the growth until released. Buckets without quotas
+// get a nil reservation.
+func reserveQuota(bucketName, key, status string, size int64) (*quotaReservation, error) {
+	scopes, err := quotaScopes(bucketName)
+	if err != nil || len(scopes) == 0 {
+		return nil, err
+	}
+
+	growth, err := quotaGrowth(bucketName, key, status, size)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingQuota.Lock()
+	defer pendingQuota.Unlock()
+
+	for _, s := range scopes {
+		if s.used, err = s.usage(); err != nil {
+			return nil, err
+		}
+
+		pending := pendingQuota.usage[s.name]
+		if pending == nil {
+			pending = &index.Usage{}
+		}
+
+		if s.exceeds(pending, growth) {
+			logger.Log.Warnf("Rejected write of %s/%s over the quota of %s", bucketName, key, s.name)
+			return nil, ErrorQuotaExceeded()
+		}
+	}
+
+	for _, s := range scopes {
+		pending := pendingQuota.usage[s.name]
+		if pending == nil {
+			pending = &index.Usage{}
+			pendingQuota.usage[s.name] = pending
+		}
+
+		pending.Objects += max(growth.Objects, 0)
+		pending.Bytes += max(growth.Bytes, 0)
+	}
+
+	return &quotaReservation{scopes: scopes, growth: growth}, nil
+}
+
+// release gives back the reserved growth, once the write is committed, or
+// has failed.
+func (r *quotaReservation) release() {
+	if r == nil {
+		return
+	}
+
+	pendingQuota.Lock()
+	defer pendingQuota.Unlock()
+
+	for _, s := range r.scopes {
+		pending := pendingQuota.usage[s.name]
+
+		pending.Objects -= max(r.growth.Objects, 0)
+		pending.Bytes -= max(r.growth.Bytes, 0)
+
+		if pending.Objects == 0 && pending.Bytes == 0 {
+			delete(pendingQuota.usage, s.name)
+		}
+	}
+}
+
+// warn logs the quotas that reached their warning threshold with the write,
+// once committed.
+func (r *quotaReservation) warn() {
+	if r == nil {
+		return
+	}
+
+	for _, s := range r.scopes {
+		s.warn(r.growth)
+	}
+}
+
+func quotaScopes(bucketName string) ([]*quotaScope, error) {
+	var scopes []*quotaScope
+
+	quota, err := bucket.BucketQuota(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	if quota != nil {
+		scopes = append(scopes, &quotaScope{name: "bucket " + bucketName, quota: quota, buckets: []string{bucketName}})
+	}
+
+	record, err := bucket.ReadRecord(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	if quota, err = bucket.UserQuota(record.Owner); err != nil {
+		return nil, err
+	}
+
+	if quota != nil {
+		buckets, err := bucket.OwnedBuckets(record.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		scopes = append(scopes, &quotaScope{name: "user " + record.Owner, quota: quota, buckets: buckets})
+	}
+
+	return scopes, nil
+}
+
+// quotaGrowth returns how much a new object version grows the usage of its
+// bucket, after the versions it replaces, depending on the versioning status,
+// are removed.
+func quotaGrowth(bucketName, key, status string, size int64) (*index.Usage, error) {
+	growth := &index.Usage{Objects: 1, Bytes: size}
+
+	remove := func(meta *Metadata) {
+		if meta != nil && !meta.DeleteMarker {
+			growth.Objects--
+			growth.Bytes -= meta.Size
+		}
+	}
+
+	if status == bucket.VersioningEnabled {
+		return growth, nil
+	}
+
+	latest, err := readLatest(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == bucket.VersioningUnversioned {
+		remove(latest)
+		return growth, nil
+	}
+
+	null, err := readVersionMetadata(versionMetadataPath(bucketName, key, NullVersionID))
+	if err != nil && !storage.IsNotExist(err) {
+		return nil, err
+	}
+
+	remove(null)
+
+	if latest != nil && latest.ExposedVersionID() == NullVersionID {
+		remove(latest)
+	}
+
+	return growth, nil
+}
+
+// UserUsage reports the usage of all buckets owned by a user, which counts
+// against their quota.
+type UserUsage struct {
+	User    string        `json:"user"`
+	Buckets []string      `json:"buckets"`
+	Objects int64         `json:"objects"`
+	Bytes   int64         `json:"bytes"`
+	Quota   *bucket.Quota `json:"quota,omitempty"`
+}
+
+// GetUserUsage returns the usage of a user, as tracked for quotas.
+func GetUserUsage(user string) (*UserUsage, error) {
+	if err := bucket.LookupUser(user); err != nil {
+		return nil, err
+	}
+
+	quota, err := bucket.UserQuota(user)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := bucket.OwnedBuckets(user)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &quotaScope{buckets: buckets}
+
+	used, err := scope.usage()
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserUsage{User: user, Buckets: buckets, Objects: used.Objects, Bytes: used.Bytes, Quota: quota}, nil
+}
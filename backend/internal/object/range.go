@@ -0,0 +1,96 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorInvalidRange() *core.S3Error {
+	return &core.S3Error{
+		Code:       "InvalidRange",
+		Message:    "The requested range is not satisfiable",
+		StatusCode: fiber.StatusRequestedRangeNotSatisfiable,
+	}
+}
+
+// ByteRange is a satisfiable range of an object, as requested by the Range
+// header.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ParseRange reads a single byte range (bytes=start-end, bytes=start- or
+// bytes=-suffix) for an object of the given size. It returns nil when the
+// whole object should be returned, which, as per S3, includes multiple ranges
+// and malformed headers.
+func ParseRange(header string, size int64) (*ByteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+
+		if suffix == 0 || size == 0 {
+			return nil, ErrorInvalidRange()
+		}
+
+		suffix = min(suffix, size)
+
+		return &ByteRange{Start: size - suffix, Length: suffix}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+
+	end := size - 1
+
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return nil, nil
+		}
+
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return nil, ErrorInvalidRange()
+	}
+
+	return &ByteRange{Start: start, Length: end - start + 1}, nil
+}
+
+func (r *ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// Section limits an object reader to the range, keeping it closeable.
+func (r *ByteRange) Section(f io.ReadSeekCloser) (io.ReadCloser, error) {
+	if _, err := f.Seek(r.Start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	section := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, r.Length), f}
+
+	return section, nil
+}
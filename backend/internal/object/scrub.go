@@ -0,0 +1,556 @@
+package object
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/sse"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ErrorObjectQuarantined() *core.S3Error {
+	return &core.S3Error{
+		Code:       "ObjectQuarantined",
+		Message:    "The object data failed verification and was quarantined",
+		StatusCode: fiber.StatusInternalServerError,
+	}
+}
+
+func ErrorScrubInProgress() *core.S3Error {
+	return &core.S3Error{
+		Code:       "ScrubInProgress",
+		Message:    "A scrub is already in progress",
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+// Quarantine records why the data of an object version failed verification.
+type Quarantine struct {
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// Corruption is an object version found quarantined by a scrub.
+type Corruption struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"versionId,omitempty"`
+	Quarantine
+}
+
+// ScrubReport describes a scrub, while running and once finished. Versions
+// stay quarantined, and are reported by every scrub, until healed.
+type ScrubReport struct {
+	StartedAt   time.Time           `json:"startedAt"`
+	FinishedAt  *time.Time          `json:"finishedAt,omitempty"`
+	Buckets     int64               `json:"buckets"`
+	Versions    int64               `json:"versions"`
+	Verified    int64               `json:"verified"`
+	Corrupted   int64               `json:"corrupted"`
+	Healed      int64               `json:"healed"`
+	Skipped     int64               `json:"skipped"`
+	Failed      int64               `json:"failed"`
+	Drives      *storage.HealResult `json:"drives,omitempty"`
+	Corruptions []*Corruption       `json:"corruptions"`
+	Error       string              `json:"error,omitempty"`
+}
+
+type ScrubStatus struct {
+	Interval  string       `json:"interval"`
+	Rate      int64        `json:"rate"`
+	Running   bool         `json:"running"`
+	NextRunAt *time.Time   `json:"nextRunAt,omitempty"`
+	Current   *ScrubReport `json:"current,omitempty"`
+	Last      *ScrubReport `json:"last,omitempty"`
+}
+
+var errUnverifiable = errors.New("data cannot be decrypted to be verified")
+
+type scrubber struct {
+	mu      sync.Mutex
+	started time.Time
+	current *ScrubReport
+	last    *ScrubReport
+
+	// Signaled after every scrub, so that the schedule is updated
+	finished chan struct{}
+}
+
+var scrubs = &scrubber{finished: make(chan struct{}, 1)}
+
+func scrubReportPath() string {
+	return config.SystemPath("scrub", "last.json")
+}
+
+// StartScrubber schedules a scrub every LS_SCRUB_INTERVAL, counting from the
+// end of the last one, or from now, when there was none.
+func StartScrubber() {
+	scrubs.started = time.Now()
+
+	if data, err := storage.ReadFile(scrubReportPath()); err == nil {
+		last := &ScrubReport{}
+
+		if err := json.Unmarshal(data, last); err != nil {
+			logger.Log.Warnf("Ignoring invalid scrub report: %s", err)
+		} else {
+			scrubs.last = last
+		}
+	}
+
+	if config.Env.ScrubInterval > 0 {
+		go scrubs.schedule()
+	}
+}
+
+// Scrub starts a scrub in the background, unless one is already running.
+func Scrub() (*ScrubStatus, error) {
+	report := scrubs.begin()
+	if report == nil {
+		return nil, ErrorScrubInProgress()
+	}
+
+	go scrubs.run(report)
+
+	return scrubs.status(), nil
+}
+
+func GetScrubStatus() *ScrubStatus {
+	return scrubs.status()
+}
+
+func (s *scrubber) schedule() {
+	for {
+		timer := time.NewTimer(time.Until(s.nextRun()))
+
+		select {
+		case <-timer.C:
+			if report := s.begin(); report != nil {
+				s.run(report)
+			} else {
+				<-s.finished
+			}
+		case <-s.finished:
+			timer.Stop()
+		}
+	}
+}
+
+func (s *scrubber) nextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last != nil && s.last.FinishedAt != nil {
+		return s.last.FinishedAt.Add(config.Env.ScrubInterval)
+	}
+
+	return s.started.Add(config.Env.ScrubInterval)
+}
+
+func (s *scrubber) status() *ScrubStatus {
+	status := &ScrubStatus{
+		Interval: config.Env.ScrubInterval.String(),
+		Rate:     config.Env.ScrubRate,
+	}
+
+	if config.Env.ScrubInterval > 0 {
+		next := s.nextRun().UTC()
+		status.NextRunAt = &next
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status.Running = s.current != nil
+	status.Current = s.current.snapshot()
+	status.Last = s.last.snapshot()
+
+	return status
+}
+
+func (r *ScrubReport) snapshot() *ScrubReport {
+	if r == nil {
+		return nil
+	}
+
+	snapshot := *r
+	snapshot.Corruptions = append([]*Corruption{}, r.Corruptions...)
+
+	return &snapshot
+}
+
+// begin returns the report for a new scrub, or nil when one is running.
+func (s *scrubber) begin() *ScrubReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil {
+		return nil
+	}
+
+	s.current = &ScrubReport{StartedAt: time.Now().UTC(), Corruptions: []*Corruption{}}
+
+	return s.current
+}
+
+func (s *scrubber) update(fn func(r *ScrubReport)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s.current)
+}
+
+func (s *scrubber) run(report *ScrubReport) {
+	logger.Log.Infoln("Scrubbing stored data")
+
+	err := s.scrub(context.Background(), report.StartedAt)
+
+	finishedAt := time.Now().UTC()
+
+	s.mu.Lock()
+	report.FinishedAt = &finishedAt
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	s.last = report
+	s.current = nil
+	data, encodeErr := json.Marshal(report)
+	s.mu.Unlock()
+
+	if err != nil {
+		logger.Log.Errorf("Could not scrub stored data: %s", err)
+	} else {
+		logger.Log.Infof(
+			"Scrubbed %d versions in %d buckets: %d verified, %d corrupted, %d healed, %d skipped, %d failed",
+			report.Versions, report.Buckets, report.Verified, report.Corrupted, report.Healed, report.Skipped, report.Failed,
+		)
+	}
+
+	if encodeErr == nil {
+		encodeErr = storage.WriteFile(scrubReportPath(), data)
+	}
+
+	if encodeErr != nil {
+		logger.Log.Errorf("Could not write scrub report: %s", encodeErr)
+	}
+
+	select {
+	case s.finished <- struct{}{}:
+	default:
+	}
+}
+
+// scrub heals the drives, for the erasure driver, and then verifies every
+// object version written before the scrub started against its checksums: the
+// SHA-256 of each blob or chunk it is stored in, or otherwise the MD5 of its
+// data, which is its ETag. Reads are limited by LS_SCRUB_RATE.
+func (s *scrubber) scrub(ctx context.Context, startedAt time.Time) error {
+	throttle := storage.NewThrottle(config.Env.ScrubRate)
+
+	if b, ok := storage.Default.(*storage.Erasure); ok {
+		res, err := b.Heal(ctx, throttle)
+		if err != nil {
+			return err
+		}
+
+		s.update(func(r *ScrubReport) { r.Drives = res })
+	}
+
+	records, err := bucket.ListRecords()
+	if err != nil {
+		return err
+	}
+
+	// Results by blob hash, as blobs are shared by many versions
+	blobs := map[string]string{}
+
+	for _, record := range records {
+		keys, err := listVersionedKeys(record.Name)
+		if err != nil {
+			logger.Log.Errorf("Could not scrub bucket %s: %s", record.Name, err)
+			s.update(func(r *ScrubReport) { r.Failed++ })
+			continue
+		}
+
+		for _, key := range keys {
+			s.scrubObject(ctx, record.Name, key, startedAt, throttle, blobs)
+		}
+
+		s.update(func(r *ScrubReport) { r.Buckets++ })
+	}
+
+	if _, err := blob.PruneQuarantine(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *scrubber) scrubObject(
+	ctx context.Context, bucketName, key string, startedAt time.Time, throttle *storage.Throttle, blobs map[string]string,
+) {
+	var paths []string
+
+	// Objects without metadata have an ETag computed from their data
+	if _, err := storage.Stat(metadataPath(bucketName, key)); err == nil {
+		paths = append(paths, objectPath(bucketName, key))
+	}
+
+	versions, err := ListNoncurrentVersions(bucketName, key)
+	if err != nil {
+		logger.Log.Errorf("Could not scrub %s/%s: %s", bucketName, key, err)
+		s.update(func(r *ScrubReport) { r.Failed++ })
+		return
+	}
+
+	for _, meta := range versions {
+		paths = append(paths, versionDataPath(bucketName, key, meta.VersionID))
+	}
+
+	for _, path := range paths {
+		if err := s.scrubVersion(ctx, bucketName, key, path, startedAt, throttle, blobs); err != nil {
+			logger.Log.Errorf("Could not scrub %s/%s: %s", bucketName, key, err)
+			s.update(func(r *ScrubReport) { r.Failed++ })
+		}
+	}
+}
+
+// scrubVersion verifies an object version, given its data path, quarantining
+// it when corrupt, or releasing it from quarantine when healed. Versions
+// written after the scrub started are left for the next one.
+func (s *scrubber) scrubVersion(
+	ctx context.Context, bucketName, key, path string, startedAt time.Time, throttle *storage.Throttle,
+	blobs map[string]string,
+) error {
+	meta, f, err := openVersion(ctx, bucketName, key, path)
+	if errors.Is(err, errUnverifiable) {
+		s.update(func(r *ScrubReport) { r.Versions++; r.Skipped++ })
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if meta == nil || !meta.LastModified.Before(startedAt) {
+		return nil
+	}
+
+	reason, err := verifyVersion(ctx, meta, f, throttle, blobs)
+	if f != nil {
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	var q *Quarantine
+
+	if reason != "" {
+		q = &Quarantine{Reason: reason, DetectedAt: time.Now().UTC()}
+	}
+
+	quarantined := meta.Quarantine != nil
+
+	if meta, err = setQuarantine(ctx, bucketName, key, path, meta, q); err != nil || meta == nil {
+		return err
+	}
+
+	s.update(func(r *ScrubReport) {
+		r.Versions++
+
+		switch {
+		case q != nil:
+			r.Corrupted++
+			r.Corruptions = append(r.Corruptions, &Corruption{
+				Bucket:     bucketName,
+				Key:        key,
+				VersionID:  meta.VersionID,
+				Quarantine: *meta.Quarantine,
+			})
+		case quarantined:
+			r.Healed++
+		default:
+			r.Verified++
+		}
+	})
+
+	switch {
+	case q != nil && !quarantined:
+		logger.Log.Warnf("Quarantined %s/%s (version %s): %s", bucketName, key, meta.ExposedVersionID(), reason)
+	case q == nil && quarantined:
+		logger.Log.Infof("Released %s/%s (version %s) from quarantine, as its data was healed",
+			bucketName, key, meta.ExposedVersionID())
+	}
+
+	return nil
+}
+
+// readVersion reads the metadata of an object version, given its data path,
+// or returns nil when it no longer exists.
+func readVersion(bucketName, key, path string) (*Metadata, error) {
+	if path == objectPath(bucketName, key) {
+		return readLatest(bucketName, key)
+	}
+
+	meta, err := readVersionMetadata(path + ".json")
+	if storage.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return meta, err
+}
+
+// openVersion reads the metadata of an object version, and opens its data,
+// unless stored in blobs, which are verified by hash instead. Delete markers
+// and versions that no longer exist are returned as nil.
+func openVersion(ctx context.Context, bucketName, key, path string) (*Metadata, io.ReadCloser, error) {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, false)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	meta, err := readVersion(bucketName, key, path)
+	if err != nil || meta == nil || meta.DeleteMarker {
+		return nil, nil, err
+	}
+
+	if meta.Blob != "" || len(meta.Chunks) > 0 {
+		return meta, nil, nil
+	}
+
+	arn := sse.ObjectARN(bucketName, key)
+
+	// SSE-C data keys are only known to clients, and KMS keys can be disabled
+	if _, err := sse.DataKey(meta.Encryption, nil, arn); err != nil {
+		return nil, nil, errUnverifiable
+	}
+
+	f, err := openData(ctx, path, arn, meta, nil)
+	if isNoSuchKey(err) {
+		return meta, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return meta, f, nil
+}
+
+// verifyVersion returns why the data of an object version is corrupt, or an
+// empty string when it is not. Data that is missing, or cannot be decoded, is
+// also corrupt, while storage errors are returned as such.
+func verifyVersion(
+	ctx context.Context, meta *Metadata, f io.Reader, throttle *storage.Throttle, blobs map[string]string,
+) (string, error) {
+	if len(meta.Chunks) > 0 {
+		for _, chunk := range meta.Chunks {
+			if reason, err := verifyBlob(ctx, chunk.Hash, throttle, blobs); reason != "" || err != nil {
+				return reason, err
+			}
+		}
+
+		return "", nil
+	}
+
+	if meta.Blob != "" {
+		return verifyBlob(ctx, meta.Blob, throttle, blobs)
+	}
+
+	if f == nil {
+		return "data is missing", nil
+	}
+
+	h := md5.New()
+
+	if _, err := io.Copy(h, throttle.Reader(ctx, f)); err != nil {
+		var s3Error *core.S3Error
+
+		if errors.As(err, &s3Error) || ctx.Err() != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("data is unreadable: %s", err), nil
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != meta.ETag {
+		return "data does not match its ETag", nil
+	}
+
+	return "", nil
+}
+
+// verifyBlob is the same as verifyVersion, for a blob, which is quarantined
+// when corrupt.
+func verifyBlob(ctx context.Context, hash string, throttle *storage.Throttle, blobs map[string]string) (string, error) {
+	if reason, ok := blobs[hash]; ok {
+		return reason, nil
+	}
+
+	ok, err := blob.Verify(ctx, hash, throttle)
+
+	switch {
+	case storage.IsNotExist(err):
+		// Not cached, as the last version referencing it might have just been
+		// deleted
+		return fmt.Sprintf("blob %s is missing", hash), nil
+	case err != nil:
+		return "", err
+	case !ok:
+		if err := blob.Quarantine(ctx, hash); err != nil {
+			return "", err
+		}
+
+		blobs[hash] = fmt.Sprintf("blob %s does not match its hash", hash)
+	default:
+		blobs[hash] = ""
+	}
+
+	return blobs[hash], nil
+}
+
+// setQuarantine quarantines an object version, or releases it from quarantine
+// when q is nil, unless it changed since it was verified, in which case nil is
+// returned. Versions already quarantined keep their original reason.
+func setQuarantine(ctx context.Context, bucketName, key, path string, seen *Metadata, q *Quarantine) (*Metadata, error) {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	meta, err := readVersion(bucketName, key, path)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	if meta.VersionID != seen.VersionID || meta.ETag != seen.ETag || !meta.LastModified.Equal(seen.LastModified) {
+		return nil, nil
+	}
+
+	if (q == nil) == (meta.Quarantine == nil) {
+		return meta, nil
+	}
+
+	meta.Quarantine = q
+
+	if err := updateVersionMetadata(bucketName, key, path, meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,177 @@
+package object
+
+import (
+	"context"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/gofiber/fiber/v2"
+)
+
+// taggingActions maps each tagging action to its version specific
+// counterpart, required when a versionId is given.
+var taggingActions = map[iam.Action]iam.Action{
+	iam.GetObjectTagging:    iam.GetObjectVersionTagging,
+	iam.PutObjectTagging:    iam.PutObjectVersionTagging,
+	iam.DeleteObjectTagging: iam.DeleteObjectVersionTagging,
+}
+
+func GetObjectTagging(bucketName, key, versionID string) (*Metadata, error) {
+	meta, _, err := ResolveVersion(bucketName, key, versionID)
+	return meta, err
+}
+
+// PutObjectTagging replaces the tag set of a version, or of the latest version
+// when versionID is empty. Passing no tags removes the tag set. When given,
+// authorize is called with the metadata of the version while it is locked, so
+// that conditions on its existing tags hold until the tags are replaced.
+func PutObjectTagging(ctx context.Context, bucketName, key, versionID string, tags map[string]string, authorize func(meta *Metadata) error) (*Metadata, error) {
+	unlock, err := lock.Lock(ctx, lock.ObjectInBucket(bucketName, key, true)...)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	meta, path, err := ResolveVersion(bucketName, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if authorize != nil {
+		if err := authorize(meta); err != nil {
+			return nil, err
+		}
+	}
+
+	meta.Tags = tags
+
+	if err := updateVersionMetadata(bucketName, key, path, meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func DeleteObjectTagging(ctx context.Context, bucketName, key, versionID string, authorize func(meta *Metadata) error) (*Metadata, error) {
+	return PutObjectTagging(ctx, bucketName, key, versionID, nil, authorize)
+}
+
+// authorizeTagging checks the version specific action when a versionId is
+// given, and returns the check of the policy conditions over the existing and
+// requested tags, to be called with the metadata of the version.
+func authorizeTagging(c *fiber.Ctx, action iam.Action, versionID string, requested map[string]string) (func(meta *Metadata) error, error) {
+	bucket := c.Params("bucket")
+
+	if versionID != "" {
+		if !middleware.CheckIAMBeforeTags(c, taggingActions[action], bucket) {
+			return nil, core.ErrorAccessDenied()
+		}
+	}
+
+	authorize := func(meta *Metadata) error {
+		if !middleware.CheckObjectTags(c, bucket, meta.Tags, requested) {
+			return core.ErrorAccessDenied()
+		}
+
+		return nil
+	}
+
+	return authorize, nil
+}
+
+// GetObjectTaggingHandler: GET /:bucket/:key?tagging
+func GetObjectTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	authorize, err := authorizeTagging(c, iam.GetObjectTagging, versionID, nil)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta, err := GetObjectTagging(bucket, key, versionID)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	if err := authorize(meta); err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta.SetVersionHeaders(c)
+
+	return c.XML(core.NewTagging(meta.Tags))
+}
+
+// PutObjectTaggingHandler: PUT /:bucket/:key?tagging
+func PutObjectTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	tags, err := core.ParseTaggingXML(c.Body(), core.MaxObjectTags)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	authorize, err := authorizeTagging(c, iam.PutObjectTagging, versionID, tags)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta, err := PutObjectTagging(c.UserContext(), bucket, key, versionID, tags, authorize)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta.SetVersionHeaders(c)
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+// DeleteObjectTaggingHandler: DELETE /:bucket/:key?tagging
+func DeleteObjectTaggingHandler(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+
+	versionID, err := versionIDParam(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	authorize, err := authorizeTagging(c, iam.DeleteObjectTagging, versionID, nil)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta, err := DeleteObjectTagging(c.UserContext(), bucket, key, versionID, authorize)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
+
+	meta.SetVersionHeaders(c)
+	c.Status(fiber.StatusNoContent)
+	return nil
+}
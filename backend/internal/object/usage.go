@@ -0,0 +1,136 @@
+package object
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/blob"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+)
+
+// Usage reports the storage used by a bucket. The logical size is what
+// clients see, while the stored size is what is used on disk, after
+// compression and encryption, for all object versions. Blobs shared by
+// deduplicated or chunked object versions are only counted once per bucket.
+// Quotas limit the versions and their logical size.
+type Usage struct {
+	Bucket            string `json:"bucket"`
+	Objects           int64  `json:"objects"`
+	Versions          int64  `json:"versions"`
+	CompressedObjects int64  `json:"compressedObjects"`
+	InlinedObjects    int64  `json:"inlinedObjects"`
+	DedupedObjects    int64  `json:"dedupedObjects"`
+	ChunkedObjects    int64  `json:"chunkedObjects"`
+	LogicalSize       int64  `json:"logicalSize"`
+	StoredSize        int64  `json:"storedSize"`
+
+	Quota *bucket.Quota `json:"quota,omitempty"`
+
+	blobs map[string]bool
+}
+
+func (u *Usage) add(meta *Metadata, path string) {
+	u.Versions++
+	u.LogicalSize += meta.Size
+
+	if meta.Compression != nil {
+		u.CompressedObjects++
+	}
+
+	if len(meta.Chunks) > 0 {
+		u.ChunkedObjects++
+
+		if meta.Chunks[0].Compression != nil {
+			u.CompressedObjects++
+		}
+
+		for _, chunk := range meta.Chunks {
+			if !u.blobs[chunk.Hash] {
+				u.blobs[chunk.Hash] = true
+				u.StoredSize += chunk.storedSize()
+			}
+		}
+
+		return
+	}
+
+	if meta.Blob != "" {
+		u.DedupedObjects++
+
+		if u.blobs[meta.Blob] {
+			return
+		}
+
+		u.blobs[meta.Blob] = true
+		path = blob.Path(meta.Blob)
+	}
+
+	if meta.Inline {
+		u.InlinedObjects++
+		u.StoredSize += int64(len(meta.InlineData))
+	} else if info, err := storage.Stat(path); err == nil {
+		u.StoredSize += info.Size
+	}
+}
+
+func BucketUsage(bucketName string) (*Usage, error) {
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, err
+	}
+
+	keys, err := listVersionedKeys(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := bucket.BucketQuota(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{Bucket: bucketName, Quota: quota, blobs: map[string]bool{}}
+
+	for _, key := range keys {
+		latest, err := readLatest(bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if latest != nil && !latest.DeleteMarker {
+			usage.Objects++
+			usage.add(latest, objectPath(bucketName, key))
+		}
+
+		versions, err := ListNoncurrentVersions(bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, meta := range versions {
+			if !meta.DeleteMarker {
+				usage.add(meta, versionDataPath(bucketName, key, meta.VersionID))
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// StorageUsage reports the usage of all buckets.
+func StorageUsage() ([]*Usage, error) {
+	records, err := bucket.ListRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []*Usage{}
+
+	for _, record := range records {
+		usage, err := BucketUsage(record.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
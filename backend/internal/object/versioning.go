@@ -0,0 +1,422 @@
+package object
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// The latest version of an object always stays at <bucket>/<key>, with its
+// metadata under the bucket's objects system directory. Noncurrent versions
+// are moved under the bucket's versions system directory, as <key>/<id> for
+// the data and <key>/<id>.json for the metadata. Delete markers only have
+// metadata. Objects written while the bucket was unversioned have an empty
+// version ID, which S3 exposes as the null version.
+
+const NullVersionID = "null"
+
+func ErrorMethodNotAllowed() *core.S3Error {
+	return &core.S3Error{
+		Code:       "MethodNotAllowed",
+		Message:    "The specified method is not allowed against this resource",
+		StatusCode: fiber.StatusMethodNotAllowed,
+	}
+}
+
+func objectPath(bucket, key string) string {
+	return path.Join(bucket, key)
+}
+
+func versionDataPath(bucket, key, versionID string) string {
+	return config.BucketSystemPath(bucket, "versions", key, versionID)
+}
+
+func versionMetadataPath(bucket, key, versionID string) string {
+	return versionDataPath(bucket, key, versionID) + ".json"
+}
+
+// ValidateVersionID accepts the null version and the UUIDs generated for new
+// versions, along with an empty ID, for the latest version. Version IDs are
+// part of system paths, so anything else is rejected before it reaches one.
+func ValidateVersionID(versionID string) error {
+	if versionID == "" || versionID == NullVersionID {
+		return nil
+	}
+
+	if id, err := uuid.Parse(versionID); err == nil && id.String() == versionID {
+		return nil
+	}
+
+	return core.ErrorInvalidArgument("Invalid version id specified")
+}
+
+// versionIDParam returns the versionId query parameter, once validated.
+func versionIDParam(c *fiber.Ctx) (string, error) {
+	versionID := c.Query("versionId")
+
+	if err := ValidateVersionID(versionID); err != nil {
+		return "", err
+	}
+
+	return versionID, nil
+}
+
+func stagingPath() string {
+	return config.SystemPath("tmp", uuid.NewString())
+}
+
+// newVersionID returns the version ID for a new version, given the bucket
+// versioning status. Version IDs are UUIDv7, so they sort chronologically.
+func newVersionID(status string) string {
+	switch status {
+	case bucket.VersioningEnabled:
+		return uuid.Must(uuid.NewV7()).String()
+	case bucket.VersioningSuspended:
+		return NullVersionID
+	default:
+		return ""
+	}
+}
+
+// ExposedVersionID returns the version ID as exposed by S3, mapping objects
+// written before versioning was enabled to the null version.
+func (m *Metadata) ExposedVersionID() string {
+	if m.VersionID == "" {
+		return NullVersionID
+	}
+
+	return m.VersionID
+}
+
+// SetVersionHeaders sets x-amz-version-id and x-amz-delete-marker, which are
+// omitted for objects that were never versioned.
+func (m *Metadata) SetVersionHeaders(c *fiber.Ctx) {
+	if m.VersionID != "" {
+		c.Set("X-Amz-Version-Id", m.VersionID)
+	}
+
+	if m.DeleteMarker {
+		c.Set("X-Amz-Delete-Marker", "true")
+	}
+}
+
+func isNoSuchKey(err error) bool {
+	var s3Error *core.S3Error
+	return errors.As(err, &s3Error) && s3Error.Code == ErrorNoSuchKey().Code
+}
+
+// readLatest returns the metadata for the latest version of an object, or nil
+// when the key does not exist.
+func readLatest(bucketName, key string) (*Metadata, error) {
+	meta, err := ReadMetadata(bucketName, key)
+	if isNoSuchKey(err) {
+		return nil, nil
+	}
+
+	return meta, err
+}
+
+func readVersionMetadata(path string) (*Metadata, error) {
+	data, err := storage.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Metadata
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("could not decode object version metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+func writeVersionMetadata(path string, meta *Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("could not encode object version metadata: %w", err)
+	}
+
+	if err := storage.WriteFile(path, data); err != nil {
+		return fmt.Errorf("could not write object version metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ResolveVersion finds the metadata and data path for a version of an object,
+// or for the latest version when versionID is empty. Delete markers are
+// returned along with the error, so that handlers can set their headers.
+func ResolveVersion(bucketName, key, versionID string) (*Metadata, string, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, "", err
+	}
+
+	if err := ValidateVersionID(versionID); err != nil {
+		return nil, "", err
+	}
+
+	if err := bucket.Lookup(bucketName); err != nil {
+		return nil, "", err
+	}
+
+	latest, err := readLatest(bucketName, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if versionID == "" {
+		if latest == nil {
+			return nil, "", ErrorNoSuchKey()
+		}
+
+		if latest.DeleteMarker {
+			return latest, "", ErrorNoSuchKey()
+		}
+
+		return latest, objectPath(bucketName, key), nil
+	}
+
+	if latest != nil && latest.ExposedVersionID() == versionID {
+		if latest.DeleteMarker {
+			return latest, "", ErrorMethodNotAllowed()
+		}
+
+		return latest, objectPath(bucketName, key), nil
+	}
+
+	meta, err := readVersionMetadata(versionMetadataPath(bucketName, key, versionID))
+	if storage.IsNotExist(err) {
+		return nil, "", ErrorNoSuchVersion()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if meta.DeleteMarker {
+		return meta, "", ErrorMethodNotAllowed()
+	}
+
+	return meta, versionDataPath(bucketName, key, versionID), nil
+}
+
+// archiveLatest makes room for a new latest version (object, copy or delete
+// marker). With versioning enabled, the latest version becomes noncurrent. With
+// versioning suspended, the null version is replaced instead. Unversioned
+// buckets simply overwrite the object. Locked versions are never replaced.
+func archiveLatest(bucketName, key, status string) error {
+	latest, err := readLatest(bucketName, key)
+	if err != nil || latest == nil {
+		return err
+	}
+
+	if status == bucket.VersioningUnversioned {
+		return latest.CheckLock(false)
+	}
+
+	if status == bucket.VersioningSuspended {
+		null, err := readVersionMetadata(versionMetadataPath(bucketName, key, NullVersionID))
+		if err != nil && !storage.IsNotExist(err) {
+			return err
+		}
+
+		if null != nil {
+			if err := null.CheckLock(false); err != nil {
+				return err
+			}
+
+			if err := deleteNoncurrentVersion(bucketName, key, NullVersionID, null); err != nil {
+				return err
+			}
+		}
+
+		if latest.ExposedVersionID() == NullVersionID {
+			if err := latest.CheckLock(false); err != nil {
+				return err
+			}
+
+			return removeLatest(bucketName, key, latest)
+		}
+	}
+
+	versionID := latest.ExposedVersionID()
+	latest.VersionID = versionID
+
+	if latest.hasFile() {
+		err := storage.Rename(objectPath(bucketName, key), versionDataPath(bucketName, key, versionID))
+
+		// The data was already archived, when resuming an interrupted commit
+		if storage.IsNotExist(err) {
+			_, err = storage.Stat(versionDataPath(bucketName, key, versionID))
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not archive object version: %w", err)
+		}
+	}
+
+	if err := writeVersionMetadata(versionMetadataPath(bucketName, key, versionID), latest); err != nil {
+		return err
+	}
+
+	return DeleteMetadata(bucketName, key)
+}
+
+// updateVersionMetadata rewrites the metadata of a version, given the data
+// path returned by ResolveVersion.
+func updateVersionMetadata(bucketName, key, path string, meta *Metadata) error {
+	if path == objectPath(bucketName, key) {
+		return WriteMetadata(bucketName, key, meta)
+	}
+
+	return writeVersionMetadata(path+".json", meta)
+}
+
+func removeLatest(bucketName, key string, latest *Metadata) error {
+	if latest.hasFile() {
+		if err := storage.Delete(objectPath(bucketName, key)); err != nil && !storage.IsNotExist(err) {
+			return fmt.Errorf("could not delete object: %w", err)
+		}
+	}
+
+	if err := DeleteMetadata(bucketName, key); err != nil {
+		return err
+	}
+
+	if err := untrackVersion(bucketName, key, latest); err != nil {
+		return err
+	}
+
+	return releaseBlobs(bucketName, key, latest)
+}
+
+// deleteNoncurrentVersion permanently deletes a noncurrent version, given its
+// metadata.
+func deleteNoncurrentVersion(bucketName, key, versionID string, meta *Metadata) error {
+	if err := storage.Delete(versionMetadataPath(bucketName, key, versionID)); err != nil {
+		return err
+	}
+
+	err := storage.Delete(versionDataPath(bucketName, key, versionID))
+	if err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("could not delete object version: %w", err)
+	}
+
+	if err := untrackVersion(bucketName, key, meta); err != nil {
+		return err
+	}
+
+	return releaseBlobs(bucketName, key, meta)
+}
+
+// ListNoncurrentVersions returns the noncurrent versions of an object, newest
+// first.
+func ListNoncurrentVersions(bucketName, key string) ([]*Metadata, error) {
+	dir := path.Dir(versionDataPath(bucketName, key, NullVersionID))
+
+	entries, err := storage.List(dir)
+	if storage.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list object versions: %w", err)
+	}
+
+	var versions []*Metadata
+
+	for _, e := range entries {
+		if e.IsDir || !strings.HasSuffix(e.Name, ".json") {
+			continue
+		}
+
+		meta, err := readVersionMetadata(e.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, meta)
+	}
+
+	sortVersions(versions)
+
+	return versions, nil
+}
+
+// sortVersions sorts newest first, with version IDs as a tie-breaker, given
+// they are time-ordered.
+func sortVersions(versions []*Metadata) {
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].LastModified.Equal(versions[j].LastModified) {
+			return versions[i].LastModified.After(versions[j].LastModified)
+		}
+
+		return versions[i].VersionID > versions[j].VersionID
+	})
+}
+
+// promoteNoncurrentVersion makes the newest noncurrent version the latest,
+// after the latest version was permanently deleted.
+func promoteNoncurrentVersion(bucketName, key string) error {
+	versions, err := ListNoncurrentVersions(bucketName, key)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+
+	newest := versions[0]
+
+	if newest.hasFile() {
+		err := storage.Rename(versionDataPath(bucketName, key, newest.VersionID), objectPath(bucketName, key))
+		if err != nil {
+			return fmt.Errorf("could not restore object version: %w", err)
+		}
+	}
+
+	if err := WriteMetadata(bucketName, key, newest); err != nil {
+		return err
+	}
+
+	return storage.Delete(versionMetadataPath(bucketName, key, newest.VersionID))
+}
+
+// putDeleteMarker adds a delete marker as the latest version of an object.
+func putDeleteMarker(bucketName, key, status string) (*Metadata, error) {
+	if err := archiveLatest(bucketName, key, status); err != nil {
+		return nil, err
+	}
+
+	marker := &Metadata{
+		VersionID:    newVersionID(status),
+		LastModified: time.Now().UTC(),
+		DeleteMarker: true,
+	}
+
+	if err := WriteMetadata(bucketName, key, marker); err != nil {
+		return nil, err
+	}
+
+	return marker, nil
+}
+
+// stageFile copies a file into the staging area, so that it can be moved in
+// place after the latest version is archived.
+func stageFile(ctx context.Context, srcPath string) (string, error) {
+	staged := stagingPath()
+
+	if err := storage.Default.Copy(ctx, srcPath, staged); err != nil {
+		return "", err
+	}
+
+	return staged, nil
+}
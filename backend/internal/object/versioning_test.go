@@ -0,0 +1,35 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateVersionID(t *testing.T) {
+	id := uuid.Must(uuid.NewV7()).String()
+
+	tests := []struct {
+		name      string
+		versionID string
+		valid     bool
+	}{
+		{"latest", "", true},
+		{"null", NullVersionID, true},
+		{"generated", id, true},
+		{"traversal", "../../../secret/objects/data.txt", false},
+		{"slash", id + "/x", false},
+		{"braces", "{" + id + "}", false},
+		{"urn", "urn:uuid:" + id, false},
+		{"upper case", "NULL", false},
+		{"garbage", "abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateVersionID(tt.versionID); (err == nil) != tt.valid {
+				t.Errorf("ValidateVersionID(%q) = %v, want valid %v", tt.versionID, err, tt.valid)
+			}
+		})
+	}
+}
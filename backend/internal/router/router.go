@@ -3,45 +3,157 @@ package router
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/DataLabTechTV/labstore/backend/internal/admin"
 	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
 	"github.com/DataLabTechTV/labstore/backend/internal/config"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/helper"
+	"github.com/DataLabTechTV/labstore/backend/internal/lock"
 	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
 	"github.com/DataLabTechTV/labstore/backend/internal/object"
 	"github.com/DataLabTechTV/labstore/backend/internal/service"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
 	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 )
 
+const AdminPrefix = "/_admin/v1"
+
+// Start serves the S3 and admin APIs until the process is interrupted. The
+// storage backend must be set up beforehand.
 func Start() {
-	os.MkdirAll(config.Env.StorageRoot, 0755)
+	lock.Default.Timeout = config.Env.LockTimeout
+
+	helper.CheckFatal(object.Recover())
+	bucket.ResumeDeletions()
+	storage.ResumeDrains()
+	object.StartScrubber()
+
+	app := NewApp()
 
-	app := fiber.New()
+	port := fmt.Sprintf(":%d", config.Env.Port)
+	logger.Log.Infoln("Starting minimal S3-compatible server on", port)
+
+	// Shut down gracefully, so that the caller can clean up afterwards
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		logger.Log.Infoln("Shutting down")
+		helper.CheckFatal(app.Shutdown())
+	}()
+
+	helper.CheckFatal(app.Listen(port))
+}
+
+// NewApp sets up the routes of the S3 and admin APIs, without starting any
+// background work.
+func NewApp() *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: core.ErrorHandler,
+	})
 
 	app.Use(middleware.AuthMiddleware())
-	app.Use(middleware.IAMMiddleware())
 
-	app.Put("/:bucket", middleware.WithIAM(iam.CreateBucket, bucket.PutBucketHandler))
-	app.Put("/:bucket/:key", middleware.WithIAM(iam.PutObject, object.PutObjectHandler))
+	// Admin API (underscores are not valid in bucket names, so no clashes)
+	adm := app.Group(AdminPrefix)
+	adm.Delete("/buckets/:bucket", middleware.WithAdmin(admin.ForceDeleteBucketHandler))
+	adm.Get("/buckets/:bucket/compression", middleware.WithAdmin(admin.GetBucketCompressionHandler))
+	adm.Put("/buckets/:bucket/compression", middleware.WithAdmin(admin.PutBucketCompressionHandler))
+	adm.Delete("/buckets/:bucket/compression", middleware.WithAdmin(admin.DeleteBucketCompressionHandler))
+	adm.Get("/buckets/:bucket/chunking", middleware.WithAdmin(admin.GetBucketChunkingHandler))
+	adm.Put("/buckets/:bucket/chunking", middleware.WithAdmin(admin.PutBucketChunkingHandler))
+	adm.Delete("/buckets/:bucket/chunking", middleware.WithAdmin(admin.DeleteBucketChunkingHandler))
+	adm.Get("/buckets/:bucket/pool", middleware.WithAdmin(admin.GetBucketPoolHandler))
+	adm.Put("/buckets/:bucket/pool", middleware.WithAdmin(admin.PutBucketPoolHandler))
+	adm.Delete("/buckets/:bucket/pool", middleware.WithAdmin(admin.DeleteBucketPoolHandler))
+	adm.Get("/buckets/:bucket/quota", middleware.WithAdmin(admin.GetBucketQuotaHandler))
+	adm.Put("/buckets/:bucket/quota", middleware.WithAdmin(admin.PutBucketQuotaHandler))
+	adm.Delete("/buckets/:bucket/quota", middleware.WithAdmin(admin.DeleteBucketQuotaHandler))
+	adm.Get("/buckets/:bucket/usage", middleware.WithAdmin(admin.GetBucketUsageHandler))
+	adm.Get("/users/:user/quota", middleware.WithAdmin(admin.GetUserQuotaHandler))
+	adm.Put("/users/:user/quota", middleware.WithAdmin(admin.PutUserQuotaHandler))
+	adm.Delete("/users/:user/quota", middleware.WithAdmin(admin.DeleteUserQuotaHandler))
+	adm.Get("/users/:user/usage", middleware.WithAdmin(admin.GetUserUsageHandler))
+	adm.Get("/usage", middleware.WithAdmin(admin.GetUsageHandler))
+	adm.Get("/blobs", middleware.WithAdmin(admin.GetBlobStatsHandler))
+	adm.Post("/blobs/gc", middleware.WithAdmin(admin.CollectBlobsHandler))
+	adm.Get("/drives", middleware.WithAdmin(admin.GetDrivesHandler))
+	adm.Post("/drives/heal", middleware.WithAdmin(admin.HealDrivesHandler))
+	adm.Get("/pools", middleware.WithAdmin(admin.GetPoolsHandler))
+	adm.Post("/pools/:pool/drain", middleware.WithAdmin(admin.DrainPoolHandler))
+	adm.Delete("/pools/:pool/drain", middleware.WithAdmin(admin.CancelPoolDrainHandler))
+	adm.Get("/scrub", middleware.WithAdmin(admin.GetScrubStatusHandler))
+	adm.Post("/scrub", middleware.WithAdmin(admin.StartScrubHandler))
+	adm.Get("/bucket-deletions", middleware.WithAdmin(admin.ListBucketDeletionsHandler))
+	adm.Get("/bucket-deletions/:id", middleware.WithAdmin(admin.GetBucketDeletionHandler))
+	adm.Post("/kms/keys", middleware.WithAdmin(admin.CreateKeyHandler))
+	adm.Get("/kms/keys", middleware.WithAdmin(admin.ListKeysHandler))
+	adm.Get("/kms/keys/:id", middleware.WithAdmin(admin.GetKeyHandler))
+	adm.Post("/kms/keys/:id/rotate", middleware.WithAdmin(admin.RotateKeyHandler))
+	adm.Post("/kms/keys/:id/disable", middleware.WithAdmin(admin.DisableKeyHandler))
 
-	app.Get("/", middleware.WithIAM(iam.ListAllMyBuckets, service.ListBucketsHandler))
-	app.Get("/:bucket", middleware.WithIAM(iam.ListBucket, bucket.ListObjectsHandler))
-	app.Get("/:bucket/:key", middleware.WithIAM(iam.GetObject, object.GetObjectHandler))
+	app.Put("/:bucket", WithSubresources(
+		middleware.WithIAM(iam.CreateBucket, bucket.PutBucketHandler),
+		Subresource{"versioning", middleware.WithIAM(iam.PutBucketVersioning, bucket.PutBucketVersioningHandler)},
+		Subresource{"object-lock", middleware.WithIAM(iam.PutBucketObjectLockConfiguration, bucket.PutObjectLockConfigurationHandler)},
+		Subresource{"tagging", middleware.WithIAM(iam.PutBucketTagging, bucket.PutBucketTaggingHandler)},
+		Subresource{"encryption", middleware.WithIAM(iam.PutEncryptionConfiguration, bucket.PutBucketEncryptionHandler)},
+	))
+	app.Put("/:bucket/:key", WithSubresources(
+		middleware.WithIAM(iam.PutObject, object.PutObjectHandler),
+		Subresource{"retention", middleware.WithIAM(iam.PutObjectRetention, object.PutObjectRetentionHandler)},
+		Subresource{"legal-hold", middleware.WithIAM(iam.PutObjectLegalHold, object.PutObjectLegalHoldHandler)},
+		Subresource{"tagging", middleware.WithIAM(iam.PutObjectTagging, object.PutObjectTaggingHandler)},
+	))
 
-	app.Delete("/:bucket", middleware.WithIAM(iam.DeleteBucket, bucket.DeleteBucketHandler))
-	app.Delete("/:bucket/:key", middleware.WithIAM(iam.DeleteObject, object.DeleteObjectHandler))
+	app.Post("/:bucket", WithSubresources(
+		nil,
+		Subresource{"delete", object.DeleteObjectsHandler},
+	))
 
+	// HEAD routes go before GET routes, as fiber also registers GET for HEAD
 	app.Head("/:bucket", middleware.WithIAM(iam.ListBucket, bucket.HeadBucketHandler))
 	app.Head("/:bucket/:key", middleware.WithIAM(iam.GetObject, object.HeadObjectHandler))
 
+	app.Get("/", middleware.WithIAM(iam.ListAllMyBuckets, service.ListBucketsHandler))
+	app.Get("/:bucket", WithSubresources(
+		middleware.WithIAM(iam.ListBucket, object.ListObjectsHandler),
+		Subresource{"location", middleware.WithIAM(iam.GetBucketLocation, bucket.GetBucketLocationHandler)},
+		Subresource{"versioning", middleware.WithIAM(iam.GetBucketVersioning, bucket.GetBucketVersioningHandler)},
+		Subresource{"versions", middleware.WithIAM(iam.ListBucketVersions, object.ListObjectVersionsHandler)},
+		Subresource{"object-lock", middleware.WithIAM(iam.GetBucketObjectLockConfiguration, bucket.GetObjectLockConfigurationHandler)},
+		Subresource{"tagging", middleware.WithIAM(iam.GetBucketTagging, bucket.GetBucketTaggingHandler)},
+		Subresource{"encryption", middleware.WithIAM(iam.GetEncryptionConfiguration, bucket.GetBucketEncryptionHandler)},
+	))
+	app.Get("/:bucket/:key", WithSubresources(
+		middleware.WithResponseOverrides(middleware.WithIAM(iam.GetObject, object.GetObjectHandler)),
+		Subresource{"retention", middleware.WithIAM(iam.GetObjectRetention, object.GetObjectRetentionHandler)},
+		Subresource{"legal-hold", middleware.WithIAM(iam.GetObjectLegalHold, object.GetObjectLegalHoldHandler)},
+		Subresource{"tagging", middleware.WithIAM(iam.GetObjectTagging, object.GetObjectTaggingHandler)},
+	))
+
+	// As per S3, deleting bucket tagging or encryption is authorized by the
+	// corresponding put action
+	app.Delete("/:bucket", WithSubresources(
+		middleware.WithIAM(iam.DeleteBucket, bucket.DeleteBucketHandler),
+		Subresource{"tagging", middleware.WithIAM(iam.PutBucketTagging, bucket.DeleteBucketTaggingHandler)},
+		Subresource{"encryption", middleware.WithIAM(iam.PutEncryptionConfiguration, bucket.DeleteBucketEncryptionHandler)},
+	))
+	app.Delete("/:bucket/:key", WithSubresources(
+		middleware.WithIAM(iam.DeleteObject, object.DeleteObjectHandler),
+		Subresource{"tagging", middleware.WithIAM(iam.DeleteObjectTagging, object.DeleteObjectTaggingHandler)},
+	))
+
 	app.Use(func(c *fiber.Ctx) error {
 		core.HandleError(c, core.ErrorNotImplemented())
 		return nil
 	})
 
-	port := fmt.Sprintf(":%d", config.Env.Port)
-	logger.Log.Infoln("Starting minimal S3-compatible server on", port)
-	logger.Log.Fatal(app.Listen(port))
+	return app
 }
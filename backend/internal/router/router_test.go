@@ -0,0 +1,341 @@
+package router
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/index"
+	"github.com/DataLabTechTV/labstore/backend/internal/storage"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestApp serves the API over the given backend, with a fresh index, and
+// allows anonymous requests everything, so that requests need no signing.
+func newTestApp(t *testing.T, backend storage.Backend) *fiber.App {
+	t.Helper()
+
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+
+	config.Load()
+	iam.Load()
+
+	iam.Policies[""] = func(bucket, op string, conditions iam.Conditions) bool {
+		return true
+	}
+
+	ix, err := index.Open(filepath.Join(t.TempDir(), "index.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.Default = backend
+	index.Default = ix
+
+	t.Cleanup(func() {
+		ix.Close()
+		backend.Close()
+	})
+
+	return NewApp()
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func send(t *testing.T, app *fiber.App, method, target string, body []byte, header map[string]string) *testResponse {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, target, err)
+		return &testResponse{}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, target, err)
+	}
+
+	return &testResponse{status: resp.StatusCode, header: resp.Header, body: data}
+}
+
+// contentMD5 is the Content-MD5 header of a body.
+func contentMD5(body string) string {
+	sum := md5.Sum([]byte(body))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// expect fails the test unless the response has one of the given statuses.
+func (r *testResponse) expect(t *testing.T, what string, statuses ...int) bool {
+	for _, status := range statuses {
+		if r.status == status {
+			return true
+		}
+	}
+
+	t.Errorf("%s: status %d, want one of %v: %s", what, r.status, statuses, r.body)
+
+	return false
+}
+
+// content is uniform, so that a read mixing two versions is caught, and sized
+// by its fill byte, so that a truncated read is caught too. Small sizes are
+// stored inline.
+func content(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 100+int(fill-'a')*1500)
+}
+
+// checkComplete fails the test unless a GET returned a whole object, as
+// uploaded.
+func checkComplete(t *testing.T, what string, r *testResponse) {
+	if len(r.body) == 0 {
+		t.Errorf("%s: empty object", what)
+		return
+	}
+
+	if want := content(r.body[0]); !bytes.Equal(r.body, want) {
+		t.Errorf("%s: torn object of %d bytes, starting with %q", what, len(r.body), r.body[0])
+		return
+	}
+
+	sum := md5.Sum(r.body)
+
+	if etag := strings.Trim(r.header.Get("ETag"), `"`); etag != hex.EncodeToString(sum[:]) {
+		t.Errorf("%s: ETag %s does not match the data", what, etag)
+	}
+}
+
+func TestConcurrentObjectRequests(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) storage.Backend
+	}{
+		{"memory", func(t *testing.T) storage.Backend {
+			return storage.NewMemory(0)
+		}},
+		{"fs", func(t *testing.T) storage.Backend {
+			b, err := storage.NewFS(t.TempDir(), storage.DurabilityNone, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return b
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			testConcurrentObjectRequests(t, newTestApp(t, backend.new(t)))
+		})
+	}
+}
+
+// testConcurrentObjectRequests runs overlapping PUT, GET, DELETE, CopyObject
+// and DeleteBucket requests on one key, while the bucket is deleted and
+// created again, and checks that every GET returns a whole object.
+func testConcurrentObjectRequests(t *testing.T, app *fiber.App) {
+	const (
+		bucket     = "race-bucket"
+		key        = "/" + bucket + "/key"
+		copyKey    = "/" + bucket + "/copy"
+		iterations = 40
+	)
+
+	send(t, app, "PUT", "/race-source", nil, nil).expect(t, "create source bucket", 200)
+	send(t, app, "PUT", "/race-source/source", content('z'), nil).expect(t, "put source", 200)
+	send(t, app, "PUT", "/"+bucket, nil, nil).expect(t, "create bucket", 200)
+
+	var wg sync.WaitGroup
+
+	run := func(workers int, fn func(worker, i int)) {
+		for w := range workers {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for i := range iterations {
+					fn(w, i)
+				}
+			}()
+		}
+	}
+
+	run(4, func(w, i int) {
+		fill := byte('a' + (w*iterations+i)%25)
+		send(t, app, "PUT", key, content(fill), nil).expect(t, "put", 200, 404)
+	})
+
+	run(4, func(w, i int) {
+		r := send(t, app, "GET", key, nil, nil)
+
+		if r.expect(t, "get", 200, 404) && r.status == 200 {
+			checkComplete(t, "get", r)
+		}
+	})
+
+	run(1, func(w, i int) {
+		send(t, app, "DELETE", key, nil, nil).expect(t, "delete", 204, 404)
+	})
+
+	run(1, func(w, i int) {
+		header := map[string]string{"X-Amz-Copy-Source": "/race-source/source"}
+		send(t, app, "PUT", key, nil, header).expect(t, "copy onto key", 200, 404)
+
+		header = map[string]string{"X-Amz-Copy-Source": key}
+		send(t, app, "PUT", copyKey, nil, header).expect(t, "copy from key", 200, 404)
+
+		r := send(t, app, "GET", copyKey, nil, nil)
+
+		if r.expect(t, "get copy", 200, 404) && r.status == 200 {
+			checkComplete(t, "get copy", r)
+		}
+	})
+
+	run(1, func(w, i int) {
+		send(t, app, "DELETE", copyKey, nil, nil).expect(t, "delete copy", 204, 404)
+		send(t, app, "DELETE", key, nil, nil).expect(t, "delete before bucket", 204, 404)
+
+		r := send(t, app, "DELETE", "/"+bucket, nil, nil)
+
+		if r.expect(t, "delete bucket", 204, 404, 409) && r.status == 204 {
+			send(t, app, "PUT", "/"+bucket, nil, nil).expect(t, "create bucket", 200, 409)
+		}
+	})
+
+	wg.Wait()
+
+	// Whatever won, the key is left either whole or missing
+	r := send(t, app, "GET", key, nil, nil)
+
+	if r.expect(t, "final get", 200, 404) && r.status == 200 {
+		checkComplete(t, "final get", r)
+	}
+
+}
+
+func TestListObjectsHeaders(t *testing.T) {
+	app := newTestApp(t, storage.NewMemory(0))
+
+	send(t, app, "PUT", "/list-bucket", nil, nil).expect(t, "create bucket", 200)
+
+	for _, target := range []string{"/list-bucket", "/list-bucket?list-type=2"} {
+		r := send(t, app, "GET", target, nil, nil)
+		r.expect(t, target, 200)
+
+		if server := r.header.Get("Server"); server != "LabStore" {
+			t.Errorf("%s: Server = %q, want LabStore", target, server)
+		}
+
+		if r.header.Get("X-Amz-Request-Id") == "" {
+			t.Errorf("%s: missing X-Amz-Request-Id", target)
+		}
+	}
+}
+
+// TestPathTraversal checks that request values never reach storage paths
+// outside of the bucket they are sent to.
+func TestPathTraversal(t *testing.T) {
+	app := newTestApp(t, storage.NewMemory(0))
+
+	send(t, app, "PUT", "/mine", nil, nil).expect(t, "create mine", 200)
+	send(t, app, "PUT", "/mine/k", []byte("mine"), nil).expect(t, "put mine", 200)
+	send(t, app, "PUT", "/secret", nil, nil).expect(t, "create secret", 200)
+	send(t, app, "PUT", "/secret/data.txt", []byte("secret"), nil).expect(t, "put secret", 200)
+
+	versionID := "..%2F..%2F..%2Fsecret%2Fobjects%2Fdata.txt"
+
+	requests := []struct {
+		method string
+		target string
+		header map[string]string
+	}{
+		{"GET", "/mine/k?versionId=" + versionID, nil},
+		{"HEAD", "/mine/k?versionId=" + versionID, nil},
+		{"DELETE", "/mine/k?versionId=" + versionID, nil},
+		{"GET", "/mine/k?tagging&versionId=" + versionID, nil},
+		{"DELETE", "/mine/k?tagging&versionId=" + versionID, nil},
+		{"GET", "/mine/k?retention&versionId=" + versionID, nil},
+		{"GET", "/mine/k?legal-hold&versionId=" + versionID, nil},
+		{"PUT", "/mine/copy", map[string]string{"X-Amz-Copy-Source": "/mine/k?versionId=" + versionID}},
+		{"PUT", "/mine/copy", map[string]string{"X-Amz-Copy-Source": "/mine/..%2Fsecret%2Fdata.txt"}},
+		{"PUT", "/mine/copy", map[string]string{"X-Amz-Copy-Source": "/mine/..%2F.labstore.sys%2Fbuckets%2Fsecret%2Fbucket.json"}},
+	}
+
+	for _, r := range requests {
+		send(t, app, r.method, r.target, nil, r.header).expect(t, r.method+" "+r.target, 400)
+	}
+
+	// Keys in the request path are not unescaped, so these stay in mine
+	send(t, app, "PUT", "/mine/..%2Fsecret%2Fdata.txt", []byte("mine"), nil).expect(t, "put escaped key", 200)
+	send(t, app, "DELETE", "/mine/..%2Fsecret%2Fdata.txt", nil, nil).expect(t, "delete escaped key", 204)
+
+	// Batch deletes report invalid keys, and delete the rest
+	batch := `<Delete><Object><Key>../secret/data.txt</Key></Object><Object><Key>k</Key></Object></Delete>`
+	r := send(t, app, "POST", "/mine?delete", []byte(batch), map[string]string{"Content-MD5": contentMD5(batch)})
+
+	if r.expect(t, "delete objects", 200) {
+		body := string(r.body)
+
+		if !strings.Contains(body, "<Error><Key>../secret/data.txt</Key><Code>InvalidArgument</Code>") {
+			t.Errorf("delete objects: missing InvalidArgument for the traversal key: %s", body)
+		}
+
+		if !strings.Contains(body, "<Deleted><Key>k</Key>") {
+			t.Errorf("delete objects: k not deleted: %s", body)
+		}
+	}
+
+	r = send(t, app, "GET", "/secret/data.txt", nil, nil)
+
+	if r.expect(t, "get secret", 200) && string(r.body) != "secret" {
+		t.Errorf("secret object changed to %q", r.body)
+	}
+}
+
+// TestTaggingConditions checks that tag replacements are authorized against
+// the tags of the version they replace.
+func TestTaggingConditions(t *testing.T) {
+	app := newTestApp(t, storage.NewMemory(0))
+
+	// Tags cannot be changed once an object is tagged as locked
+	iam.Policies[""] = func(bucket, op string, conditions iam.Conditions) bool {
+		return op != string(iam.PutObjectTagging) || conditions[iam.ExistingObjectTag+"locked"] != "yes"
+	}
+
+	tagging := func(key, value string) []byte {
+		return []byte("<Tagging><TagSet><Tag><Key>" + key + "</Key><Value>" + value + "</Value></Tag></TagSet></Tagging>")
+	}
+
+	send(t, app, "PUT", "/tagged", nil, nil).expect(t, "create bucket", 200)
+	send(t, app, "PUT", "/tagged/k", []byte("data"), nil).expect(t, "put object", 200)
+	send(t, app, "PUT", "/tagged/k?tagging", tagging("locked", "yes"), nil).expect(t, "lock tags", 200)
+	send(t, app, "PUT", "/tagged/k?tagging", tagging("locked", "no"), nil).expect(t, "unlock tags", 403)
+
+	r := send(t, app, "GET", "/tagged/k?tagging", nil, nil)
+
+	if r.expect(t, "get tags", 200) && !strings.Contains(string(r.body), "<Value>yes</Value>") {
+		t.Errorf("tags changed to %s", r.body)
+	}
+}
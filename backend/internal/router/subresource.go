@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Subresource routes requests to a handler based on the presence of a query
+// parameter (e.g., ?delete, ?versioning), as S3 uses the same method and path
+// for multiple operations.
+type Subresource struct {
+	Query   string
+	Handler fiber.Handler
+}
+
+// WithSubresources dispatches to the first matching subresource handler, or to
+// the default handler, which can be nil when there is no plain operation.
+func WithSubresources(handler fiber.Handler, subresources ...Subresource) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		args := c.Request().URI().QueryArgs()
+
+		for _, sub := range subresources {
+			if args.Has(sub.Query) {
+				return sub.Handler(c)
+			}
+		}
+
+		if handler == nil {
+			core.HandleError(c, core.ErrorNotImplemented())
+			return nil
+		}
+
+		return handler(c)
+	}
+}
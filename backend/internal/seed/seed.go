@@ -0,0 +1,237 @@
+// Package seed loads buckets, objects, users and policies into a server at
+// startup, from a fixtures directory or a YAML manifest.
+package seed
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/object"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type Manifest struct {
+	Buckets  []Bucket      `yaml:"buckets"`
+	Users    []User        `yaml:"users"`
+	Policies []*iam.Policy `yaml:"policies"`
+}
+
+type Bucket struct {
+	Name       string            `yaml:"name"`
+	Versioning string            `yaml:"versioning"`
+	ObjectLock bool              `yaml:"objectLock"`
+	Tags       map[string]string `yaml:"tags"`
+	Objects    []Object          `yaml:"objects"`
+}
+
+// Object data is either given inline, as content, or read from a file,
+// relative to the manifest.
+type Object struct {
+	Key         string            `yaml:"key"`
+	Content     string            `yaml:"content"`
+	File        string            `yaml:"file"`
+	ContentType string            `yaml:"contentType"`
+	Metadata    map[string]string `yaml:"metadata"`
+	Tags        map[string]string `yaml:"tags"`
+}
+
+type User struct {
+	AccessKey string   `yaml:"accessKey"`
+	SecretKey string   `yaml:"secretKey"`
+	Policies  []string `yaml:"policies"`
+}
+
+// Load seeds the server from a path, which is either a YAML manifest, or a
+// fixtures directory, where each subdirectory is a bucket, and each file
+// under it is an object, keyed by its relative path.
+func Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not read seed: %w", err)
+	}
+
+	var manifest *Manifest
+
+	if info.IsDir() {
+		manifest, err = manifestFromDir(path)
+	} else {
+		manifest, err = manifestFromFile(path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return Apply(manifest)
+}
+
+func manifestFromFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read seed manifest: %w", err)
+	}
+
+	var manifest Manifest
+
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("could not decode seed manifest: %w", err)
+	}
+
+	// Object files are relative to the manifest
+	base := filepath.Dir(path)
+
+	for _, b := range manifest.Buckets {
+		for i := range b.Objects {
+			if b.Objects[i].File != "" && !filepath.IsAbs(b.Objects[i].File) {
+				b.Objects[i].File = filepath.Join(base, b.Objects[i].File)
+			}
+		}
+	}
+
+	return &manifest, nil
+}
+
+func manifestFromDir(dir string) (*Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read fixtures: %w", err)
+	}
+
+	manifest := &Manifest{}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			logger.Log.Warnf("Skipping fixture %s, which is not in a bucket directory", e.Name())
+			continue
+		}
+
+		b := Bucket{Name: e.Name()}
+		root := filepath.Join(dir, e.Name())
+
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+
+			b.Objects = append(b.Objects, Object{Key: filepath.ToSlash(rel), File: p})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read fixtures: %w", err)
+		}
+
+		manifest.Buckets = append(manifest.Buckets, b)
+	}
+
+	return manifest, nil
+}
+
+// Apply creates everything in the manifest, through the same code paths as
+// the S3 API, so seeded data is stored exactly as uploaded data would be.
+func Apply(manifest *Manifest) error {
+	policies := map[string]*iam.Policy{}
+
+	for _, p := range manifest.Policies {
+		policies[p.Name] = p
+	}
+
+	for _, u := range manifest.Users {
+		userPolicies := make([]*iam.Policy, 0, len(u.Policies))
+
+		for _, name := range u.Policies {
+			p, ok := policies[name]
+			if !ok {
+				return fmt.Errorf("user %s has unknown policy %s", u.AccessKey, name)
+			}
+
+			userPolicies = append(userPolicies, p)
+		}
+
+		iam.AddUser(u.AccessKey, u.SecretKey, userPolicies)
+	}
+
+	for _, b := range manifest.Buckets {
+		if err := applyBucket(&b); err != nil {
+			return fmt.Errorf("could not seed bucket %s: %w", b.Name, err)
+		}
+	}
+
+	logger.Log.Infof(
+		"Seeded %d buckets, %d users and %d policies",
+		len(manifest.Buckets), len(manifest.Users), len(manifest.Policies),
+	)
+
+	return nil
+}
+
+func applyBucket(b *Bucket) error {
+	opts := bucket.CreateBucketOptions{
+		Owner:             config.Env.AdminAccessKey,
+		Region:            config.Env.Region,
+		ObjectLockEnabled: b.ObjectLock,
+	}
+
+	if err := bucket.CreateBucket(b.Name, opts); err != nil {
+		return err
+	}
+
+	if b.Versioning != "" && !b.ObjectLock {
+		conf := &bucket.VersioningConfiguration{Status: b.Versioning}
+
+		if err := bucket.PutBucketVersioning(b.Name, conf); err != nil {
+			return err
+		}
+	}
+
+	if len(b.Tags) > 0 {
+		if err := bucket.PutBucketTagging(b.Name, b.Tags); err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range b.Objects {
+		if err := applyObject(b.Name, &obj); err != nil {
+			return fmt.Errorf("could not seed object %s: %w", obj.Key, err)
+		}
+	}
+
+	return nil
+}
+
+func applyObject(bucketName string, obj *Object) error {
+	data := []byte(obj.Content)
+
+	if obj.File != "" {
+		var err error
+
+		if data, err = os.ReadFile(obj.File); err != nil {
+			return err
+		}
+	}
+
+	meta := object.NewMetadata(obj.Key)
+	meta.Tags = obj.Tags
+
+	if obj.ContentType != "" {
+		meta.Headers[fiber.HeaderContentType] = obj.ContentType
+	}
+
+	for k, v := range obj.Metadata {
+		meta.UserMetadata[k] = v
+	}
+
+	return object.PutObject(context.Background(), bucketName, obj.Key, data, meta, nil)
+}
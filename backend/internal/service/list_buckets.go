@@ -1,20 +1,26 @@
 package service
 
 import (
+	"encoding/base64"
 	"encoding/xml"
-	"os"
-	"time"
+	"strconv"
+	"strings"
 
-	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/bucket"
 	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/middleware"
+	"github.com/DataLabTechTV/labstore/backend/pkg/iam"
 	"github.com/gofiber/fiber/v2"
 )
 
+const maxBuckets = 10000
+
 // !FIXME: move types to a proper location
 
 type Bucket struct {
 	Name         string
 	CreationDate string
+	BucketRegion string
 }
 
 type ListAllMyBucketsResult struct {
@@ -26,33 +32,106 @@ type ListAllMyBucketsResult struct {
 	Buckets struct {
 		Bucket []Bucket
 	}
+	ContinuationToken string `xml:",omitempty"`
+	Prefix            string `xml:",omitempty"`
+}
+
+type ListBucketsOptions struct {
+	MaxBuckets        int
+	ContinuationToken string
+	Prefix            string
+	BucketRegion      string
 }
 
-func ListBuckets(accessKey string) (*ListAllMyBucketsResult, error) {
-	entries, err := os.ReadDir(config.Env.StorageRoot)
+// ListBuckets lists buckets the caller is allowed to list, in lexicographical
+// order. The continuation token encodes the last bucket name returned.
+func ListBuckets(accessKey string, opts ListBucketsOptions) (*ListAllMyBucketsResult, error) {
+	records, err := bucket.ListRecords()
 	if err != nil {
 		return nil, core.ErrorInternalError("Failed to list buckets")
 	}
 
-	res := ListAllMyBucketsResult{}
+	var startAfter string
+
+	if opts.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(opts.ContinuationToken)
+		if err != nil {
+			return nil, core.ErrorInvalidArgument("The continuation token provided is incorrect")
+		}
+
+		startAfter = string(token)
+	}
+
+	res := ListAllMyBucketsResult{Prefix: opts.Prefix}
 	res.Owner.ID = accessKey
 	res.Owner.DisplayName = accessKey
 
-	for _, e := range entries {
-		if e.IsDir() {
-			b := Bucket{Name: e.Name(), CreationDate: time.Now().Format(time.RFC3339)}
-			res.Buckets.Bucket = append(res.Buckets.Bucket, b)
+	for _, record := range records {
+		if record.Name <= startAfter {
+			continue
+		}
+
+		if !strings.HasPrefix(record.Name, opts.Prefix) {
+			continue
+		}
+
+		if opts.BucketRegion != "" && record.Region != opts.BucketRegion {
+			continue
+		}
+
+		if !iam.CheckPolicy(accessKey, record.Name, string(iam.ListBucket), nil) {
+			continue
+		}
+
+		if len(res.Buckets.Bucket) == opts.MaxBuckets {
+			last := res.Buckets.Bucket[len(res.Buckets.Bucket)-1].Name
+			res.ContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
 		}
+
+		b := Bucket{
+			Name:         record.Name,
+			CreationDate: core.FormatTimestamp(record.CreationDate),
+			BucketRegion: record.Region,
+		}
+
+		res.Buckets.Bucket = append(res.Buckets.Bucket, b)
 	}
 
 	return &res, nil
 }
 
+func parseListBucketsOptions(c *fiber.Ctx) (ListBucketsOptions, error) {
+	opts := ListBucketsOptions{
+		MaxBuckets:        maxBuckets,
+		ContinuationToken: c.Query("continuation-token"),
+		Prefix:            c.Query("prefix"),
+		BucketRegion:      c.Query("bucket-region"),
+	}
+
+	if value := c.Query("max-buckets"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxBuckets {
+			return opts, core.ErrorInvalidArgument("max-buckets must be an integer between 1 and 10000")
+		}
+
+		opts.MaxBuckets = n
+	}
+
+	return opts, nil
+}
+
 // ListBuckets: GET /
 func ListBucketsHandler(c *fiber.Ctx) error {
-	accessKey := c.Params("accessKey")
+	accessKey := middleware.AccessKey(c)
+
+	opts, err := parseListBucketsOptions(c)
+	if err != nil {
+		core.HandleError(c, err)
+		return err
+	}
 
-	res, err := ListBuckets(accessKey)
+	res, err := ListBuckets(accessKey, opts)
 	if err != nil {
 		core.HandleError(c, err)
 		return err
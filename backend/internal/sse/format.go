@@ -0,0 +1,201 @@
+package sse
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted objects are stored as a sequence of AES-256-GCM sealed chunks of
+// ChunkSize plaintext bytes (the last one can be shorter), each followed by its
+// authentication tag. Every object has its own data key, so the nonce is just
+// the chunk index, and the last chunk is flagged in the additional data, which
+// detects reordered, dropped or truncated chunks. As chunks have a fixed size,
+// any plaintext offset maps directly to a chunk, which makes range reads
+// possible without decrypting from the start.
+
+const (
+	ChunkSize = 64 << 10
+	tagSize   = 16
+)
+
+var errCorrupted = errors.New("encrypted object is corrupted or the key is wrong")
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[aead.NonceSize()-8:], uint64(index))
+	return nonce
+}
+
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+// EncryptedSize returns the stored size for a plaintext size. Empty objects
+// still store a single empty chunk, so that truncation is detected.
+func EncryptedSize(size int64) int64 {
+	chunks := max(1, (size+ChunkSize-1)/ChunkSize)
+	return size + chunks*tagSize
+}
+
+type writer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index int64
+}
+
+// NewWriter encrypts everything written to it into w. Close must be called to
+// seal the last chunk, but it does not close w.
+func NewWriter(w io.Writer, dataKey []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as it could be
+		// the last one
+		if len(w.buf) == ChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	return w.seal(true)
+}
+
+func (w *writer) seal(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.aead, w.index), w.buf, chunkAD(final))
+
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	w.index++
+
+	return nil
+}
+
+type reader struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	size   int64
+	offset int64
+	chunk  []byte
+	index  int64
+}
+
+// NewReader decrypts an object of the given plaintext size. Seeking only
+// decrypts the chunk holding the new offset. When r is an io.Closer, it is
+// closed along with the reader.
+func NewReader(r io.ReaderAt, size int64, dataKey []byte) (io.ReadSeekCloser, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{r: r, aead: aead, size: size, index: -1}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / ChunkSize
+
+	if index != r.index {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk[r.offset-index*ChunkSize:])
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *reader) load(index int64) error {
+	start := index * ChunkSize
+	length := min(ChunkSize, r.size-start)
+	final := start+length == r.size
+
+	sealed := make([]byte, length+tagSize)
+
+	if _, err := r.r.ReadAt(sealed, start+index*tagSize); err != nil {
+		return fmt.Errorf("could not read encrypted object: %w", err)
+	}
+
+	chunk, err := r.aead.Open(sealed[:0], chunkNonce(r.aead, index), sealed, chunkAD(final))
+	if err != nil {
+		return errCorrupted
+	}
+
+	r.chunk = chunk
+	r.index = index
+
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if closer, ok := r.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package sse
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/kms"
+)
+
+// Info is the encryption state stored with each encrypted object version. The
+// data key is only ever stored wrapped by the master key (SSE-S3), by the
+// customer key (SSE-C), or by a KMS key version (SSE-KMS).
+type Info struct {
+	Algorithm      string            `json:"algorithm"`
+	CustomerKeyMD5 string            `json:"customerKeyMD5,omitempty"`
+	KMSKeyID       string            `json:"kmsKeyId,omitempty"`
+	KMSKeyVersion  int               `json:"kmsKeyVersion,omitempty"`
+	Context        map[string]string `json:"context,omitempty"`
+	WrappedKey     []byte            `json:"wrappedKey"`
+}
+
+func (i *Info) IsCustomer() bool {
+	return i.CustomerKeyMD5 != ""
+}
+
+func (i *Info) IsKMS() bool {
+	return i.KMSKeyID != ""
+}
+
+// ObjectARN identifies the object that a KMS data key is bound to.
+func ObjectARN(bucket, key string) string {
+	return "arn:aws:s3:::" + bucket + "/" + key
+}
+
+// encryptionContext adds the object ARN to the context requested by the
+// client, so that a data key cannot be used for any other object.
+func encryptionContext(arn string, context map[string]string) map[string]string {
+	res := map[string]string{"aws:s3:arn": arn}
+
+	for k, v := range context {
+		res[k] = v
+	}
+
+	return res
+}
+
+// masterKey returns the server's root key, used for SSE-S3.
+func masterKey() ([]byte, error) {
+	key, err := kms.RootKey()
+	if errors.Is(err, kms.ErrNoRootKey) {
+		return nil, ErrorNotConfigured()
+	}
+
+	return key, err
+}
+
+// CheckConfigured fails when SSE-S3 cannot be used, so that it is not enabled
+// as a bucket default that would fail every upload.
+func CheckConfigured() error {
+	_, err := masterKey()
+	return err
+}
+
+// CheckRequest fails when the encryption requested for a new object cannot be
+// used, so that writes only fail on I/O errors.
+func CheckRequest(req *Request) error {
+	switch {
+	case req.CustomerKey != nil:
+		return nil
+	case req.Algorithm == AlgorithmKMS:
+		if err := CheckConfigured(); err != nil {
+			return err
+		}
+
+		return kms.CheckKey(req.KMSKeyID)
+	default:
+		return CheckConfigured()
+	}
+}
+
+// NewDataKey generates the data key for a new version of the object
+// identified by arn, returning it along with the encryption state to store.
+func NewDataKey(req *Request, arn string) (*Info, []byte, error) {
+	if req.Algorithm == AlgorithmKMS {
+		dataKey, wrapped, err := kms.GenerateDataKey(req.KMSKeyID, encryptionContext(arn, req.Context))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		info := &Info{
+			Algorithm:     AlgorithmKMS,
+			KMSKeyID:      wrapped.KeyID,
+			KMSKeyVersion: wrapped.KeyVersion,
+			Context:       req.Context,
+			WrappedKey:    wrapped.Ciphertext,
+		}
+
+		return info, dataKey, nil
+	}
+
+	kek := req.CustomerKey
+
+	if kek == nil {
+		var err error
+
+		if kek, err = masterKey(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dataKey, err := kms.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := kms.Seal(kek, dataKey, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not wrap data key: %w", err)
+	}
+
+	info := &Info{
+		Algorithm:      req.Algorithm,
+		CustomerKeyMD5: req.CustomerKeyMD5,
+		WrappedKey:     wrapped,
+	}
+
+	return info, dataKey, nil
+}
+
+// DataKey unwraps the data key of a version of the object identified by arn,
+// given the request's customer key, which is required for SSE-C and rejected
+// otherwise. Unencrypted objects have no data key.
+func DataKey(info *Info, req *Request, arn string) ([]byte, error) {
+	if info == nil {
+		if req != nil {
+			return nil, ErrorNotApplicable()
+		}
+
+		return nil, nil
+	}
+
+	if !info.IsCustomer() {
+		if req != nil {
+			return nil, ErrorNotApplicable()
+		}
+
+		if info.IsKMS() {
+			wrapped := &kms.DataKey{
+				KeyID:      info.KMSKeyID,
+				KeyVersion: info.KMSKeyVersion,
+				Ciphertext: info.WrappedKey,
+			}
+
+			return kms.Decrypt(wrapped, encryptionContext(arn, info.Context))
+		}
+
+		kek, err := masterKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return kms.Open(kek, info.WrappedKey, nil)
+	}
+
+	if req == nil {
+		return nil, ErrorCustomerKeyRequired()
+	}
+
+	if req.CustomerKeyMD5 != info.CustomerKeyMD5 {
+		return nil, ErrorCustomerKeyMismatch()
+	}
+
+	dataKey, err := kms.Open(req.CustomerKey, info.WrappedKey, nil)
+	if err != nil {
+		return nil, ErrorCustomerKeyMismatch()
+	}
+
+	return dataKey, nil
+}
+
+// encodeContext encodes an encryption context as in the
+// x-amz-server-side-encryption-context header.
+func encodeContext(context map[string]string) string {
+	data, _ := json.Marshal(context)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func decodeContext(value string) (map[string]string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var context map[string]string
+
+	if err := json.Unmarshal(data, &context); err != nil {
+		return nil, err
+	}
+
+	return context, nil
+}
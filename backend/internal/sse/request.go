@@ -0,0 +1,162 @@
+package sse
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/internal/kms"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	AlgorithmAES256 = "AES256"
+	AlgorithmKMS    = "aws:kms"
+
+	KMSKeyIDHeader = "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"
+	ContextHeader  = "X-Amz-Server-Side-Encryption-Context"
+
+	CustomerHeaderPrefix           = "X-Amz-Server-Side-Encryption-Customer-"
+	CopySourceCustomerHeaderPrefix = "X-Amz-Copy-Source-Server-Side-Encryption-Customer-"
+)
+
+func ErrorNotConfigured() *core.S3Error {
+	return core.ErrorInvalidRequest("Server-side encryption is not configured on this server")
+}
+
+func ErrorNotApplicable() *core.S3Error {
+	return core.ErrorInvalidRequest("The encryption parameters are not applicable to this object.")
+}
+
+func ErrorCustomerKeyRequired() *core.S3Error {
+	return core.ErrorInvalidRequest(
+		"The object was stored using a form of Server Side Encryption. " +
+			"The correct parameters must be provided to retrieve the object.",
+	)
+}
+
+func ErrorCustomerKeyMismatch() *core.S3Error {
+	return &core.S3Error{
+		Code:       "AccessDenied",
+		Message:    "The provided customer key does not match the key used to encrypt the object",
+		StatusCode: fiber.StatusForbidden,
+	}
+}
+
+// Request holds the encryption parameters of a request. The customer key is
+// only set for SSE-C, and it is never stored. The KMS key ID and encryption
+// context are only set for SSE-KMS.
+type Request struct {
+	Algorithm      string
+	CustomerKey    []byte
+	CustomerKeyMD5 string
+	KMSKeyID       string
+	Context        map[string]string
+}
+
+// ParseRequest reads the encryption requested for a new object, either SSE-S3
+// or SSE-KMS (x-amz-server-side-encryption), or SSE-C. It returns nil when no
+// encryption was requested.
+func ParseRequest(c *fiber.Ctx) (*Request, error) {
+	customer, err := ParseCustomerKey(c, CustomerHeaderPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := c.Get("X-Amz-Server-Side-Encryption")
+
+	if customer != nil {
+		if algorithm != "" {
+			return nil, core.ErrorInvalidArgument(
+				"Server Side Encryption with Customer provided key is incompatible with the encryption method specified",
+			)
+		}
+
+		return customer, nil
+	}
+
+	if algorithm != AlgorithmKMS && (c.Get(KMSKeyIDHeader) != "" || c.Get(ContextHeader) != "") {
+		return nil, core.ErrorInvalidArgument(
+			"Server Side Encryption with AWS KMS managed key requires HTTP header x-amz-server-side-encryption : aws:kms",
+		)
+	}
+
+	switch algorithm {
+	case "":
+		return nil, nil
+	case AlgorithmAES256:
+		return &Request{Algorithm: AlgorithmAES256}, nil
+	case AlgorithmKMS:
+		req := &Request{Algorithm: AlgorithmKMS, KMSKeyID: c.Get(KMSKeyIDHeader)}
+
+		if value := c.Get(ContextHeader); value != "" {
+			if req.Context, err = decodeContext(value); err != nil {
+				return nil, core.ErrorInvalidArgument("The encryption context must be a base64 encoded JSON object of strings")
+			}
+		}
+
+		return req, nil
+	default:
+		return nil, core.ErrorInvalidArgument("The encryption method specified is not supported")
+	}
+}
+
+// ParseCustomerKey reads the SSE-C headers under the given prefix (regular or
+// copy source), validating the key against its MD5. It returns nil when no
+// customer key was provided.
+func ParseCustomerKey(c *fiber.Ctx, prefix string) (*Request, error) {
+	algorithm := c.Get(prefix + "Algorithm")
+	encodedKey := c.Get(prefix + "Key")
+	keyMD5 := c.Get(prefix + "Key-MD5")
+
+	if algorithm == "" && encodedKey == "" && keyMD5 == "" {
+		return nil, nil
+	}
+
+	if algorithm != AlgorithmAES256 {
+		return nil, core.ErrorInvalidArgument("The encryption algorithm specified is not supported, it must be AES256")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != kms.KeySize {
+		return nil, core.ErrorInvalidArgument("The secret key was invalid for the specified algorithm.")
+	}
+
+	sum := md5.Sum(key)
+	expectedMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	if keyMD5 != expectedMD5 {
+		return nil, core.ErrorInvalidArgument("The calculated MD5 hash of the key did not match the hash that was provided.")
+	}
+
+	req := &Request{
+		Algorithm:      AlgorithmAES256,
+		CustomerKey:    key,
+		CustomerKeyMD5: keyMD5,
+	}
+
+	return req, nil
+}
+
+// SetResponseHeaders reports how an object is encrypted.
+func (i *Info) SetResponseHeaders(c *fiber.Ctx) {
+	if i == nil {
+		return
+	}
+
+	if i.IsCustomer() {
+		c.Set(CustomerHeaderPrefix+"Algorithm", i.Algorithm)
+		c.Set(CustomerHeaderPrefix+"Key-MD5", i.CustomerKeyMD5)
+		return
+	}
+
+	c.Set("X-Amz-Server-Side-Encryption", i.Algorithm)
+
+	if i.IsKMS() {
+		c.Set(KMSKeyIDHeader, i.KMSKeyID)
+
+		if len(i.Context) > 0 {
+			c.Set(ContextHeader, encodeContext(i.Context))
+		}
+	}
+}
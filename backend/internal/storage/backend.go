@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+)
+
+// Backend stores the files that make up buckets, objects and their metadata.
+// Names are slash-separated paths relative to the storage root, and directories
+// are implied by the files under them, except for buckets, which are explicitly
+// created. Errors for missing names satisfy errors.Is(err, fs.ErrNotExist).
+type Backend interface {
+	// Put stores the data read from r under name, replacing any existing
+	// file, and returns the number of bytes written.
+	Put(ctx context.Context, name string, r io.Reader) (int64, error)
+
+	// Get opens a file for reading.
+	Get(ctx context.Context, name string) (File, error)
+
+	Stat(ctx context.Context, name string) (*Info, error)
+
+	// Delete removes a file or an empty directory.
+	Delete(ctx context.Context, name string) error
+
+	// DeleteAll removes a file or a directory with everything under it. Missing
+	// names are not an error.
+	DeleteAll(ctx context.Context, name string) error
+
+	// List returns the direct children of a directory, sorted by name.
+	List(ctx context.Context, dir string) ([]*Info, error)
+
+	// Walk calls fn for every file under dir, in lexical order, with its full
+	// name. Walking a missing directory is not an error.
+	Walk(ctx context.Context, dir string, fn WalkFunc) error
+
+	// Mkdir creates a directory, along with any missing parents.
+	Mkdir(ctx context.Context, name string) error
+
+	// Rename moves a file or directory, creating the parents of newName.
+	Rename(ctx context.Context, oldName, newName string) error
+
+	// Copy duplicates a file, as cheaply as the driver allows.
+	Copy(ctx context.Context, src, dst string) error
+
+	// Close releases the backend when the server shuts down.
+	Close() error
+}
+
+// File is an open file, which supports random access for range reads.
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// Info describes a file or directory, where Name is its full name.
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+type WalkFunc func(name string, info *Info) error
+
+// Default is the backend used by all handlers, set up by Init.
+var Default Backend
+
+// Init sets up the default backend, as configured by LS_STORAGE_DRIVER, which
+// is either fs, for the storage root, erasure, for a set of drives, pools, for
+// several storage roots, or memory, for ephemeral servers.
+func Init() error {
+	switch config.Env.StorageDriver {
+	case "fs":
+		durability, err := ParseDurability(config.Env.Durability)
+		if err != nil {
+			return err
+		}
+
+		backend, err := NewFS(config.Env.StorageRoot, durability, config.Env.SyncInterval)
+		if err != nil {
+			return err
+		}
+
+		Default = backend
+	case "erasure":
+		durability, err := ParseDurability(config.Env.Durability)
+		if err != nil {
+			return err
+		}
+
+		backend, err := NewErasure(config.Env.ErasureDrives, config.Env.ErasureParity, durability, config.Env.SyncInterval)
+		if err != nil {
+			return err
+		}
+
+		Default = backend
+	case "pools":
+		durability, err := ParseDurability(config.Env.Durability)
+		if err != nil {
+			return err
+		}
+
+		statePath := filepath.Join(config.Env.StorageRoot, filepath.FromSlash(config.SystemPath("pools.json")))
+
+		backend, err := NewPools(config.Env.Pools, config.Env.PoolReserve, statePath, durability, config.Env.SyncInterval)
+		if err != nil {
+			return err
+		}
+
+		Default = backend
+	case "memory":
+		Default = NewMemory(config.Env.MemoryMaxSize)
+	default:
+		return fmt.Errorf("unknown storage driver: %s", config.Env.StorageDriver)
+	}
+
+	return nil
+}
+
+// Close releases the default backend.
+func Close() error {
+	return Default.Close()
+}
+
+// The functions below use the default backend without cancellation, for the
+// small metadata and configuration files, which are read and written whole.
+
+func Open(name string) (File, error) {
+	return Default.Get(context.Background(), name)
+}
+
+func ReadFile(name string) ([]byte, error) {
+	f, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+func WriteFile(name string, data []byte) error {
+	_, err := Default.Put(context.Background(), name, bytes.NewReader(data))
+	return err
+}
+
+func Stat(name string) (*Info, error) {
+	return Default.Stat(context.Background(), name)
+}
+
+func Delete(name string) error {
+	return Default.Delete(context.Background(), name)
+}
+
+func DeleteAll(name string) error {
+	return Default.DeleteAll(context.Background(), name)
+}
+
+func List(dir string) ([]*Info, error) {
+	return Default.List(context.Background(), dir)
+}
+
+func Walk(dir string, fn WalkFunc) error {
+	return Default.Walk(context.Background(), dir, fn)
+}
+
+func Mkdir(name string) error {
+	return Default.Mkdir(context.Background(), name)
+}
+
+func Rename(oldName, newName string) error {
+	return Default.Rename(context.Background(), oldName, newName)
+}
+
+// contextReader stops reading once the context is done, so that long copies
+// are cancelled along with their request.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+func withContext(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+// IsNotExist reports whether an error is due to a missing name.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
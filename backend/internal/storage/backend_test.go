@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// drivers creates a fresh backend of each driver, so that every driver is
+// held to the same contract.
+var drivers = []struct {
+	name string
+	new  func(t *testing.T) Backend
+}{
+	{"fs", func(t *testing.T) Backend {
+		b, err := NewFS(t.TempDir(), DurabilityNone, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}},
+	{"memory", func(t *testing.T) Backend {
+		return NewMemory(0)
+	}},
+	{"erasure", func(t *testing.T) Backend {
+		root := t.TempDir()
+		drives := make([]string, 4)
+
+		for i := range drives {
+			drives[i] = filepath.Join(root, "drive"+string(rune('1'+i)))
+
+			if err := os.Mkdir(drives[i], 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b, err := NewErasure(drives, 2, DurabilityNone, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}},
+	{"pools", func(t *testing.T) Backend {
+		root := t.TempDir()
+		pools := []string{filepath.Join(root, "pool1"), filepath.Join(root, "pool2")}
+
+		for _, pool := range pools {
+			if err := os.Mkdir(pool, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b, err := NewPools(pools, 0, filepath.Join(root, "pools.json"), DurabilityNone, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}},
+}
+
+func TestBackendConformance(t *testing.T) {
+	logger.Init()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, b Backend)
+	}{
+		{"put and get", testPutGet},
+		{"read at", testReadAt},
+		{"stat", testStat},
+		{"list", testList},
+		{"walk", testWalk},
+		{"rename", testRename},
+		{"copy", testCopy},
+		{"delete", testDelete},
+		{"delete all", testDeleteAll},
+		{"missing names", testMissing},
+	}
+
+	for _, d := range drivers {
+		t.Run(d.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					b := d.new(t)
+					t.Cleanup(func() { b.Close() })
+
+					ctx := context.Background()
+
+					if err := b.Mkdir(ctx, "bucket"); err != nil {
+						t.Fatalf("Mkdir: %v", err)
+					}
+
+					tt.run(t, ctx, b)
+				})
+			}
+		})
+	}
+}
+
+func put(t *testing.T, ctx context.Context, b Backend, name, data string) {
+	t.Helper()
+
+	n, err := b.Put(ctx, name, bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatalf("Put(%s): %v", name, err)
+	}
+
+	if n != int64(len(data)) {
+		t.Fatalf("Put(%s) = %d bytes, want %d", name, n, len(data))
+	}
+}
+
+func read(t *testing.T, ctx context.Context, b Backend, name string) string {
+	t.Helper()
+
+	f, err := b.Get(ctx, name)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", name, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+
+	return string(data)
+}
+
+func names(infos []*Info) []string {
+	var names []string
+
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+
+	return names
+}
+
+func testPutGet(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/dir/key", "first")
+
+	if got := read(t, ctx, b, "bucket/dir/key"); got != "first" {
+		t.Errorf("Get = %q, want %q", got, "first")
+	}
+
+	put(t, ctx, b, "bucket/dir/key", "replaced")
+
+	if got := read(t, ctx, b, "bucket/dir/key"); got != "replaced" {
+		t.Errorf("Get after replace = %q, want %q", got, "replaced")
+	}
+
+	put(t, ctx, b, "bucket/empty", "")
+
+	if got := read(t, ctx, b, "bucket/empty"); got != "" {
+		t.Errorf("Get empty = %q, want empty", got)
+	}
+}
+
+func testReadAt(t *testing.T, ctx context.Context, b Backend) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	if _, err := b.Put(ctx, "bucket/key", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := b.Get(ctx, "bucket/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, off := range []int64{0, 1, 4095, 65536, int64(len(data)) - 10} {
+		p := make([]byte, 10)
+
+		if _, err := f.ReadAt(p, off); err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+
+		if want := data[off : off+10]; !bytes.Equal(p, want) {
+			t.Errorf("ReadAt(%d) = %q, want %q", off, p, want)
+		}
+	}
+
+	if _, err := f.Seek(50000, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(rest, data[50000:]) {
+		t.Errorf("read after Seek returned %d bytes, want %d", len(rest), len(data)-50000)
+	}
+
+	if _, err := f.ReadAt(make([]byte, 10), int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt past the end = %v, want io.EOF", err)
+	}
+}
+
+func testStat(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/dir/key", "data")
+
+	info, err := b.Stat(ctx, "bucket/dir/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Name != "bucket/dir/key" || info.Size != 4 || info.IsDir {
+		t.Errorf("Stat(file) = %+v, want bucket/dir/key of 4 bytes", info)
+	}
+
+	for _, dir := range []string{"bucket", "bucket/dir"} {
+		info, err := b.Stat(ctx, dir)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", dir, err)
+		}
+
+		if !info.IsDir {
+			t.Errorf("Stat(%s) is not a directory", dir)
+		}
+	}
+}
+
+func testList(t *testing.T, ctx context.Context, b Backend) {
+	for _, name := range []string{"bucket/c", "bucket/a", "bucket/b/nested", "bucket/b/other"} {
+		put(t, ctx, b, name, name)
+	}
+
+	infos, err := b.List(ctx, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names(infos), []string{"bucket/a", "bucket/b", "bucket/c"}; !slices.Equal(got, want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+
+	if !infos[1].IsDir || infos[0].IsDir || infos[0].Size != int64(len("bucket/a")) {
+		t.Errorf("List returned wrong info: %+v, %+v", infos[0], infos[1])
+	}
+
+	infos, err = b.List(ctx, "bucket/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names(infos), []string{"bucket/b/nested", "bucket/b/other"}; !slices.Equal(got, want) {
+		t.Errorf("List(subdirectory) = %v, want %v", got, want)
+	}
+}
+
+func testWalk(t *testing.T, ctx context.Context, b Backend) {
+	files := []string{"bucket/z", "bucket/a/2", "bucket/a/1", "bucket/m/n/o"}
+
+	for _, name := range files {
+		put(t, ctx, b, name, "data")
+	}
+
+	var got []string
+
+	err := b.Walk(ctx, "bucket", func(name string, info *Info) error {
+		if info.IsDir {
+			t.Errorf("Walk visited directory %s", name)
+		}
+
+		got = append(got, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"bucket/a/1", "bucket/a/2", "bucket/m/n/o", "bucket/z"}; !slices.Equal(got, want) {
+		t.Errorf("Walk = %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+
+	err = b.Walk(ctx, "bucket", func(name string, info *Info) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Errorf("Walk returned %v after %d files, want stop after 1", err, visited)
+	}
+}
+
+func testRename(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/old", "file")
+
+	if err := b.Rename(ctx, "bucket/old", "bucket/new/parent/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, ctx, b, "bucket/new/parent/file"); got != "file" {
+		t.Errorf("Get renamed file = %q, want %q", got, "file")
+	}
+
+	if _, err := b.Stat(ctx, "bucket/old"); !IsNotExist(err) {
+		t.Errorf("Stat(old name) = %v, want fs.ErrNotExist", err)
+	}
+
+	put(t, ctx, b, "bucket/dir/a", "a")
+	put(t, ctx, b, "bucket/dir/sub/b", "b")
+
+	if err := b.Rename(ctx, "bucket/dir", "bucket/moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, ctx, b, "bucket/moved/sub/b"); got != "b" {
+		t.Errorf("Get file in renamed directory = %q, want %q", got, "b")
+	}
+
+	if _, err := b.Stat(ctx, "bucket/dir"); !IsNotExist(err) {
+		t.Errorf("Stat(old directory) = %v, want fs.ErrNotExist", err)
+	}
+
+	put(t, ctx, b, "bucket/src", "new")
+	put(t, ctx, b, "bucket/dst", "old")
+
+	if err := b.Rename(ctx, "bucket/src", "bucket/dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, ctx, b, "bucket/dst"); got != "new" {
+		t.Errorf("Get replaced file = %q, want %q", got, "new")
+	}
+}
+
+func testCopy(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/src", "data")
+
+	if err := b.Copy(ctx, "bucket/src", "bucket/copy/dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, ctx, b, "bucket/src", "changed")
+
+	if got := read(t, ctx, b, "bucket/copy/dst"); got != "data" {
+		t.Errorf("Get copy = %q, want %q", got, "data")
+	}
+}
+
+func testDelete(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/dir/key", "data")
+
+	if err := b.Delete(ctx, "bucket/dir"); err == nil {
+		t.Error("Delete of a non-empty directory succeeded")
+	}
+
+	if err := b.Delete(ctx, "bucket/dir/key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Stat(ctx, "bucket/dir/key"); !IsNotExist(err) {
+		t.Errorf("Stat(deleted) = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := b.Mkdir(ctx, "bucket/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Delete(ctx, "bucket/empty"); err != nil {
+		t.Errorf("Delete of an empty directory: %v", err)
+	}
+}
+
+func testDeleteAll(t *testing.T, ctx context.Context, b Backend) {
+	put(t, ctx, b, "bucket/dir/a", "a")
+	put(t, ctx, b, "bucket/dir/sub/b", "b")
+	put(t, ctx, b, "bucket/keep", "keep")
+
+	if err := b.DeleteAll(ctx, "bucket/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Stat(ctx, "bucket/dir/sub/b"); !IsNotExist(err) {
+		t.Errorf("Stat(deleted) = %v, want fs.ErrNotExist", err)
+	}
+
+	if got := read(t, ctx, b, "bucket/keep"); got != "keep" {
+		t.Errorf("Get sibling = %q, want %q", got, "keep")
+	}
+
+	if err := b.DeleteAll(ctx, "bucket/missing"); err != nil {
+		t.Errorf("DeleteAll(missing) = %v, want nil", err)
+	}
+}
+
+func testMissing(t *testing.T, ctx context.Context, b Backend) {
+	const missing = "bucket/missing"
+
+	checks := map[string]func() error{
+		"Get": func() error {
+			_, err := b.Get(ctx, missing)
+			return err
+		},
+		"Stat":   func() error { _, err := b.Stat(ctx, missing); return err },
+		"Delete": func() error { return b.Delete(ctx, missing) },
+		"List":   func() error { _, err := b.List(ctx, missing); return err },
+		"Rename": func() error { return b.Rename(ctx, missing, "bucket/other") },
+		"Copy":   func() error { return b.Copy(ctx, missing, "bucket/other") },
+	}
+
+	for op, check := range checks {
+		if err := check(); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s(missing) = %v, want fs.ErrNotExist", op, err)
+		}
+	}
+
+	err := b.Walk(ctx, missing, func(name string, info *Info) error {
+		t.Errorf("Walk(missing) visited %s", name)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Walk(missing) = %v, want nil", err)
+	}
+}
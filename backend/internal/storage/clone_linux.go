@@ -0,0 +1,15 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates a copy-on-write clone (reflink) of src into dst, which is
+// supported by filesystems like Btrfs, XFS or bcachefs.
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
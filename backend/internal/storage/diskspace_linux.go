@@ -0,0 +1,17 @@
+//go:build linux
+
+package storage
+
+import "golang.org/x/sys/unix"
+
+// diskSpace returns the size of the filesystem holding a directory, and the
+// space available to unprivileged users, in bytes.
+func diskSpace(dir string) (int64, int64, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+
+	return int64(st.Blocks) * st.Bsize, int64(st.Bavail) * st.Bsize, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package storage
+
+// diskSpace reports an unknown size, of -1, where it is not supported, which
+// never counts as full.
+func diskSpace(dir string) (int64, int64, error) {
+	return -1, -1, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// Durability sets when writes to the filesystem are flushed to stable storage.
+type Durability string
+
+const (
+	// DurabilityObject syncs every file before it is renamed into place, and
+	// its directory afterwards, so acknowledged writes survive a crash.
+	DurabilityObject Durability = "object"
+
+	// DurabilityBatch syncs everything written since the last flush, at a
+	// fixed interval, so a crash loses at most the last interval of writes.
+	DurabilityBatch Durability = "batch"
+
+	// DurabilityNone leaves flushing to the OS, which is only meant for
+	// benchmarks.
+	DurabilityNone Durability = "none"
+)
+
+func ParseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case DurabilityObject, DurabilityBatch, DurabilityNone:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown durability mode: %s", s)
+	}
+}
+
+// syncer flushes files and directories to stable storage, as required by the
+// durability mode.
+type syncer struct {
+	durability Durability
+
+	mu    sync.Mutex
+	dirty map[string]struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newSyncer(durability Durability, interval time.Duration) *syncer {
+	s := &syncer{
+		durability: durability,
+		dirty:      map[string]struct{}{},
+	}
+
+	if durability == DurabilityBatch {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+
+		go s.run(interval)
+	}
+
+	return s
+}
+
+func (s *syncer) run(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				logger.Log.Errorf("Could not flush writes: %s", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// file is called with a fully written file, before it is closed.
+func (s *syncer) file(f *os.File) error {
+	if s.durability == DurabilityObject {
+		return f.Sync()
+	}
+
+	return nil
+}
+
+// paths is called after files are renamed into place, or removed, with the
+// affected files and directories.
+func (s *syncer) paths(paths ...string) error {
+	switch s.durability {
+	case DurabilityObject:
+		for _, p := range paths {
+			if err := syncPath(p); err != nil {
+				return err
+			}
+		}
+	case DurabilityBatch:
+		s.mu.Lock()
+
+		for _, p := range paths {
+			s.dirty[p] = struct{}{}
+		}
+
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// flush syncs everything written since the last flush. Paths removed in the
+// meantime are skipped.
+func (s *syncer) flush() error {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.dirty = map[string]struct{}{}
+	s.mu.Unlock()
+
+	for p := range dirty {
+		if err := syncPath(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *syncer) close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+
+	return s.flush()
+}
+
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,740 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/reedsolomon"
+)
+
+const maxErasureDrives = 16
+
+func ErrorReadQuorum() *core.S3Error {
+	return &core.S3Error{
+		Code:       "XMinioReadQuorum",
+		Message:    "Multiple disk failures, unable to reconstruct data.",
+		StatusCode: fiber.StatusServiceUnavailable,
+	}
+}
+
+func ErrorWriteQuorum() *core.S3Error {
+	return &core.S3Error{
+		Code:       "XMinioWriteQuorum",
+		Message:    "Multiple disk failures, unable to write data.",
+		StatusCode: fiber.StatusServiceUnavailable,
+	}
+}
+
+// Erasure spreads every file over a set of drives, split into data and parity
+// shards with Reed-Solomon, one shard per drive. Reads tolerate as many
+// missing or corrupt shards as there are parity shards, while writes must
+// reach a write quorum of drives, which is one more than the data shards when
+// there are as many parity shards, so that two conflicting writes cannot both
+// succeed. Directories are created on every drive.
+type Erasure struct {
+	roots  []string
+	drives []*FS
+	syncer *syncer
+
+	data   int
+	parity int
+
+	mu       sync.Mutex
+	encoders map[[2]int]reedsolomon.Encoder
+}
+
+// NewErasure creates an erasure set over the given drives, with parity shards
+// for each file, or half the drives, when zero. Missing drives are reported
+// as offline, but do not keep the server from starting, as long as there are
+// enough drives to write.
+func NewErasure(roots []string, parity int, durability Durability, syncInterval time.Duration) (*Erasure, error) {
+	if len(roots) < 2 || len(roots) > maxErasureDrives {
+		return nil, fmt.Errorf("erasure coding needs between 2 and %d drives", maxErasureDrives)
+	}
+
+	if parity == 0 {
+		parity = len(roots) / 2
+	}
+
+	if parity < 1 || parity > len(roots)/2 {
+		return nil, fmt.Errorf("erasure parity must be between 1 and %d", len(roots)/2)
+	}
+
+	b := &Erasure{
+		roots:    roots,
+		syncer:   newSyncer(durability, syncInterval),
+		data:     len(roots) - parity,
+		parity:   parity,
+		encoders: map[[2]int]reedsolomon.Encoder{},
+	}
+
+	online := 0
+
+	for _, root := range roots {
+		b.drives = append(b.drives, &FS{root: root, syncer: b.syncer})
+
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			logger.Log.Warnf("Drive %s is offline", root)
+			continue
+		}
+
+		online++
+	}
+
+	if online < b.writeQuorum() {
+		return nil, fmt.Errorf("only %d of %d drives are online, below the write quorum of %d", online, len(roots), b.writeQuorum())
+	}
+
+	logger.Log.Infof("Erasure coding over %d drives, with %d data and %d parity shards", len(roots), b.data, b.parity)
+
+	return b, nil
+}
+
+func (b *Erasure) readQuorum() int {
+	return b.data
+}
+
+func (b *Erasure) writeQuorum() int {
+	if b.data == b.parity {
+		return b.data + 1
+	}
+
+	return b.data
+}
+
+// online reports whether a drive is mounted, which is never assumed, so that
+// files are never written to the mount point of a missing drive.
+func (b *Erasure) online(drive int) bool {
+	info, err := os.Stat(b.roots[drive])
+	return err == nil && info.IsDir()
+}
+
+func (b *Erasure) encoder(data, parity int) (reedsolomon.Encoder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := [2]int{data, parity}
+
+	if enc, ok := b.encoders[key]; ok {
+		return enc, nil
+	}
+
+	enc, err := reedsolomon.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+
+	b.encoders[key] = enc
+
+	return enc, nil
+}
+
+// forEach runs fn on every online drive, in parallel, and returns the error
+// of each drive, with errOffline for offline drives.
+func (b *Erasure) forEach(fn func(drive int, d *FS) error) []error {
+	errs := make([]error, len(b.drives))
+
+	var wg sync.WaitGroup
+
+	for i, d := range b.drives {
+		if !b.online(i) {
+			errs[i] = errOffline
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, d)
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+var errOffline = errors.New("drive is offline")
+
+// shardWriter writes a shard of a file to a temporary file on a drive.
+type shardWriter struct {
+	drive int
+	index int
+	f     *os.File
+	w     *bufio.Writer
+	err   error
+}
+
+// createShards creates a shard writer on each drive, for the shard index it is
+// mapped to. Writers that fail keep their error, and are skipped from then on.
+func (b *Erasure) createShards(targets map[int]int) []*shardWriter {
+	writers := make([]*shardWriter, 0, len(targets))
+
+	for drive, index := range targets {
+		w := &shardWriter{drive: drive, index: index}
+		writers = append(writers, w)
+
+		if !b.online(drive) {
+			w.err = errOffline
+			continue
+		}
+
+		if w.f, w.err = b.drives[drive].createTemp(); w.err != nil {
+			continue
+		}
+
+		w.w = bufio.NewWriterSize(w.f, 256<<10)
+
+		// Rewritten once the size is known
+		_, w.err = w.w.Write(make([]byte, shardHeaderSize))
+	}
+
+	return writers
+}
+
+func (w *shardWriter) write(shards [][]byte) {
+	if w.err == nil {
+		w.err = writeShard(w.w, shards[w.index])
+	}
+}
+
+// finishShards writes the header of each shard, and syncs it, as required by
+// the durability mode, returning the writers that succeeded.
+func (b *Erasure) finishShards(writers []*shardWriter, h shardHeader) []*shardWriter {
+	var ok []*shardWriter
+
+	for _, w := range writers {
+		if w.err == nil {
+			w.err = w.w.Flush()
+		}
+
+		if w.err == nil {
+			h.Index = w.index
+			_, w.err = w.f.WriteAt(h.encode(), 0)
+		}
+
+		if w.err == nil {
+			w.err = b.syncer.file(w.f)
+		}
+
+		if w.f != nil {
+			if err := w.f.Close(); w.err == nil {
+				w.err = err
+			}
+		}
+
+		if w.err != nil {
+			if w.f != nil {
+				os.Remove(w.f.Name())
+			}
+
+			if w.err != errOffline {
+				logger.Log.Warnf("Could not write to drive %s: %s", b.roots[w.drive], w.err)
+			}
+
+			continue
+		}
+
+		ok = append(ok, w)
+	}
+
+	return ok
+}
+
+// commitShards renames the shards into place, returning how many were.
+func (b *Erasure) commitShards(writers []*shardWriter, name string) int {
+	errs := make([]error, len(writers))
+
+	var wg sync.WaitGroup
+
+	for i, w := range writers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[i] = b.drives[w.drive].commit(w.f.Name(), name)
+		}()
+	}
+
+	wg.Wait()
+
+	committed := 0
+
+	for i, err := range errs {
+		if err != nil {
+			logger.Log.Warnf("Could not write to drive %s: %s", b.roots[writers[i].drive], err)
+			continue
+		}
+
+		committed++
+	}
+
+	return committed
+}
+
+func discardShards(writers []*shardWriter) {
+	for _, w := range writers {
+		if w.f != nil {
+			w.f.Close()
+			os.Remove(w.f.Name())
+		}
+	}
+}
+
+// encodeBlock splits a block into data shards, padded with zeros, and then
+// computes the parity shards.
+func encodeBlock(enc reedsolomon.Encoder, h *shardHeader, block []byte) ([][]byte, error) {
+	shardLen := (len(block) + h.Data - 1) / h.Data
+	shards := make([][]byte, h.shards())
+
+	for i := range shards {
+		shards[i] = make([]byte, shardLen)
+
+		if i < h.Data {
+			copy(shards[i], block[min(i*shardLen, len(block)):])
+		}
+	}
+
+	if err := enc.Encode(shards); err != nil {
+		return nil, err
+	}
+
+	return shards, nil
+}
+
+func (b *Erasure) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	h := shardHeader{
+		Data:      b.data,
+		Parity:    b.parity,
+		BlockSize: erasureBlockSize,
+		WriteID:   rand.Uint64(),
+		ModTime:   time.Now().UTC(),
+	}
+
+	enc, err := b.encoder(h.Data, h.Parity)
+	if err != nil {
+		return 0, err
+	}
+
+	targets := map[int]int{}
+
+	for i := range b.drives {
+		targets[i] = i
+	}
+
+	writers := b.createShards(targets)
+	block := make([]byte, h.BlockSize)
+	r = withContext(ctx, r)
+
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			shards, err := encodeBlock(enc, &h, block[:n])
+			if err != nil {
+				discardShards(writers)
+				return h.Size, err
+			}
+
+			for _, w := range writers {
+				w.write(shards)
+			}
+
+			h.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			discardShards(writers)
+			return h.Size, err
+		}
+	}
+
+	writers = b.finishShards(writers, h)
+
+	if len(writers) < b.writeQuorum() {
+		discardShards(writers)
+		return h.Size, ErrorWriteQuorum()
+	}
+
+	if b.commitShards(writers, name) < b.writeQuorum() {
+		return h.Size, ErrorWriteQuorum()
+	}
+
+	return h.Size, nil
+}
+
+// openShards opens the shards of a file on every online drive, and keeps those
+// of the latest write that can still be read, closing the rest. The file does
+// not exist when it is missing from more drives than a write can miss, which
+// also hides shards left behind on drives that missed a delete.
+func (b *Erasure) openShards(name string) (*shardHeader, []*shardFile, error) {
+	found := make([]*shardFile, len(b.drives))
+
+	errs := b.forEach(func(drive int, d *FS) error {
+		f, err := os.Open(d.path(name))
+		if err != nil {
+			return err
+		}
+
+		h, err := readShardHeader(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		found[drive] = &shardFile{drive: drive, f: f, h: h}
+
+		return nil
+	})
+
+	missing := 0
+
+	for _, err := range errs {
+		if os.IsNotExist(err) {
+			missing++
+		}
+	}
+
+	h, shards := pickWrite(found)
+
+	for _, s := range found {
+		if s != nil && (h == nil || shards[s.h.Index] != s) {
+			s.f.Close()
+		}
+	}
+
+	if h == nil {
+		if missing > len(b.drives)-b.writeQuorum() {
+			return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		return nil, nil, ErrorReadQuorum()
+	}
+
+	return h, shards, nil
+}
+
+// pickWrite finds the latest write with enough shards to be read, and returns
+// its header and shards, by shard index.
+func pickWrite(found []*shardFile) (*shardHeader, []*shardFile) {
+	var (
+		best   *shardHeader
+		shards []*shardFile
+	)
+
+	for _, s := range found {
+		if s == nil || best != nil && !s.h.ModTime.After(best.ModTime) {
+			continue
+		}
+
+		candidate := make([]*shardFile, s.h.shards())
+		count := 0
+
+		for _, o := range found {
+			if o != nil && o.h.sameWrite(s.h) && candidate[o.h.Index] == nil {
+				candidate[o.h.Index] = o
+				count++
+			}
+		}
+
+		if count >= s.h.Data {
+			best, shards = s.h, candidate
+		}
+	}
+
+	return best, shards
+}
+
+func (b *Erasure) Get(ctx context.Context, name string) (File, error) {
+	h, shards, err := b.openShards(name)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := b.encoder(h.Data, h.Parity)
+	if err != nil {
+		closeShards(shards)
+		return nil, err
+	}
+
+	return newErasureFile(b, name, enc, h, shards), nil
+}
+
+// statDir returns a directory, along with the number of online drives holding
+// it, or nil, when it is not a directory on any drive.
+func (b *Erasure) statDir(name string) (*Info, int) {
+	var (
+		dir   *Info
+		count int
+	)
+
+	for i, d := range b.drives {
+		if !b.online(i) {
+			continue
+		}
+
+		if info, err := os.Stat(d.path(name)); err == nil && info.IsDir() {
+			if dir == nil {
+				dir = fileInfo(name, info)
+			}
+
+			count++
+		}
+	}
+
+	return dir, count
+}
+
+// Directories only exist when on enough drives to be read, the same as files.
+func (b *Erasure) Stat(ctx context.Context, name string) (*Info, error) {
+	if dir, count := b.statDir(name); dir != nil {
+		if count < b.readQuorum() {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+
+		return dir, nil
+	}
+
+	h, shards, err := b.openShards(name)
+	if err != nil {
+		return nil, err
+	}
+
+	closeShards(shards)
+
+	return &Info{Name: name, Size: h.Size, ModTime: h.ModTime}, nil
+}
+
+// quorum checks the result of an operation on every drive, which succeeds
+// when it reached the write quorum, with missing names counting as done, and
+// returns fs.ErrNotExist when missing everywhere.
+func (b *Erasure) quorum(op, name string, errs []error) error {
+	done, missing := 0, 0
+
+	var firstErr error
+
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			done++
+		case err == errOffline:
+		case os.IsNotExist(err):
+			missing++
+		default:
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if done == 0 && missing > 0 && firstErr == nil {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	if done+missing >= b.writeQuorum() {
+		return nil
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ErrorWriteQuorum()
+}
+
+func (b *Erasure) Delete(ctx context.Context, name string) error {
+	return b.quorum("remove", name, b.forEach(func(_ int, d *FS) error {
+		return d.Delete(ctx, name)
+	}))
+}
+
+func (b *Erasure) DeleteAll(ctx context.Context, name string) error {
+	return b.quorum("remove", name, b.forEach(func(_ int, d *FS) error {
+		return d.DeleteAll(ctx, name)
+	}))
+}
+
+// entries returns the union of the names under a directory, on every online
+// drive, along with whether each one is a directory.
+func (b *Erasure) entries(ctx context.Context, dir string, recursive bool) (map[string]bool, error) {
+	var mu sync.Mutex
+
+	names := map[string]bool{}
+
+	errs := b.forEach(func(_ int, d *FS) error {
+		root := d.path(dir)
+
+		return filepath.WalkDir(root, func(p string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if p == root {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			names[path.Join(dir, filepath.ToSlash(rel))] = e.IsDir()
+			mu.Unlock()
+
+			if e.IsDir() && !recursive {
+				return filepath.SkipDir
+			}
+
+			return nil
+		})
+	})
+
+	for _, err := range errs {
+		if err != nil && err != errOffline && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+func (b *Erasure) List(ctx context.Context, dir string) ([]*Info, error) {
+	if info, err := b.Stat(ctx, dir); err != nil {
+		return nil, err
+	} else if !info.IsDir {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: errors.New("not a directory")}
+	}
+
+	names, err := b.entries(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*Info, 0, len(names))
+
+	for name := range names {
+		info, err := b.Stat(ctx, name)
+		if IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos, nil
+}
+
+func (b *Erasure) Walk(ctx context.Context, dir string, fn WalkFunc) error {
+	names, err := b.entries(ctx, dir, true)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(names))
+
+	for name, isDir := range names {
+		if !isDir {
+			files = append(files, name)
+		}
+	}
+
+	// Same order as walking each directory in turn, by name
+	sort.Slice(files, func(i, j int) bool {
+		return strings.ReplaceAll(files[i], "/", "\x00") < strings.ReplaceAll(files[j], "/", "\x00")
+	})
+
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		info, err := b.Stat(ctx, name)
+		if IsNotExist(err) || err == nil && info.IsDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(name, info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Erasure) Mkdir(ctx context.Context, name string) error {
+	return b.quorum("mkdir", name, b.forEach(func(_ int, d *FS) error {
+		return d.Mkdir(ctx, name)
+	}))
+}
+
+func (b *Erasure) Rename(ctx context.Context, oldName, newName string) error {
+	return b.quorum("rename", oldName, b.forEach(func(_ int, d *FS) error {
+		return d.Rename(ctx, oldName, newName)
+	}))
+}
+
+// Copy copies the shards on each drive, unless too few drives hold them, in
+// which case the file is rewritten, restoring its missing shards.
+func (b *Erasure) Copy(ctx context.Context, src, dst string) error {
+	errs := b.forEach(func(_ int, d *FS) error {
+		return d.Copy(ctx, src, dst)
+	})
+
+	done := 0
+
+	for _, err := range errs {
+		if err == nil {
+			done++
+		}
+	}
+
+	if done == len(b.drives) {
+		return nil
+	}
+
+	f, err := b.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = b.Put(ctx, dst, f)
+
+	return err
+}
+
+// Close flushes any pending writes, under batch durability.
+func (b *Erasure) Close() error {
+	return b.syncer.close()
+}
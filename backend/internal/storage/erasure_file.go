@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/klauspost/reedsolomon"
+)
+
+// Shard files start with a header, followed by the shard of each block, in
+// order, each one preceded by its CRC-32C. All shards of a block have the same
+// size, so any block is found without reading the ones before it.
+//
+//	magic (4) version (1) data (1) parity (1) index (1) block size (4)
+//	size (8) write ID (8) modification time (8) header CRC-32C (4)
+const (
+	shardVersion    = 1
+	shardHeaderSize = 40
+	shardCRCSize    = 4
+
+	erasureBlockSize = 1 << 20
+)
+
+var (
+	shardMagic = []byte("LSEC")
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errShardHeader  = errors.New("invalid shard header")
+	errShardCorrupt = errors.New("shard checksum mismatch")
+
+	// ErrCorrupted is returned when a block cannot be read because too many of
+	// its shards are corrupt, rather than missing, which no healing can fix
+	ErrCorrupted = errors.New("data is corrupted beyond repair")
+)
+
+// shardHeader describes a file and one of its shards. All shards written
+// together share the same write ID, which tells them apart from stale shards
+// left by earlier writes, on drives that missed later ones.
+type shardHeader struct {
+	Data      int
+	Parity    int
+	Index     int
+	BlockSize int64
+	Size      int64
+	WriteID   uint64
+	ModTime   time.Time
+}
+
+func (h *shardHeader) encode() []byte {
+	buf := make([]byte, shardHeaderSize)
+
+	copy(buf, shardMagic)
+	buf[4] = shardVersion
+	buf[5] = byte(h.Data)
+	buf[6] = byte(h.Parity)
+	buf[7] = byte(h.Index)
+	binary.BigEndian.PutUint32(buf[8:], uint32(h.BlockSize))
+	binary.BigEndian.PutUint64(buf[12:], uint64(h.Size))
+	binary.BigEndian.PutUint64(buf[20:], h.WriteID)
+	binary.BigEndian.PutUint64(buf[28:], uint64(h.ModTime.UnixNano()))
+	binary.BigEndian.PutUint32(buf[36:], crc32.Checksum(buf[:36], castagnoli))
+
+	return buf
+}
+
+func decodeShardHeader(buf []byte) (*shardHeader, error) {
+	if len(buf) != shardHeaderSize || string(buf[:4]) != string(shardMagic) || buf[4] != shardVersion ||
+		binary.BigEndian.Uint32(buf[36:]) != crc32.Checksum(buf[:36], castagnoli) {
+		return nil, errShardHeader
+	}
+
+	h := &shardHeader{
+		Data:      int(buf[5]),
+		Parity:    int(buf[6]),
+		Index:     int(buf[7]),
+		BlockSize: int64(binary.BigEndian.Uint32(buf[8:])),
+		Size:      int64(binary.BigEndian.Uint64(buf[12:])),
+		WriteID:   binary.BigEndian.Uint64(buf[20:]),
+		ModTime:   time.Unix(0, int64(binary.BigEndian.Uint64(buf[28:]))).UTC(),
+	}
+
+	if h.Data < 1 || h.Parity < 1 || h.Index >= h.Data+h.Parity || h.BlockSize < 1 || h.Size < 0 {
+		return nil, errShardHeader
+	}
+
+	return h, nil
+}
+
+func (h *shardHeader) shards() int {
+	return h.Data + h.Parity
+}
+
+func (h *shardHeader) blocks() int64 {
+	return (h.Size + h.BlockSize - 1) / h.BlockSize
+}
+
+// blockLen returns the size of a block, which is smaller for the last one.
+func (h *shardHeader) blockLen(block int64) int64 {
+	return min(h.BlockSize, h.Size-block*h.BlockSize)
+}
+
+// shardLen returns the size of each shard of a block.
+func (h *shardHeader) shardLen(block int64) int64 {
+	return (h.blockLen(block) + int64(h.Data) - 1) / int64(h.Data)
+}
+
+func (h *shardHeader) shardOffset(block int64) int64 {
+	return shardHeaderSize + block*(shardCRCSize+(h.BlockSize+int64(h.Data)-1)/int64(h.Data))
+}
+
+// sameWrite reports whether two headers belong to shards written together.
+func (h *shardHeader) sameWrite(o *shardHeader) bool {
+	return h.WriteID == o.WriteID && h.Size == o.Size && h.Data == o.Data && h.Parity == o.Parity &&
+		h.BlockSize == o.BlockSize && h.ModTime.Equal(o.ModTime)
+}
+
+func readShardHeader(f *os.File) (*shardHeader, error) {
+	buf := make([]byte, shardHeaderSize)
+
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return nil, errShardHeader
+	}
+
+	return decodeShardHeader(buf)
+}
+
+// readShard reads the shard of a block, checking its CRC-32C.
+func readShard(f *os.File, h *shardHeader, block int64) ([]byte, error) {
+	buf := make([]byte, shardCRCSize+h.shardLen(block))
+
+	if _, err := f.ReadAt(buf, h.shardOffset(block)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errShardCorrupt
+		}
+
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(buf) != crc32.Checksum(buf[shardCRCSize:], castagnoli) {
+		return nil, errShardCorrupt
+	}
+
+	return buf[shardCRCSize:], nil
+}
+
+// writeShard writes the shard of a block, preceded by its CRC-32C.
+func writeShard(w io.Writer, shard []byte) error {
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(shard, castagnoli))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(shard)
+
+	return err
+}
+
+// shardFile is an open shard of a file, on one of the drives.
+type shardFile struct {
+	drive int
+	f     *os.File
+	h     *shardHeader
+}
+
+// erasureFile reads a file from its shards, reading only the data shards of
+// each block, unless some are missing or corrupt, in which case the block is
+// reconstructed from the parity shards.
+type erasureFile struct {
+	b      *Erasure
+	name   string
+	h      *shardHeader
+	enc    reedsolomon.Encoder
+	shards []*shardFile
+
+	// Shards that failed with an I/O error, and are no longer read
+	failed []bool
+
+	// Shards already reported as corrupt or failed
+	reported []bool
+
+	block  []byte
+	index  int64
+	offset int64
+}
+
+func newErasureFile(b *Erasure, name string, enc reedsolomon.Encoder, h *shardHeader, shards []*shardFile) *erasureFile {
+	return &erasureFile{
+		b:        b,
+		name:     name,
+		h:        h,
+		enc:      enc,
+		shards:   shards,
+		failed:   make([]bool, len(shards)),
+		reported: make([]bool, len(shards)),
+		index:    -1,
+	}
+}
+
+func (f *erasureFile) report(i int, err error) {
+	if f.reported[i] {
+		return
+	}
+
+	f.reported[i] = true
+
+	logger.Log.Warnf("Shard %d of %s on %s is unreadable: %s", i, f.name, f.b.roots[f.shards[i].drive], err)
+}
+
+// load reads a block into the block buffer.
+func (f *erasureFile) load(block int64) error {
+	shards := make([][]byte, f.h.shards())
+	read, corrupt := 0, 0
+
+	for i := 0; i < len(shards) && read < f.h.Data; i++ {
+		if f.shards[i] == nil || f.failed[i] {
+			continue
+		}
+
+		shard, err := readShard(f.shards[i].f, f.h, block)
+		if err != nil {
+			if errors.Is(err, errShardCorrupt) {
+				corrupt++
+			} else {
+				f.failed[i] = true
+			}
+
+			f.report(i, err)
+			continue
+		}
+
+		shards[i] = shard
+		read++
+	}
+
+	if read < f.h.Data {
+		if read+corrupt >= f.h.Data {
+			return ErrCorrupted
+		}
+
+		return ErrorReadQuorum()
+	}
+
+	for i := range f.h.Data {
+		if shards[i] == nil {
+			if err := f.enc.ReconstructData(shards); err != nil {
+				return ErrorReadQuorum()
+			}
+
+			break
+		}
+	}
+
+	data := f.block[:0]
+
+	for _, shard := range shards[:f.h.Data] {
+		data = append(data, shard...)
+	}
+
+	f.block = data[:f.h.blockLen(block)]
+	f.index = block
+
+	return nil
+}
+
+func (f *erasureFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *erasureFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if off >= f.h.Size {
+			return n, io.EOF
+		}
+
+		block := off / f.h.BlockSize
+
+		if block != f.index {
+			if err := f.load(block); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p[n:], f.block[off-block*f.h.BlockSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (f *erasureFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.h.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *erasureFile) Close() error {
+	closeShards(f.shards)
+	return nil
+}
+
+func closeShards(shards []*shardFile) {
+	for _, s := range shards {
+		if s != nil {
+			s.f.Close()
+		}
+	}
+}
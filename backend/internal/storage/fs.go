@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+)
+
+// FS stores files as-is under a root directory of the local filesystem. Files
+// are written to a temporary file in the staging directory first, and then
+// renamed into place, so readers never see partial files.
+type FS struct {
+	root   string
+	syncer *syncer
+}
+
+func NewFS(root string, durability Durability, syncInterval time.Duration) (*FS, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	b := &FS{
+		root:   root,
+		syncer: newSyncer(durability, syncInterval),
+	}
+
+	return b, nil
+}
+
+func (b *FS) path(name string) string {
+	return filepath.Join(b.root, filepath.FromSlash(name))
+}
+
+// createTemp creates a temporary file in the staging directory, which is on
+// the same filesystem as the final path, as required for an atomic rename.
+func (b *FS) createTemp() (*os.File, error) {
+	dir := b.path(config.SystemPath("tmp"))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return os.CreateTemp(dir, "put-*")
+}
+
+// writeTemp writes a temporary file with fill, syncing it as required by the
+// durability mode, and returns its path.
+func (b *FS) writeTemp(fill func(f *os.File) error) (string, error) {
+	f, err := b.createTemp()
+	if err != nil {
+		return "", err
+	}
+
+	err = fill(f)
+
+	if err == nil {
+		err = b.syncer.file(f)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// commit renames a temporary file into place, replacing any existing file.
+func (b *FS) commit(tmp, name string) error {
+	p := b.path(name)
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if b.syncer.durability == DurabilityObject {
+		return b.syncer.paths(filepath.Dir(p))
+	}
+
+	return b.syncer.paths(p, filepath.Dir(p))
+}
+
+func (b *FS) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	var n int64
+
+	tmp, err := b.writeTemp(func(f *os.File) (err error) {
+		n, err = io.Copy(f, withContext(ctx, r))
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+
+	return n, b.commit(tmp, name)
+}
+
+func (b *FS) Get(ctx context.Context, name string) (File, error) {
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+func (b *FS) Stat(ctx context.Context, name string) (*Info, error) {
+	info, err := os.Stat(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo(name, info), nil
+}
+
+func fileInfo(name string, info fs.FileInfo) *Info {
+	return &Info{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+func (b *FS) Delete(ctx context.Context, name string) error {
+	p := b.path(name)
+
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+
+	return b.syncer.paths(filepath.Dir(p))
+}
+
+func (b *FS) DeleteAll(ctx context.Context, name string) error {
+	return os.RemoveAll(b.path(name))
+}
+
+func (b *FS) List(ctx context.Context, dir string) ([]*Info, error) {
+	entries, err := os.ReadDir(b.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*Info, 0, len(entries))
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, fileInfo(path.Join(dir, e.Name()), info))
+	}
+
+	return infos, nil
+}
+
+func (b *FS) Walk(ctx context.Context, dir string, fn WalkFunc) error {
+	root := b.path(dir)
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		name := path.Join(dir, filepath.ToSlash(rel))
+
+		return fn(name, fileInfo(name, info))
+	})
+}
+
+func (b *FS) Mkdir(ctx context.Context, name string) error {
+	p := b.path(name)
+
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return err
+	}
+
+	return b.syncer.paths(filepath.Dir(p))
+}
+
+func (b *FS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := b.path(oldName), b.path(newName)
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if filepath.Dir(oldPath) == filepath.Dir(newPath) {
+		return b.syncer.paths(filepath.Dir(newPath))
+	}
+
+	return b.syncer.paths(filepath.Dir(newPath), filepath.Dir(oldPath))
+}
+
+// Copy tries a reflink first, falling back to io.Copy, which will still use
+// copy_file_range or sendfile, when supported by the OS.
+func (b *FS) Copy(ctx context.Context, src, dst string) error {
+	in, err := os.Open(b.path(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := b.writeTemp(func(f *os.File) error {
+		if err := cloneFile(f, in); err == nil {
+			return nil
+		}
+
+		_, err := io.Copy(f, withContext(ctx, in))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.commit(tmp, dst)
+}
+
+// Close flushes any pending writes, under batch durability.
+func (b *FS) Close() error {
+	return b.syncer.close()
+}
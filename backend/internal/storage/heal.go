@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+// Shards without enough others to be read are only removed once this old, so
+// that writes still being committed are left alone.
+const danglingAge = time.Minute
+
+type DriveStatus struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Online bool   `json:"online"`
+}
+
+type ErasureStatus struct {
+	DataShards   int            `json:"dataShards"`
+	ParityShards int            `json:"parityShards"`
+	ReadQuorum   int            `json:"readQuorum"`
+	WriteQuorum  int            `json:"writeQuorum"`
+	Drives       []*DriveStatus `json:"drives"`
+}
+
+func (b *Erasure) Status() *ErasureStatus {
+	status := &ErasureStatus{
+		DataShards:   b.data,
+		ParityShards: b.parity,
+		ReadQuorum:   b.readQuorum(),
+		WriteQuorum:  b.writeQuorum(),
+	}
+
+	for i, root := range b.roots {
+		status.Drives = append(status.Drives, &DriveStatus{Index: i, Path: root, Online: b.online(i)})
+	}
+
+	return status
+}
+
+type HealResult struct {
+	Files       int64 `json:"files"`
+	Healed      int64 `json:"healed"`
+	Shards      int64 `json:"shards"`
+	Directories int64 `json:"directories"`
+	Dangling    int64 `json:"dangling"`
+	Failed      int64 `json:"failed"`
+}
+
+// Heal checks every shard of every file, rebuilding those that are missing,
+// stale or corrupt, from the remaining shards, which is how a replaced drive
+// is filled back in. Directories held by enough drives are created on the rest,
+// and shards left behind by deletes that missed a drive are removed. Healing
+// is safe while serving requests, since shards are written to a temporary file
+// first, and a shard rebuilt over a concurrent write only loses to it. Reads
+// are limited by throttle, when not nil.
+func (b *Erasure) Heal(ctx context.Context, throttle *Throttle) (*HealResult, error) {
+	dirs, files, err := b.scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &HealResult{}
+
+	for _, dir := range dirs {
+		created, err := b.healDir(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Directories += int64(created)
+	}
+
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		res.Files++
+
+		shards, err := b.healFile(ctx, name, throttle)
+
+		switch {
+		case errors.Is(err, errDangling):
+			res.Dangling++
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			logger.Log.Errorf("Could not heal %s: %s", name, err)
+			res.Failed++
+		case shards > 0:
+			res.Healed++
+			res.Shards += int64(shards)
+		}
+	}
+
+	logger.Log.Infof(
+		"Healed %d of %d files (%d shards, %d directories), removed %d dangling, %d failed",
+		res.Healed, res.Files, res.Shards, res.Directories, res.Dangling, res.Failed,
+	)
+
+	return res, nil
+}
+
+// scan lists the directories and files on every online drive, skipping the
+// staging area, as sorted slash-separated names.
+func (b *Erasure) scan(ctx context.Context) ([]string, []string, error) {
+	names, err := b.entries(ctx, "", true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	staging := config.SystemPath("tmp")
+
+	var dirs, files []string
+
+	for name, isDir := range names {
+		if name == staging || strings.HasPrefix(name, staging+"/") {
+			continue
+		}
+
+		if isDir {
+			dirs = append(dirs, name)
+		} else {
+			files = append(files, name)
+		}
+	}
+
+	sort.Strings(dirs)
+	sort.Strings(files)
+
+	return dirs, files, nil
+}
+
+// healDir creates a directory on the drives missing it, when held by enough
+// drives to exist, and returns on how many drives it was created.
+func (b *Erasure) healDir(ctx context.Context, name string) (int, error) {
+	if _, count := b.statDir(name); count < b.readQuorum() {
+		return 0, nil
+	}
+
+	created := 0
+
+	for i, d := range b.drives {
+		if !b.online(i) {
+			continue
+		}
+
+		if _, err := os.Stat(d.path(name)); !os.IsNotExist(err) {
+			continue
+		}
+
+		if err := d.Mkdir(ctx, name); err != nil {
+			return created, err
+		}
+
+		created++
+	}
+
+	return created, nil
+}
+
+var errDangling = errors.New("dangling shards")
+
+// healFile rebuilds the missing, stale or corrupt shards of a file, returning
+// how many were rebuilt.
+func (b *Erasure) healFile(ctx context.Context, name string, throttle *Throttle) (int, error) {
+	h, shards, err := b.openShards(name)
+	if IsNotExist(err) {
+		return 0, b.removeDangling(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer closeShards(shards)
+
+	enc, err := b.encoder(h.Data, h.Parity)
+	if err != nil {
+		return 0, err
+	}
+
+	// Shards with a corrupt block are rebuilt as a whole
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+
+		for block := range h.blocks() {
+			if _, err := readShard(s.f, h, block); err != nil {
+				logger.Log.Warnf("Shard %d of %s on %s is unreadable: %s", i, name, b.roots[s.drive], err)
+				s.f.Close()
+				shards[i] = nil
+				break
+			}
+
+			if err := throttle.Wait(ctx, h.shardLen(block)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Missing shards go to the drives without a good shard, in order
+	held := make([]bool, len(b.drives))
+
+	for _, s := range shards {
+		if s != nil {
+			held[s.drive] = true
+		}
+	}
+
+	targets := map[int]int{}
+	drive := 0
+
+	for index, s := range shards {
+		if s != nil {
+			continue
+		}
+
+		for drive < len(b.drives) && (held[drive] || !b.online(drive)) {
+			drive++
+		}
+
+		if drive == len(b.drives) {
+			break
+		}
+
+		targets[drive] = index
+		drive++
+	}
+
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	writers := b.createShards(targets)
+
+	for block := range h.blocks() {
+		data := make([][]byte, h.shards())
+		read := 0
+
+		for i, s := range shards {
+			if s == nil {
+				continue
+			}
+
+			if data[i], err = readShard(s.f, h, block); err != nil {
+				data[i] = nil
+				continue
+			}
+
+			read++
+		}
+
+		if read < h.Data {
+			discardShards(writers)
+			return 0, ErrorReadQuorum()
+		}
+
+		if err := enc.Reconstruct(data); err != nil {
+			discardShards(writers)
+			return 0, err
+		}
+
+		for _, w := range writers {
+			w.write(data)
+		}
+	}
+
+	return b.commitShards(b.finishShards(writers, *h), name), nil
+}
+
+// removeDangling removes the shards of a file that cannot be read, and was
+// deleted, or never committed, once old enough.
+func (b *Erasure) removeDangling(name string) error {
+	removed := false
+
+	for i, d := range b.drives {
+		if !b.online(i) {
+			continue
+		}
+
+		f, err := os.Open(d.path(name))
+		if err != nil {
+			continue
+		}
+
+		h, err := readShardHeader(f)
+		f.Close()
+
+		if err == nil && time.Since(h.ModTime) < danglingAge {
+			continue
+		}
+
+		if err := os.Remove(d.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := b.syncer.paths(filepath.Dir(d.path(name))); err != nil {
+			return err
+		}
+
+		removed = true
+	}
+
+	if !removed {
+		return nil
+	}
+
+	logger.Log.Infof("Removed dangling shards of %s", name)
+
+	return errDangling
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errNotEmpty = errors.New("directory not empty")
+
+func ErrorStorageFull() *core.S3Error {
+	return &core.S3Error{
+		Code:       "XMinioStorageFull",
+		Message:    "Storage backend has reached its maximum size. Please delete a few objects to proceed.",
+		StatusCode: fiber.StatusInsufficientStorage,
+	}
+}
+
+// Memory keeps all files in memory, for ephemeral servers. Directories are
+// implied by the files under them, along with the ones explicitly created.
+type Memory struct {
+	mu      sync.RWMutex
+	files   map[string]*memFile
+	dirs    map[string]time.Time
+	size    int64
+	maxSize int64
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// memReader is immutable, as Put always replaces the data of a file.
+type memReader struct {
+	*bytes.Reader
+}
+
+func (r memReader) Close() error {
+	return nil
+}
+
+// NewMemory creates an empty in-memory backend, holding at most maxSize bytes
+// of file data, or unlimited, when maxSize is zero.
+func NewMemory(maxSize int64) *Memory {
+	return &Memory{
+		files:   map[string]*memFile{},
+		dirs:    map[string]time.Time{},
+		maxSize: maxSize,
+	}
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// under returns the prefix of all names under a directory.
+func under(dir string) string {
+	if dir == "" || dir == "." {
+		return ""
+	}
+
+	return dir + "/"
+}
+
+// Size returns the number of bytes currently stored.
+func (b *Memory) Size() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.size
+}
+
+// Close wipes all files and directories, as nothing outlives an ephemeral
+// server.
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.files = map[string]*memFile{}
+	b.dirs = map[string]time.Time{}
+	b.size = 0
+
+	return nil
+}
+
+// available returns how many bytes can be written to name, or -1 when there
+// is no limit. Replaced data counts as available.
+func (b *Memory) available(name string) int64 {
+	if b.maxSize == 0 {
+		return -1
+	}
+
+	available := b.maxSize - b.size
+
+	if f, ok := b.files[name]; ok {
+		available += int64(len(f.data))
+	}
+
+	return max(available, 0)
+}
+
+// store must be called with the lock held.
+func (b *Memory) store(name string, data []byte) error {
+	if available := b.available(name); available >= 0 && int64(len(data)) > available {
+		return ErrorStorageFull()
+	}
+
+	if f, ok := b.files[name]; ok {
+		b.size -= int64(len(f.data))
+	}
+
+	// Names may come from request parameters, which fiber reuses, so they are
+	// cloned before being kept
+	b.files[strings.Clone(name)] = &memFile{data: data, modTime: time.Now()}
+	b.size += int64(len(data))
+
+	return nil
+}
+
+// remove must be called with the lock held.
+func (b *Memory) remove(name string) {
+	if f, ok := b.files[name]; ok {
+		b.size -= int64(len(f.data))
+		delete(b.files, name)
+	}
+}
+
+func (b *Memory) isDir(name string) bool {
+	if name == "" || name == "." {
+		return true
+	}
+
+	if _, ok := b.dirs[name]; ok {
+		return true
+	}
+
+	prefix := under(name)
+
+	for n := range b.files {
+		if strings.HasPrefix(n, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Memory) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	b.mu.RLock()
+	available := b.available(name)
+	b.mu.RUnlock()
+
+	src := withContext(ctx, r)
+
+	// Stop reading early when the data cannot fit, instead of buffering it all
+	if available >= 0 {
+		src = io.LimitReader(src, available+1)
+	}
+
+	var buf bytes.Buffer
+
+	n, err := io.Copy(&buf, src)
+	if err != nil {
+		return n, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.store(name, buf.Bytes()); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+func (b *Memory) Get(ctx context.Context, name string) (File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	f, ok := b.files[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+
+	return memReader{bytes.NewReader(f.data)}, nil
+}
+
+func (b *Memory) Stat(ctx context.Context, name string) (*Info, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if f, ok := b.files[name]; ok {
+		return &Info{Name: name, Size: int64(len(f.data)), ModTime: f.modTime}, nil
+	}
+
+	if b.isDir(name) {
+		return &Info{Name: name, ModTime: b.dirs[name], IsDir: true}, nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func (b *Memory) Delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.files[name]; ok {
+		b.remove(name)
+		return nil
+	}
+
+	if !b.isDir(name) {
+		return notExist("remove", name)
+	}
+
+	prefix := under(name)
+
+	for n := range b.files {
+		if strings.HasPrefix(n, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+	}
+
+	for n := range b.dirs {
+		if strings.HasPrefix(n, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+	}
+
+	delete(b.dirs, name)
+
+	return nil
+}
+
+func (b *Memory) DeleteAll(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(name)
+	delete(b.dirs, name)
+
+	prefix := under(name)
+
+	for n := range b.files {
+		if strings.HasPrefix(n, prefix) {
+			b.remove(n)
+		}
+	}
+
+	for n := range b.dirs {
+		if strings.HasPrefix(n, prefix) {
+			delete(b.dirs, n)
+		}
+	}
+
+	return nil
+}
+
+func (b *Memory) List(ctx context.Context, dir string) ([]*Info, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.isDir(dir) {
+		return nil, notExist("open", dir)
+	}
+
+	prefix := under(dir)
+	children := map[string]*Info{}
+
+	addDir := func(rel string) {
+		child, _, _ := strings.Cut(rel, "/")
+		name := prefix + child
+
+		if _, ok := children[child]; !ok {
+			children[child] = &Info{Name: name, ModTime: b.dirs[name], IsDir: true}
+		}
+	}
+
+	for n, f := range b.files {
+		rel, ok := strings.CutPrefix(n, prefix)
+		if !ok {
+			continue
+		}
+
+		if strings.Contains(rel, "/") {
+			addDir(rel)
+		} else {
+			children[rel] = &Info{Name: n, Size: int64(len(f.data)), ModTime: f.modTime}
+		}
+	}
+
+	for n := range b.dirs {
+		if rel, ok := strings.CutPrefix(n, prefix); ok && rel != "" {
+			addDir(rel)
+		}
+	}
+
+	infos := make([]*Info, 0, len(children))
+
+	for _, info := range children {
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos, nil
+}
+
+func (b *Memory) Walk(ctx context.Context, dir string, fn WalkFunc) error {
+	b.mu.RLock()
+
+	prefix := under(dir)
+	infos := []*Info{}
+
+	for n, f := range b.files {
+		if strings.HasPrefix(n, prefix) {
+			infos = append(infos, &Info{Name: n, Size: int64(len(f.data)), ModTime: f.modTime})
+		}
+	}
+
+	b.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	// Called without the lock, as fn may change files while walking
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(info.Name, info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Memory) Mkdir(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	for dir := name; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if _, ok := b.dirs[dir]; !ok {
+			b.dirs[strings.Clone(dir)] = now
+		}
+	}
+
+	return nil
+}
+
+func (b *Memory) Rename(ctx context.Context, oldName, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if f, ok := b.files[oldName]; ok {
+		b.remove(oldName)
+		return b.store(newName, f.data)
+	}
+
+	if !b.isDir(oldName) {
+		return notExist("rename", oldName)
+	}
+
+	oldPrefix, newPrefix := under(oldName), under(newName)
+
+	for n, f := range b.files {
+		if rel, ok := strings.CutPrefix(n, oldPrefix); ok {
+			delete(b.files, n)
+			b.files[strings.Clone(newPrefix+rel)] = f
+		}
+	}
+
+	for n, modTime := range b.dirs {
+		if rel, ok := strings.CutPrefix(n, oldPrefix); ok {
+			delete(b.dirs, n)
+			b.dirs[strings.Clone(newPrefix+rel)] = modTime
+		}
+	}
+
+	if modTime, ok := b.dirs[oldName]; ok {
+		delete(b.dirs, oldName)
+		b.dirs[strings.Clone(newName)] = modTime
+	}
+
+	return nil
+}
+
+func (b *Memory) Copy(ctx context.Context, src, dst string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.files[src]
+	if !ok {
+		return notExist("open", src)
+	}
+
+	// Data is never modified in place, so it can be shared
+	return b.store(dst, f.data)
+}
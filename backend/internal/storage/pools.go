@@ -0,0 +1,818 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+)
+
+const poolLockStripes = 64
+
+var errChanged = errors.New("file changed while being moved")
+
+// Pools spreads files over several storage roots, usually one per disk, so
+// that capacity can be added over time, with each file kept whole on a single
+// pool. New files go to the pool their bucket is pinned to, or else the one
+// with the most free space, while rewritten files stay where they are, and
+// reads find files on whichever pool holds them. Directories are created on
+// every pool. Pools being drained take no new files, while theirs are moved
+// to the other pools in the background.
+type Pools struct {
+	roots     []string
+	pools     []*FS
+	syncer    *syncer
+	reserve   int64
+	statePath string
+
+	// Files are locked by stripes of names, only while being committed, and
+	// files moving between pools, along with directory renames and removals,
+	// lock the whole tree, so that walks never miss them
+	tree  sync.RWMutex
+	names [poolLockStripes]sync.Mutex
+
+	mu     sync.Mutex
+	pins   map[string]string
+	drains map[string]*PoolDrain
+}
+
+type poolsState struct {
+	Pins   map[string]string     `json:"pins"`
+	Drains map[string]*PoolDrain `json:"drains"`
+}
+
+// NewPools creates a set of storage pools, keeping free space above reserve on
+// each, in bytes. Pinned buckets and drains are kept in the state file, which
+// should not be on any of the pools. Missing pools are reported as offline,
+// but do not keep the server from starting, as long as one is online.
+func NewPools(roots []string, reserve int64, statePath string, durability Durability, syncInterval time.Duration) (*Pools, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("the pools driver needs at least one pool")
+	}
+
+	b := &Pools{
+		roots:     roots,
+		syncer:    newSyncer(durability, syncInterval),
+		reserve:   reserve,
+		statePath: statePath,
+		pins:      map[string]string{},
+		drains:    map[string]*PoolDrain{},
+	}
+
+	online := 0
+
+	for i, root := range roots {
+		if slices.Contains(roots[:i], root) {
+			return nil, fmt.Errorf("pool %s is listed more than once", root)
+		}
+
+		b.pools = append(b.pools, &FS{root: root, syncer: b.syncer})
+
+		if !b.online(i) {
+			logger.Log.Warnf("Pool %s is offline", root)
+			continue
+		}
+
+		online++
+	}
+
+	if online == 0 {
+		return nil, errors.New("no pool is online")
+	}
+
+	if err := b.loadState(); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infof("Storing files over %d pools", len(roots))
+
+	return b, nil
+}
+
+// loadState reads pinned buckets and drains, dropping those of pools that are
+// no longer configured, which is how drained pools are removed.
+func (b *Pools) loadState() error {
+	data, err := os.ReadFile(b.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read pool state: %w", err)
+	}
+
+	var state poolsState
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("could not decode pool state: %w", err)
+	}
+
+	for bucket, root := range state.Pins {
+		if !slices.Contains(b.roots, root) {
+			logger.Log.Warnf("Unpinning bucket %s from removed pool %s", bucket, root)
+			continue
+		}
+
+		b.pins[bucket] = root
+	}
+
+	for root, d := range state.Drains {
+		if !slices.Contains(b.roots, root) {
+			logger.Log.Infof("Pool %s was removed", root)
+			continue
+		}
+
+		b.drains[root] = d
+	}
+
+	return nil
+}
+
+// saveState writes pinned buckets and drains, with the state lock held.
+func (b *Pools) saveState() error {
+	data, err := json.Marshal(&poolsState{Pins: b.pins, Drains: b.drains})
+	if err != nil {
+		return fmt.Errorf("could not encode pool state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.statePath), 0755); err != nil {
+		return fmt.Errorf("could not write pool state: %w", err)
+	}
+
+	tmp := b.statePath + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("could not write pool state: %w", err)
+	}
+
+	if err := os.Rename(tmp, b.statePath); err != nil {
+		return fmt.Errorf("could not write pool state: %w", err)
+	}
+
+	return nil
+}
+
+// online reports whether a pool is mounted, which is never assumed, so that
+// files are never written to the mount point of a missing disk.
+func (b *Pools) online(pool int) bool {
+	info, err := os.Stat(b.roots[pool])
+	return err == nil && info.IsDir()
+}
+
+// writable reports whether a pool takes new files, i.e., whether it is online
+// and not being drained.
+func (b *Pools) writable(pool int) bool {
+	b.mu.Lock()
+	draining := b.drains[b.roots[pool]] != nil
+	b.mu.Unlock()
+
+	return !draining && b.online(pool)
+}
+
+// free returns the free space of a pool, or -1 when unknown, which never
+// counts as full.
+func (b *Pools) free(pool int) int64 {
+	_, free, err := diskSpace(b.roots[pool])
+	if err != nil {
+		return 0
+	}
+
+	return free
+}
+
+func (b *Pools) hasRoom(pool int) bool {
+	free := b.free(pool)
+	return free < 0 || free > b.reserve
+}
+
+// bucketOf returns the bucket a name belongs to, including its configuration
+// and metadata, or an empty string for names outside of buckets.
+func bucketOf(name string) string {
+	parts := strings.SplitN(name, "/", 4)
+
+	if parts[0] != config.SystemDir {
+		return parts[0]
+	}
+
+	if len(parts) > 2 && parts[1] == "buckets" {
+		return parts[2]
+	}
+
+	return ""
+}
+
+// pinned returns the pool the bucket of a name is pinned to, or -1, when not
+// pinned, or when that pool does not take new files.
+func (b *Pools) pinned(name string) int {
+	b.mu.Lock()
+	root, ok := b.pins[bucketOf(name)]
+	b.mu.Unlock()
+
+	if !ok {
+		return -1
+	}
+
+	pool := slices.Index(b.roots, root)
+
+	if !b.writable(pool) {
+		return -1
+	}
+
+	return pool
+}
+
+// place picks the pool for a file: the one its bucket is pinned to, or else
+// the one already holding it, when given as held, or the one with the most
+// free space, among the pools that take new files and are above the reserve.
+func (b *Pools) place(name string, held int) (int, error) {
+	if pool := b.pinned(name); pool >= 0 {
+		if !b.hasRoom(pool) {
+			return -1, ErrorStorageFull()
+		}
+
+		return pool, nil
+	}
+
+	if held >= 0 && b.writable(held) && b.hasRoom(held) {
+		return held, nil
+	}
+
+	best, bestFree := -1, int64(0)
+
+	for i := range b.pools {
+		if !b.writable(i) || !b.hasRoom(i) {
+			continue
+		}
+
+		free := b.free(i)
+
+		if best < 0 || free > bestFree || free < 0 && bestFree >= 0 {
+			best, bestFree = i, free
+		}
+	}
+
+	if best < 0 {
+		return -1, ErrorStorageFull()
+	}
+
+	return best, nil
+}
+
+// lock locks the stripes of the given names, in order, returning a function
+// to unlock them.
+func (b *Pools) lock(names ...string) func() {
+	stripes := make([]int, 0, len(names))
+
+	for _, name := range names {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		stripes = append(stripes, int(h.Sum32()%poolLockStripes))
+	}
+
+	sort.Ints(stripes)
+	stripes = slices.Compact(stripes)
+
+	for _, s := range stripes {
+		b.names[s].Lock()
+	}
+
+	return func() {
+		for _, s := range stripes {
+			b.names[s].Unlock()
+		}
+	}
+}
+
+// locate finds the pool holding a name, which is the first one for
+// directories, or the one with the newest copy for files, since older copies
+// are only left behind by interrupted writes.
+func (b *Pools) locate(name string) (int, *Info, error) {
+	found := -1
+	var info *Info
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		fi, err := os.Stat(p.path(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return -1, nil, err
+		}
+
+		if fi.IsDir() {
+			return i, fileInfo(name, fi), nil
+		}
+
+		if found < 0 || fi.ModTime().After(info.ModTime) {
+			found, info = i, fileInfo(name, fi)
+		}
+	}
+
+	if found < 0 {
+		return -1, nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return found, info, nil
+}
+
+// heldElsewhere reports whether a file is on any pool other than the given one.
+func (b *Pools) heldElsewhere(pool int, name string) bool {
+	for i, p := range b.pools {
+		if i == pool || !b.online(i) {
+			continue
+		}
+
+		if info, err := os.Lstat(p.path(name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeOthers removes the copies of a file from the pools other than the
+// given one.
+func (b *Pools) removeOthers(pool int, name string) error {
+	for i, p := range b.pools {
+		if i == pool || !b.online(i) {
+			continue
+		}
+
+		if info, err := os.Lstat(p.path(name)); err != nil || info.IsDir() {
+			continue
+		}
+
+		if err := p.Delete(context.Background(), name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit renames a temporary file into place on a pool, removing any other
+// copy of the file.
+func (b *Pools) commit(pool int, tmp, name string) error {
+	if b.heldElsewhere(pool, name) {
+		b.tree.Lock()
+		defer b.tree.Unlock()
+	} else {
+		b.tree.RLock()
+		defer b.tree.RUnlock()
+	}
+
+	unlock := b.lock(name)
+	defer unlock()
+
+	if err := b.pools[pool].commit(tmp, name); err != nil {
+		return storageError(err)
+	}
+
+	return b.removeOthers(pool, name)
+}
+
+// transfer moves a file to another pool, copying it into the staging area of
+// the target pool first, so that it is only locked while committed, provided
+// that it did not change in the meantime, and failing with errChanged
+// otherwise. The modification time is kept. It returns the bytes copied.
+func (b *Pools) transfer(ctx context.Context, from, to int, src, dst string) (int64, error) {
+	f, err := os.Open(b.pools[from].path(src))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	before, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+
+	tmp, err := b.pools[to].writeTemp(func(out *os.File) (err error) {
+		n, err = io.Copy(out, withContext(ctx, f))
+		return err
+	})
+	if err != nil {
+		return n, storageError(err)
+	}
+
+	if err := os.Chtimes(tmp, before.ModTime(), before.ModTime()); err != nil {
+		os.Remove(tmp)
+		return n, err
+	}
+
+	b.tree.Lock()
+	defer b.tree.Unlock()
+
+	unlock := b.lock(src, dst)
+	defer unlock()
+
+	after, err := os.Stat(b.pools[from].path(src))
+	if err != nil || !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
+		os.Remove(tmp)
+
+		if err != nil {
+			return n, err
+		}
+
+		return n, errChanged
+	}
+
+	if err := b.pools[to].commit(tmp, dst); err != nil {
+		return n, storageError(err)
+	}
+
+	if err := b.removeOthers(to, dst); err != nil {
+		return n, err
+	}
+
+	if src != dst {
+		if err := b.pools[from].Delete(ctx, src); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// storageError reports a full disk as XMinioStorageFull, for writes larger than
+// the reserve.
+func storageError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrorStorageFull()
+	}
+
+	return err
+}
+
+func (b *Pools) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	held, _, _ := b.locate(name)
+
+	pool, err := b.place(name, held)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+
+	tmp, err := b.pools[pool].writeTemp(func(f *os.File) (err error) {
+		n, err = io.Copy(f, withContext(ctx, r))
+		return err
+	})
+	if err != nil {
+		return n, storageError(err)
+	}
+
+	return n, b.commit(pool, tmp, name)
+}
+
+func (b *Pools) Get(ctx context.Context, name string) (File, error) {
+	unlock := b.lock(name)
+	defer unlock()
+
+	pool, _, err := b.locate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.pools[pool].Get(ctx, name)
+}
+
+func (b *Pools) Stat(ctx context.Context, name string) (*Info, error) {
+	unlock := b.lock(name)
+	defer unlock()
+
+	_, info, err := b.locate(name)
+
+	return info, err
+}
+
+func (b *Pools) Delete(ctx context.Context, name string) error {
+	b.tree.RLock()
+	defer b.tree.RUnlock()
+
+	unlock := b.lock(name)
+	defer unlock()
+
+	deleted := false
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		err := p.Delete(ctx, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		deleted = true
+	}
+
+	if !deleted {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+func (b *Pools) DeleteAll(ctx context.Context, name string) error {
+	b.tree.Lock()
+	defer b.tree.Unlock()
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		if err := p.DeleteAll(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// merge adds a file or directory found on a pool, keeping directories over
+// files, and newer files over older ones.
+func merge(infos map[string]*Info, info *Info) {
+	prev, ok := infos[info.Name]
+
+	if !ok || !prev.IsDir && (info.IsDir || info.ModTime.After(prev.ModTime)) {
+		infos[info.Name] = info
+	}
+}
+
+func (b *Pools) List(ctx context.Context, dir string) ([]*Info, error) {
+	b.tree.RLock()
+	defer b.tree.RUnlock()
+
+	byName := map[string]*Info{}
+	found := false
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		infos, err := p.List(ctx, dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+
+		for _, info := range infos {
+			merge(byName, info)
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+
+	infos := make([]*Info, 0, len(byName))
+
+	for _, info := range byName {
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos, nil
+}
+
+// walkPools collects the files under dir on every pool.
+func (b *Pools) walkPools(ctx context.Context, dir string) (map[string]*Info, error) {
+	b.tree.RLock()
+	defer b.tree.RUnlock()
+
+	byName := map[string]*Info{}
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		err := p.Walk(ctx, dir, func(_ string, info *Info) error {
+			merge(byName, info)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return byName, nil
+}
+
+func (b *Pools) Walk(ctx context.Context, dir string, fn WalkFunc) error {
+	byName, err := b.walkPools(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(byName))
+
+	for name := range byName {
+		names = append(names, name)
+	}
+
+	// Same order as walking each directory in turn, by name
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ReplaceAll(names[i], "/", "\x00") < strings.ReplaceAll(names[j], "/", "\x00")
+	})
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(name, byName[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Pools) Mkdir(ctx context.Context, name string) error {
+	b.tree.RLock()
+	defer b.tree.RUnlock()
+
+	created := false
+
+	for i, p := range b.pools {
+		if !b.writable(i) {
+			continue
+		}
+
+		if err := p.Mkdir(ctx, name); err != nil {
+			return err
+		}
+
+		created = true
+	}
+
+	if !created {
+		return ErrorStorageFull()
+	}
+
+	return nil
+}
+
+// Rename keeps files on the same pool, unless their new bucket is pinned to
+// another pool, or their pool is being drained, in which case they are moved.
+func (b *Pools) Rename(ctx context.Context, oldName, newName string) error {
+	for {
+		err := b.rename(ctx, oldName, newName)
+		if err != errChanged {
+			return err
+		}
+	}
+}
+
+func (b *Pools) rename(ctx context.Context, oldName, newName string) error {
+	b.tree.RLock()
+	unlock := b.lock(oldName, newName)
+
+	from, info, err := b.locate(oldName)
+	if err != nil {
+		unlock()
+		b.tree.RUnlock()
+		return err
+	}
+
+	if info.IsDir {
+		unlock()
+		b.tree.RUnlock()
+		return b.renameDir(ctx, oldName, newName)
+	}
+
+	to := from
+
+	if pool := b.pinned(newName); pool >= 0 && pool != from || !b.writable(from) {
+		if to, err = b.place(newName, -1); err != nil {
+			unlock()
+			b.tree.RUnlock()
+			return err
+		}
+	}
+
+	if to == from {
+		defer b.tree.RUnlock()
+		defer unlock()
+
+		if err := b.pools[from].Rename(ctx, oldName, newName); err != nil {
+			return err
+		}
+
+		return b.removeOthers(from, newName)
+	}
+
+	unlock()
+	b.tree.RUnlock()
+
+	_, err = b.transfer(ctx, from, to, oldName, newName)
+
+	return err
+}
+
+// renameDir renames a directory on every pool holding it.
+func (b *Pools) renameDir(ctx context.Context, oldName, newName string) error {
+	b.tree.Lock()
+	defer b.tree.Unlock()
+
+	renamed := false
+
+	for i, p := range b.pools {
+		if !b.online(i) {
+			continue
+		}
+
+		if info, err := os.Stat(p.path(oldName)); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := p.Rename(ctx, oldName, newName); err != nil {
+			return err
+		}
+
+		renamed = true
+	}
+
+	if !renamed {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+// Copy clones the file when the copy is placed on the same pool, as FS does.
+func (b *Pools) Copy(ctx context.Context, src, dst string) error {
+	unlock := b.lock(src)
+
+	from, _, err := b.locate(src)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	in, err := os.Open(b.pools[from].path(src))
+	unlock()
+
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	held, _, _ := b.locate(dst)
+
+	to, err := b.place(dst, held)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := b.pools[to].writeTemp(func(f *os.File) error {
+		if err := cloneFile(f, in); err == nil {
+			return nil
+		}
+
+		_, err := io.Copy(f, withContext(ctx, in))
+		return err
+	})
+	if err != nil {
+		return storageError(err)
+	}
+
+	return b.commit(to, tmp, dst)
+}
+
+// Close flushes any pending writes, under batch durability.
+func (b *Pools) Close() error {
+	return b.syncer.close()
+}
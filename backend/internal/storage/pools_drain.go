@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataLabTechTV/labstore/backend/internal/config"
+	"github.com/DataLabTechTV/labstore/backend/internal/core"
+	"github.com/DataLabTechTV/labstore/backend/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Progress is saved after this many files are moved, so that resumed drains
+// report the files moved before a restart.
+const drainSaveInterval = 100
+
+func ErrorPoolDrainInProgress() *core.S3Error {
+	return &core.S3Error{
+		Code:       "PoolDrainInProgress",
+		Message:    "The storage pool is already being drained",
+		StatusCode: fiber.StatusConflict,
+	}
+}
+
+func ErrorNoSuchPoolDrain() *core.S3Error {
+	return &core.S3Error{
+		Code:       "NoSuchPoolDrain",
+		Message:    "The storage pool is not being drained",
+		StatusCode: fiber.StatusNotFound,
+	}
+}
+
+// PoolDrain tracks the files moved off a pool being drained. Pools stay
+// drained, taking no new files, once finished without an error.
+type PoolDrain struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Total      int64      `json:"total"`
+	Moved      int64      `json:"moved"`
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+type PoolStatus struct {
+	Index      int        `json:"index"`
+	Path       string     `json:"path"`
+	Online     bool       `json:"online"`
+	TotalBytes int64      `json:"totalBytes"`
+	FreeBytes  int64      `json:"freeBytes"`
+	Buckets    []string   `json:"buckets"`
+	Drain      *PoolDrain `json:"drain,omitempty"`
+}
+
+type PoolsStatus struct {
+	ReserveBytes int64         `json:"reserveBytes"`
+	Pools        []*PoolStatus `json:"pools"`
+}
+
+func (b *Pools) Status() *PoolsStatus {
+	status := &PoolsStatus{ReserveBytes: b.reserve}
+
+	for i := range b.roots {
+		status.Pools = append(status.Pools, b.poolStatus(i))
+	}
+
+	return status
+}
+
+func (b *Pools) poolStatus(pool int) *PoolStatus {
+	root := b.roots[pool]
+
+	status := &PoolStatus{Index: pool, Path: root, Online: b.online(pool), Buckets: []string{}}
+
+	if status.Online {
+		status.TotalBytes, status.FreeBytes, _ = diskSpace(root)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for bucket, pin := range b.pins {
+		if pin == root {
+			status.Buckets = append(status.Buckets, bucket)
+		}
+	}
+
+	sort.Strings(status.Buckets)
+
+	if d, ok := b.drains[root]; ok {
+		snapshot := *d
+		snapshot.cancel = nil
+		status.Drain = &snapshot
+	}
+
+	return status
+}
+
+func (b *Pools) checkPool(pool int) error {
+	if pool < 0 || pool >= len(b.roots) {
+		return core.ErrorInvalidArgument("Unknown storage pool")
+	}
+
+	return nil
+}
+
+// PoolPin is the pool new files of a bucket are placed on.
+type PoolPin struct {
+	Pool int    `json:"pool"`
+	Path string `json:"path"`
+}
+
+// Pinned returns the pool a bucket is pinned to, if any.
+func (b *Pools) Pinned(bucket string) (*PoolPin, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	root, ok := b.pins[bucket]
+	if !ok {
+		return nil, false
+	}
+
+	return &PoolPin{Pool: slices.Index(b.roots, root), Path: root}, true
+}
+
+// Pin places the new files of a bucket on a pool, which fails with
+// XMinioStorageFull once the pool is down to the reserve. Existing files are
+// only moved when rewritten.
+func (b *Pools) Pin(bucket string, pool int) (*PoolPin, error) {
+	if err := b.checkPool(pool); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	root := b.roots[pool]
+
+	if b.drains[root] != nil {
+		return nil, core.ErrorInvalidRequest("The storage pool is being drained")
+	}
+
+	// Names may come from request parameters, which fiber reuses, so they are
+	// cloned before being kept
+	b.pins[strings.Clone(bucket)] = root
+
+	if err := b.saveState(); err != nil {
+		return nil, err
+	}
+
+	return &PoolPin{Pool: pool, Path: root}, nil
+}
+
+// Unpin places the new files of a bucket like any other, which is also done
+// when the bucket is deleted.
+func (b *Pools) Unpin(bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.pins[bucket]; !ok {
+		return nil
+	}
+
+	delete(b.pins, bucket)
+
+	return b.saveState()
+}
+
+// Drain stops placing new files on a pool, and moves its files to the other
+// pools in the background, after which the pool can be removed. Buckets pinned
+// to the pool are unpinned.
+func (b *Pools) Drain(pool int) (*PoolStatus, error) {
+	if err := b.checkPool(pool); err != nil {
+		return nil, err
+	}
+
+	if !b.online(pool) {
+		return nil, core.ErrorInvalidRequest("The storage pool is offline")
+	}
+
+	b.mu.Lock()
+
+	root := b.roots[pool]
+
+	if d := b.drains[root]; d != nil && d.FinishedAt == nil {
+		b.mu.Unlock()
+		return nil, ErrorPoolDrainInProgress()
+	}
+
+	others := false
+
+	for i, other := range b.roots {
+		if i != pool && b.drains[other] == nil && b.online(i) {
+			others = true
+		}
+	}
+
+	if !others {
+		b.mu.Unlock()
+		return nil, core.ErrorInvalidRequest("There is no other storage pool to drain into")
+	}
+
+	for bucket, pin := range b.pins {
+		if pin == root {
+			logger.Log.Warnf("Unpinning bucket %s from drained pool %s", bucket, root)
+			delete(b.pins, bucket)
+		}
+	}
+
+	d := &PoolDrain{StartedAt: time.Now().UTC()}
+	b.drains[root] = d
+
+	if err := b.saveState(); err != nil {
+		delete(b.drains, root)
+		b.mu.Unlock()
+		return nil, err
+	}
+
+	b.start(pool, d)
+	b.mu.Unlock()
+
+	return b.poolStatus(pool), nil
+}
+
+// CancelDrain stops draining a pool, which takes new files again, while the
+// files already moved stay on the other pools.
+func (b *Pools) CancelDrain(pool int) error {
+	if err := b.checkPool(pool); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	root := b.roots[pool]
+
+	d, ok := b.drains[root]
+	if !ok {
+		return ErrorNoSuchPoolDrain()
+	}
+
+	if d.cancel != nil {
+		d.cancel()
+	}
+
+	delete(b.drains, root)
+
+	logger.Log.Infof("Cancelled draining pool %s", root)
+
+	return b.saveState()
+}
+
+// ResumeDrains restarts pool drains interrupted by a server shutdown.
+func ResumeDrains() {
+	b, ok := Default.(*Pools)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, root := range b.roots {
+		d, ok := b.drains[root]
+		if !ok || d.FinishedAt != nil {
+			continue
+		}
+
+		if !b.online(i) {
+			logger.Log.Warnf("Could not resume draining pool %s, which is offline", root)
+			continue
+		}
+
+		logger.Log.Infof("Resuming drain of pool %s", root)
+		b.start(i, d)
+	}
+}
+
+// start runs a drain in the background, with the state lock held.
+func (b *Pools) start(pool int, d *PoolDrain) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	go b.drain(ctx, pool, d)
+}
+
+func (b *Pools) drain(ctx context.Context, pool int, d *PoolDrain) {
+	root := b.roots[pool]
+
+	logger.Log.Infof("Draining pool %s", root)
+
+	err := b.drainPool(ctx, pool, d)
+
+	// Cancelled drains were already removed
+	if ctx.Err() != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	finishedAt := time.Now().UTC()
+	d.FinishedAt = &finishedAt
+
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	if err := b.saveState(); err != nil {
+		logger.Log.Errorf("Could not save drain of pool %s: %s", root, err)
+	}
+
+	if err != nil {
+		logger.Log.Errorf("Could not drain pool %s: %s", root, err)
+		return
+	}
+
+	logger.Log.Infof("Drained pool %s (%d files, %d bytes), which can now be removed", root, d.Moved, d.Bytes)
+}
+
+// drainPool moves every file off a pool, in passes, until none is left, and
+// writes that were already under way, in the staging area, are finished.
+// Directories are created on the other pools first, so that empty buckets are
+// kept, and removed from the pool last.
+func (b *Pools) drainPool(ctx context.Context, pool int, d *PoolDrain) error {
+	dirs, _, err := b.scanPool(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := b.Mkdir(ctx, dir); err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, files, err := b.scanPool(ctx, pool)
+		if err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		d.Total = d.Moved + int64(len(files))
+		b.mu.Unlock()
+
+		if len(files) == 0 {
+			if !b.staging(pool) {
+				break
+			}
+
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		for _, name := range files {
+			if err := b.moveOff(ctx, pool, name, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dir := range slices.Backward(dirs) {
+		os.Remove(b.pools[pool].path(dir))
+	}
+
+	return nil
+}
+
+// moveOff moves a file from a draining pool to the one with the most free
+// space. Files that changed, or were removed, are left to the next pass.
+func (b *Pools) moveOff(ctx context.Context, pool int, name string, d *PoolDrain) error {
+	to, err := b.place(name, -1)
+	if err != nil {
+		return err
+	}
+
+	n, err := b.transfer(ctx, pool, to, name, name)
+
+	switch {
+	case err == errChanged || IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d.Moved++
+	d.Bytes += n
+
+	if d.Moved%drainSaveInterval == 0 {
+		if err := b.saveState(); err != nil {
+			logger.Log.Errorf("Could not save drain of pool %s: %s", b.roots[pool], err)
+		}
+	}
+
+	return nil
+}
+
+// scanPool lists the directories and files on a single pool, skipping the
+// staging area, as sorted slash-separated names.
+func (b *Pools) scanPool(ctx context.Context, pool int) ([]string, []string, error) {
+	root := b.pools[pool].root
+	staging := config.SystemPath("tmp")
+
+	var dirs, files []string
+
+	err := filepath.WalkDir(root, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if p == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+
+		switch {
+		case name == staging:
+			return filepath.SkipDir
+		case e.IsDir():
+			dirs = append(dirs, name)
+		default:
+			files = append(files, name)
+		}
+
+		return nil
+	})
+
+	return dirs, files, err
+}
+
+// staging reports whether files are still being written to the staging area
+// of a pool, by writes placed on it before it was drained, which are told
+// apart from leftovers of interrupted writes by their age.
+func (b *Pools) staging(pool int) bool {
+	entries, err := os.ReadDir(b.pools[pool].path(config.SystemPath("tmp")))
+	if err != nil {
+		return false
+	}
+
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) < danglingAge {
+			return true
+		}
+	}
+
+	return false
+}
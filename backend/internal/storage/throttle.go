@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Throttle limits the rate of reads done in the background, in bytes per
+// second, so that they leave enough bandwidth for clients. A nil throttle does
+// not limit anything.
+type Throttle struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+// NewThrottle returns a throttle for a rate in bytes per second, or nil when
+// the rate is not positive.
+func NewThrottle(rate int64) *Throttle {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Throttle{rate: rate, start: time.Now()}
+}
+
+// Wait accounts for n bytes read, blocking until they are within the rate.
+func (t *Throttle) Wait(ctx context.Context, n int64) error {
+	if t == nil {
+		return ctx.Err()
+	}
+
+	t.bytes += n
+
+	delay := time.Duration(float64(t.bytes)/float64(t.rate)*float64(time.Second)) - time.Since(t.start)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader throttles reads from r.
+func (t *Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, t: t}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *Throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	if waitErr := r.t.Wait(r.ctx, int64(n)); waitErr != nil && err == nil {
+		err = waitErr
+	}
+
+	return n, err
+}
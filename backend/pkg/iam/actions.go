@@ -3,11 +3,34 @@ package iam
 type Action string
 
 const (
-	ListAllMyBuckets Action = "s3:ListAllMyBuckets"
-	CreateBucket     Action = "s3:CreateBucket"
-	DeleteBucket     Action = "s3:DeleteBucket"
-	ListBucket       Action = "s3:ListBucket"
-	PutObject        Action = "s3:PutObject"
-	GetObject        Action = "s3:GetObject"
-	DeleteObject     Action = "s3:DeleteObject"
+	ListAllMyBuckets                 Action = "s3:ListAllMyBuckets"
+	CreateBucket                     Action = "s3:CreateBucket"
+	DeleteBucket                     Action = "s3:DeleteBucket"
+	ListBucket                       Action = "s3:ListBucket"
+	GetBucketLocation                Action = "s3:GetBucketLocation"
+	GetBucketVersioning              Action = "s3:GetBucketVersioning"
+	PutBucketVersioning              Action = "s3:PutBucketVersioning"
+	ListBucketVersions               Action = "s3:ListBucketVersions"
+	GetBucketObjectLockConfiguration Action = "s3:GetBucketObjectLockConfiguration"
+	PutBucketObjectLockConfiguration Action = "s3:PutBucketObjectLockConfiguration"
+	GetBucketTagging                 Action = "s3:GetBucketTagging"
+	PutBucketTagging                 Action = "s3:PutBucketTagging"
+	GetEncryptionConfiguration       Action = "s3:GetEncryptionConfiguration"
+	PutEncryptionConfiguration       Action = "s3:PutEncryptionConfiguration"
+	PutObject                        Action = "s3:PutObject"
+	GetObject                        Action = "s3:GetObject"
+	DeleteObject                     Action = "s3:DeleteObject"
+	GetObjectVersion                 Action = "s3:GetObjectVersion"
+	DeleteObjectVersion              Action = "s3:DeleteObjectVersion"
+	GetObjectRetention               Action = "s3:GetObjectRetention"
+	PutObjectRetention               Action = "s3:PutObjectRetention"
+	GetObjectLegalHold               Action = "s3:GetObjectLegalHold"
+	PutObjectLegalHold               Action = "s3:PutObjectLegalHold"
+	BypassGovernanceRetention        Action = "s3:BypassGovernanceRetention"
+	GetObjectTagging                 Action = "s3:GetObjectTagging"
+	PutObjectTagging                 Action = "s3:PutObjectTagging"
+	DeleteObjectTagging              Action = "s3:DeleteObjectTagging"
+	GetObjectVersionTagging          Action = "s3:GetObjectVersionTagging"
+	PutObjectVersionTagging          Action = "s3:PutObjectVersionTagging"
+	DeleteObjectVersionTagging       Action = "s3:DeleteObjectVersionTagging"
 )
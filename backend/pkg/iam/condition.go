@@ -0,0 +1,135 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Condition operators supported in policy statements.
+const (
+	StringEquals    = "StringEquals"
+	StringNotEquals = "StringNotEquals"
+	StringLike      = "StringLike"
+	StringNotLike   = "StringNotLike"
+)
+
+// Condition maps operators to condition keys and the values they are compared
+// against, as in AWS IAM (e.g., StringEquals: {s3:ExistingObjectTag/team:
+// [data]}). Operators and keys must all hold, while any of the values of a key
+// can match.
+type Condition map[string]map[string]Values
+
+// Values are the values of a condition key, given either as a single string or
+// as a list.
+type Values []string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err == nil {
+		*v = Values{value}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(v))
+}
+
+func (v *Values) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = Values{node.Value}
+		return nil
+	}
+
+	return node.Decode((*[]string)(v))
+}
+
+// Defer marks the condition keys under a prefix as not known yet, such as the
+// existing tags of an object before it is read. Conditions on deferred keys are
+// assumed to allow, but not to deny, so the check must be repeated once the
+// keys are known.
+func (c Conditions) Defer(prefix string) Conditions {
+	c[deferredKey+prefix] = ""
+	return c
+}
+
+// Deferred keys are stored under a prefix that request values cannot produce.
+const deferredKey = "labstore:deferred:"
+
+func (c Conditions) deferred(key string) bool {
+	for k := range c {
+		if prefix, ok := strings.CutPrefix(k, deferredKey); ok && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// likePattern compiles a StringLike value, where * matches any sequence of
+// characters and ? a single character.
+func likePattern(value string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(value)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+
+	return regexp.MustCompile("(?s)^" + pattern + "$")
+}
+
+func matchOperator(op string) (match func(value, candidate string) bool, negated bool, err error) {
+	equals := func(value, candidate string) bool { return value == candidate }
+	like := func(value, candidate string) bool { return likePattern(value).MatchString(candidate) }
+
+	switch op {
+	case StringEquals:
+		return equals, false, nil
+	case StringNotEquals:
+		return equals, true, nil
+	case StringLike:
+		return like, false, nil
+	case StringNotLike:
+		return like, true, nil
+	}
+
+	return nil, false, fmt.Errorf("unsupported condition operator %s", op)
+}
+
+// holds evaluates the condition against the request, where assume is the
+// outcome for deferred keys. Negated operators hold when a key is missing.
+func (c Condition) holds(conditions Conditions, assume bool) (bool, error) {
+	for op, keys := range c {
+		match, negated, err := matchOperator(op)
+		if err != nil {
+			return false, err
+		}
+
+		for key, values := range keys {
+			if conditions.deferred(key) {
+				if !assume {
+					return false, nil
+				}
+
+				continue
+			}
+
+			candidate, ok := conditions[key]
+			matched := false
+
+			for _, value := range values {
+				if ok && match(value, candidate) {
+					matched = true
+					break
+				}
+			}
+
+			if matched == negated {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
@@ -2,10 +2,21 @@ package iam
 
 import "github.com/DataLabTechTV/labstore/backend/internal/config"
 
+// Condition key prefixes for object tags, followed by the tag key (e.g.,
+// s3:ExistingObjectTag/project).
+const (
+	ExistingObjectTag = "s3:ExistingObjectTag/"
+	RequestObjectTag  = "s3:RequestObjectTag/"
+)
+
 var Users map[string]string
 var Policies map[string]PolicyFunc
 
-type PolicyFunc func(userID string, resourceID string) bool
+// Conditions maps condition keys to the request values they are evaluated
+// against.
+type Conditions map[string]string
+
+type PolicyFunc func(userID string, resourceID string, conditions Conditions) bool
 
 func Load() {
 	Users = map[string]string{
@@ -13,15 +24,24 @@ func Load() {
 	}
 
 	Policies = map[string]PolicyFunc{
-		config.Env.AdminAccessKey: func(bucket, op string) bool {
+		config.Env.AdminAccessKey: func(bucket, op string, conditions Conditions) bool {
 			return true
 		},
 	}
 }
 
-func CheckPolicy(accessKey, bucket, op string) bool {
+// AddTags adds a condition key for each tag, under the given prefix.
+func (c Conditions) AddTags(prefix string, tags map[string]string) Conditions {
+	for k, v := range tags {
+		c[prefix+k] = v
+	}
+
+	return c
+}
+
+func CheckPolicy(accessKey, bucket, op string, conditions Conditions) bool {
 	if polFunc, ok := Policies[accessKey]; ok {
-		return polFunc(bucket, op)
+		return polFunc(bucket, op, conditions)
 	}
 	return false
 }
@@ -0,0 +1,78 @@
+package iam
+
+import "path"
+
+const (
+	Allow = "Allow"
+	Deny  = "Deny"
+)
+
+// Statement allows or denies actions on buckets. Actions and buckets are
+// matched as glob patterns (e.g., s3:Get*, or logs-*). The statement only
+// applies when its condition, if any, holds for the request.
+type Statement struct {
+	Effect    string    `json:"effect" yaml:"effect"`
+	Actions   []string  `json:"actions" yaml:"actions"`
+	Buckets   []string  `json:"buckets" yaml:"buckets"`
+	Condition Condition `json:"condition,omitempty" yaml:"condition,omitempty"`
+}
+
+type Policy struct {
+	Name       string      `json:"name" yaml:"name"`
+	Statements []Statement `json:"statements" yaml:"statements"`
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Statement) matches(bucket, op string) bool {
+	return matchAny(s.Actions, op) && matchAny(s.Buckets, bucket)
+}
+
+// PolicyFromStatements evaluates statements as in AWS IAM: access is denied
+// by default, unless allowed, and an explicit deny always wins. Conditions
+// with unsupported operators never allow, and always deny.
+func PolicyFromStatements(statements []Statement) PolicyFunc {
+	return func(bucket, op string, conditions Conditions) bool {
+		allowed := false
+
+		for _, s := range statements {
+			if !s.matches(bucket, op) {
+				continue
+			}
+
+			if s.Effect == Deny {
+				if holds, err := s.Condition.holds(conditions, false); holds || err != nil {
+					return false
+				}
+
+				continue
+			}
+
+			if holds, err := s.Condition.holds(conditions, true); holds && err == nil {
+				allowed = allowed || s.Effect == Allow
+			}
+		}
+
+		return allowed
+	}
+}
+
+// AddUser registers a user, along with the policies that apply to it.
+func AddUser(accessKey, secretKey string, policies []*Policy) {
+	var statements []Statement
+
+	for _, p := range policies {
+		statements = append(statements, p.Statements...)
+	}
+
+	Users[accessKey] = secretKey
+	Policies[accessKey] = PolicyFromStatements(statements)
+}
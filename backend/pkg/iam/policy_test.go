@@ -0,0 +1,175 @@
+package iam
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPolicyFromStatementsConditions(t *testing.T) {
+	policy := PolicyFromStatements([]Statement{
+		{
+			Effect:  Allow,
+			Actions: []string{"s3:GetObject"},
+			Buckets: []string{"data"},
+			Condition: Condition{
+				StringEquals: {ExistingObjectTag + "team": {"data", "ml"}},
+			},
+		},
+		{
+			Effect:  Allow,
+			Actions: []string{"s3:PutObject"},
+			Buckets: []string{"data"},
+			Condition: Condition{
+				StringLike: {RequestObjectTag + "project": {"lab-*"}},
+			},
+		},
+		{
+			Effect:  Deny,
+			Actions: []string{"s3:*"},
+			Buckets: []string{"data"},
+			Condition: Condition{
+				StringEquals: {ExistingObjectTag + "classification": {"secret"}},
+			},
+		},
+		{
+			Effect:  Deny,
+			Actions: []string{"s3:PutObject"},
+			Buckets: []string{"data"},
+			Condition: Condition{
+				StringLike: {RequestObjectTag + "project": {"lab-?-old"}},
+			},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		op         string
+		conditions Conditions
+		want       bool
+	}{
+		{"equals allows", "s3:GetObject", Conditions{ExistingObjectTag + "team": "ml"}, true},
+		{"equals does not allow other value", "s3:GetObject", Conditions{ExistingObjectTag + "team": "ops"}, false},
+		{"equals does not allow missing key", "s3:GetObject", Conditions{}, false},
+		{"equals denies", "s3:GetObject", Conditions{ExistingObjectTag + "team": "data", ExistingObjectTag + "classification": "secret"}, false},
+		{"like allows", "s3:PutObject", Conditions{RequestObjectTag + "project": "lab-store"}, true},
+		{"like does not allow other value", "s3:PutObject", Conditions{RequestObjectTag + "project": "store"}, false},
+		{"like denies", "s3:PutObject", Conditions{RequestObjectTag + "project": "lab-1-old"}, false},
+		{"like does not deny other value", "s3:PutObject", Conditions{RequestObjectTag + "project": "lab-12-old"}, true},
+		{"deferred key allows", "s3:GetObject", Conditions{}.Defer(ExistingObjectTag), true},
+		{"deferred key does not deny", "s3:PutObject", Conditions{RequestObjectTag + "project": "lab-x"}.Defer(ExistingObjectTag), true},
+		{"deferred key does not allow other keys", "s3:PutObject", Conditions{}.Defer(ExistingObjectTag), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy("data", tt.op, tt.conditions); got != tt.want {
+				t.Errorf("policy(%s) = %v, want %v", tt.op, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyFromStatementsNegatedConditions(t *testing.T) {
+	policy := PolicyFromStatements([]Statement{
+		{Effect: Allow, Actions: []string{"s3:GetObject"}, Buckets: []string{"*"}},
+		{
+			Effect:  Deny,
+			Actions: []string{"s3:GetObject"},
+			Buckets: []string{"*"},
+			Condition: Condition{
+				StringNotEquals: {ExistingObjectTag + "public": {"true"}},
+				StringNotLike:   {ExistingObjectTag + "owner": {"team-*"}},
+			},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		conditions Conditions
+		want       bool
+	}{
+		{"both negations hold", Conditions{}, false},
+		{"equals breaks negation", Conditions{ExistingObjectTag + "public": "true"}, true},
+		{"like breaks negation", Conditions{ExistingObjectTag + "owner": "team-a"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy("data", "s3:GetObject", tt.conditions); got != tt.want {
+				t.Errorf("policy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyFromStatementsUnsupportedOperator(t *testing.T) {
+	condition := Condition{"NumericLessThan": {ExistingObjectTag + "level": {"3"}}}
+
+	allow := PolicyFromStatements([]Statement{
+		{Effect: Allow, Actions: []string{"*"}, Buckets: []string{"*"}, Condition: condition},
+	})
+
+	if allow("data", "s3:GetObject", Conditions{}) {
+		t.Error("allow with unsupported operator granted access")
+	}
+
+	deny := PolicyFromStatements([]Statement{
+		{Effect: Allow, Actions: []string{"*"}, Buckets: []string{"*"}},
+		{Effect: Deny, Actions: []string{"*"}, Buckets: []string{"*"}, Condition: condition},
+	})
+
+	if deny("data", "s3:GetObject", Conditions{}) {
+		t.Error("deny with unsupported operator did not deny access")
+	}
+}
+
+func TestConditionDecode(t *testing.T) {
+	want := Condition{StringEquals: {"s3:ExistingObjectTag/team": {"data"}, "s3:RequestObjectTag/env": {"dev", "test"}}}
+
+	var fromYAML Statement
+
+	err := yaml.Unmarshal([]byte(`
+effect: Allow
+actions: ["s3:GetObject"]
+buckets: ["data"]
+condition:
+  StringEquals:
+    s3:ExistingObjectTag/team: data
+    s3:RequestObjectTag/env: [dev, test]
+`), &fromYAML)
+	if err != nil {
+		t.Fatalf("yaml: %v", err)
+	}
+
+	var fromJSON Statement
+
+	err = json.Unmarshal([]byte(`{
+		"effect": "Allow",
+		"actions": ["s3:GetObject"],
+		"buckets": ["data"],
+		"condition": {"StringEquals": {"s3:ExistingObjectTag/team": "data", "s3:RequestObjectTag/env": ["dev", "test"]}}
+	}`), &fromJSON)
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+
+	for name, s := range map[string]Statement{"yaml": fromYAML, "json": fromJSON} {
+		for op, keys := range want {
+			for key, values := range keys {
+				got := s.Condition[op][key]
+
+				if len(got) != len(values) {
+					t.Fatalf("%s: %s %s = %v, want %v", name, op, key, got, values)
+				}
+
+				for i := range values {
+					if got[i] != values[i] {
+						t.Errorf("%s: %s %s = %v, want %v", name, op, key, got, values)
+					}
+				}
+			}
+		}
+	}
+}